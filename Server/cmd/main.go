@@ -1,7 +1,161 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"Server/pkgs/api"
+	"Server/pkgs/artifact"
+	"Server/pkgs/config"
+	"Server/pkgs/database"
+	"Server/pkgs/logging"
+	"Server/pkgs/metrics"
+	"Server/pkgs/ratelimit"
+	"Server/pkgs/scheduler"
+	"Server/pkgs/validation"
+	"Server/pkgs/webhook"
+)
 
 func main() {
-	fmt.Println("Hello, my server")
+	cfg, loadErr := config.Load(config.DefaultPath)
+	if errors.Is(loadErr, os.ErrNotExist) {
+		cfg = config.Default()
+	} else if loadErr != nil {
+		// a broken file must not start the server without its auth settings
+		fatal("Failed to load config", loadErr)
+	}
+	logger, err := logging.New(cfg.Log, os.Stderr)
+	if err != nil {
+		fatal("Invalid log config", err)
+	}
+	// also routes the standard log package and gorm through the json handler
+	slog.SetDefault(logger)
+	if loadErr != nil {
+		slog.Warn("No config file, using defaults", "error", loadErr)
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		fatal("Failed to open database", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		fatal("Failed to migrate database", err)
+	}
+	if cfg.Database.SearchIndex {
+		if err := database.EnsureSearchIndex(db); err != nil {
+			fatal("Failed to create search index", err)
+		}
+	}
+
+	artifacts, err := artifact.Open(cfg.Artifact)
+	if err != nil {
+		fatal("Failed to open artifact store", err)
+	}
+	limits, err := scheduler.NewConcurrencyLimits(cfg.Concurrency)
+	if err != nil {
+		fatal("Invalid concurrency config", err)
+	}
+	taskTypes, err := scheduler.NewTaskTypes(cfg.TaskTypes)
+	if err != nil {
+		fatal("Invalid task type config", err)
+	}
+	sched := scheduler.New(db, scheduler.NewRetryPolicy(cfg.Retry),
+		scheduler.WithArtifactStore(artifacts),
+		scheduler.WithConcurrencyLimits(limits),
+		scheduler.WithTaskTypes(taskTypes),
+		scheduler.WithLease(cfg.Reaper.LeaseDuration()),
+		scheduler.WithLogLimit(cfg.TaskLogs.MaxBytes),
+		scheduler.WithStatsWindow(cfg.Workers.StatsWindowDuration()),
+	)
+	payloads, err := validation.New(cfg.Payload)
+	if err != nil {
+		fatal("Invalid payload config", err)
+	}
+	reaper := scheduler.NewReaper(sched, cfg.Reaper.IntervalDuration())
+	srv := api.New(sched, artifacts,
+		api.WithMaxBatchSize(cfg.Server.MaxBatchSize),
+		api.WithAdminKeys(cfg.Server.AdminKeys),
+		api.WithWorkerAuth(cfg.Server.RequireWorkerTokens),
+		api.WithRateLimiter(ratelimit.New(cfg.RateLimit)),
+		api.WithPayloadValidation(payloads),
+		api.WithReadyCheck("reaper", reaper.Check),
+	)
+
+	m := metrics.New()
+	counts, err := sched.CountByStatus(context.Background())
+	if err != nil {
+		fatal("Failed to count tasks", err)
+	}
+	m.Seed(counts)
+	sched.OnTransition(m.Observe)
+	srv.Handle("GET /metrics", m.Handler())
+
+	// the background loops get their own context so they outlive the http drain
+	background, stopBackground := context.WithCancel(context.Background())
+	var loops sync.WaitGroup
+
+	hooks := webhook.New(cfg.Webhook)
+	sched.OnTransition(hooks.Notify)
+	loops.Go(func() { hooks.Run(background) })
+
+	loops.Go(func() { reaper.Run(background) })
+
+	archiver := scheduler.NewArchiver(sched, cfg.Archive.IntervalDuration(), cfg.Archive.RetentionDuration())
+	loops.Go(func() { archiver.Run(background) })
+
+	if gc := cfg.Artifact.GC; gc.RetentionDays > 0 {
+		collector := scheduler.NewArtifactCollector(sched, gc.IntervalDuration(), gc.RetentionDuration(), gc.DryRun)
+		loops.Go(func() { collector.Run(background) })
+	}
+
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		fatal("Failed to listen", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Server listening", "addr", cfg.Server.Addr)
+	timeout := cfg.Server.ShutdownTimeoutDuration()
+	if err := srv.Serve(ctx, ln, timeout); err != nil {
+		if ctx.Err() == nil {
+			fatal("Server stopped", err)
+		}
+		slog.Warn("Cut in flight requests short", "error", err)
+	}
+	// a second signal kills the process right away
+	stop()
+	slog.Info("Stopped serving, shutting down")
+
+	stopBackground()
+	loops.Wait()
+	slog.Info("Stopped background loops")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// refuses the writes of requests cut short and waits for the ones already running
+	if err := sched.Close(shutdownCtx); err != nil {
+		fatal("Database writes still in progress, not closing the database", err)
+	}
+	hooks.Flush(shutdownCtx)
+	slog.Info("Flushed webhook deliveries")
+
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		fatal("Failed to close database", err)
+	}
+	slog.Info("Server stopped")
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
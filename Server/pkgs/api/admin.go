@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// WithAdminKeys lists the api keys allowed to call admin endpoints, without any they are disabled
+func WithAdminKeys(keys []string) Option {
+	return func(s *Server) {
+		s.adminKeys = append(s.adminKeys, keys...)
+	}
+}
+
+// adminOnly answers 401 without an api key and 403 unless it is an admin key
+func (s *Server) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			writeError(w, http.StatusUnauthorized, "admin api key required")
+			return
+		}
+		var admin bool
+		for _, k := range s.adminKeys {
+			// keep comparing after a match so timing does not tell which key it was
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				admin = true
+			}
+		}
+		if !admin {
+			writeError(w, http.StatusForbidden, "api key is not an admin key")
+			return
+		}
+		h(w, r)
+	}
+}
+
+type reassignRequest struct {
+	// never hand the task to its current worker again
+	ExcludeWorker bool   `json:"exclude_worker"`
+	Reason        string `json:"reason"`
+}
+
+// reassignTask takes a running task from a wedged worker so another one runs it, the body is optional
+func (s *Server) reassignTask(w http.ResponseWriter, r *http.Request) {
+	var req reassignRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+	}
+	task, err := s.sched.Reassign(r.Context(), r.PathValue("id"), req.ExcludeWorker, req.Reason)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Server/pkgs/model"
+)
+
+func TestReassignNeedsAnAdminKey(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	claim(srv, "worker-1", 0)
+
+	reassign := func(key string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(reassignRequest{ExcludeWorker: true})
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+created.ID+"/reassign", bytes.NewReader(body))
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := reassign(""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a key: expected 401, got %d", rec.Code)
+	}
+	if rec := reassign("someone-else"); rec.Code != http.StatusForbidden {
+		t.Fatalf("with a plain key: expected 403, got %d", rec.Code)
+	}
+	rec := reassign("admin-key")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reassign: %d %s", rec.Code, rec.Body)
+	}
+	if task := decode[model.Task](t, rec); task.Status != model.StatusPending || len(task.ExcludedWorkers) != 1 {
+		t.Fatalf("unexpected task %+v", task)
+	}
+	if rec := reassign("admin-key"); rec.Code != http.StatusConflict {
+		t.Fatalf("reassigning a pending task: expected 409, got %d", rec.Code)
+	}
+
+	// the report the old worker eventually sends is refused
+	rec = do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusSuccess})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("stale report: expected 409, got %d", rec.Code)
+	}
+}
+
+func TestOperatorEndpointsNeedAnAdminKey(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+
+	for _, target := range []string{
+		"/tasks/" + created.ID + "/cancel",
+		"/tasks/" + created.ID + "/requeue",
+		"/tasks/" + created.ID + "/rerun",
+		"/experiments",
+	} {
+		if rec := do(t, srv, http.MethodPost, target, map[string]any{"name": "kdump regression"}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a key: expected 401, got %d", target, rec.Code)
+		}
+		if rec := doWithHeader(t, srv, http.MethodPost, target, map[string]any{"name": "kdump regression"}, APIKeyHeader, "someone-else"); rec.Code != http.StatusForbidden {
+			t.Errorf("%s with a plain key: expected 403, got %d", target, rec.Code)
+		}
+	}
+	if task := decode[model.Task](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID, nil)); task.Status != model.StatusPending {
+		t.Fatalf("a refused cancel changed the task: %+v", task)
+	}
+}
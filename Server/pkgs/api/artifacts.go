@@ -0,0 +1,259 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/logging"
+	"Server/pkgs/model"
+)
+
+type uploadArtifactResponse struct {
+	Name string `json:"name"`
+	// size and sha256 of the uncompressed content, the worker reports these
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	StoredSize  int64  `json:"stored_size"`
+	Compression string `json:"compression,omitempty"`
+}
+
+// maxContentTypeLen matches the artifacts.content_type column
+const maxContentTypeLen = 128
+
+// uploadArtifact stores the raw request body as the primary artifact of a running task,
+// the worker then references it by name in its report
+func (s *Server) uploadArtifact(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.storeUpload(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusCreated, uploadArtifactResponse{
+		Name: a.Name, Size: a.Size, SHA256: a.SHA256, StoredSize: a.StoredSize, Compression: a.Compression,
+	})
+}
+
+// addArtifact stores one more file of a running task, e.g. a build log next to the
+// patched image. It is recorded right away, no report has to name it.
+func (s *Server) addArtifact(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.storeUpload(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// storeUpload checks an upload against the task, stores the body and records the artifact.
+// A gzip or zstd Content-Encoding is kept as is, the body is only decompressed to checksum it.
+// It answers the request itself when it fails.
+func (s *Server) storeUpload(w http.ResponseWriter, r *http.Request) (*model.Artifact, bool) {
+	q := r.URL.Query()
+	workerID, ok := boundWorker(w, r, q.Get("worker_id"))
+	if !ok {
+		return nil, false
+	}
+	name := q.Get("name")
+	if !artifact.ValidName(name) {
+		writeError(w, http.StatusBadRequest, artifact.ErrInvalidName.Error())
+		return nil, false
+	}
+	enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if enc == "identity" {
+		enc = ""
+	}
+	if !artifact.ValidEncoding(enc) {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content encoding %q, use gzip or zstd", enc))
+		return nil, false
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil || len(contentType) > maxContentTypeLen {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid content type %q", contentType))
+			return nil, false
+		}
+	}
+
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return nil, false
+	}
+	if task.Status != model.StatusRunning {
+		writeError(w, http.StatusConflict, "task is not running")
+		return nil, false
+	}
+	if task.WorkerID != workerID {
+		writeError(w, http.StatusForbidden, "task is not owned by this worker")
+		return nil, false
+	}
+	if task.Payload.DryRun() {
+		writeError(w, http.StatusBadRequest, "dry-run tasks must not produce an artifact")
+		return nil, false
+	}
+
+	key := artifact.EncodedKey(task.ID, name, enc)
+	up, err := artifact.PutEncoded(r.Context(), s.artifacts, key, r.Body, enc)
+	if errors.Is(err, artifact.ErrInvalidName) || errors.Is(err, artifact.ErrCorrupt) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return nil, false
+	}
+	// a re-upload in another encoding replaces the earlier one, the report must find exactly one
+	for _, other := range artifact.Encodings {
+		if other != enc {
+			s.artifacts.Delete(r.Context(), artifact.EncodedKey(task.ID, name, other))
+		}
+	}
+
+	a := &model.Artifact{
+		TaskID:      task.ID,
+		Name:        name,
+		Path:        key,
+		Size:        up.Size,
+		StoredSize:  up.StoredSize,
+		Compression: enc,
+		SHA256:      up.SHA256,
+		ContentType: contentType,
+	}
+	if err := s.sched.AddArtifact(r.Context(), workerID, a); err != nil {
+		writeSchedulerError(w, r, err)
+		return nil, false
+	}
+	return a, true
+}
+
+// listArtifacts answers every artifact of a task, the primary one included
+func (s *Server) listArtifacts(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	artifacts := task.Artifacts
+	if artifacts == nil {
+		artifacts = []model.Artifact{}
+	}
+	writeJSON(w, http.StatusOK, artifacts)
+}
+
+// downloadArtifact streams the primary artifact of a successful task
+func (s *Server) downloadArtifact(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	if task.ArtifactGCedAt != nil {
+		writeError(w, http.StatusGone, "artifact was deleted after the retention period")
+		return
+	}
+	a := task.PrimaryArtifact()
+	if task.Status != model.StatusSuccess || a == nil {
+		writeError(w, http.StatusNotFound, "task has no artifact")
+		return
+	}
+	s.serveArtifact(w, r, task, a)
+}
+
+// downloadNamedArtifact streams any artifact of a task by name, also while it runs
+// or after it failed since logs are most useful then
+func (s *Server) downloadNamedArtifact(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	name := r.PathValue("name")
+	for i := range task.Artifacts {
+		if task.Artifacts[i].Name == name {
+			s.serveArtifact(w, r, task, &task.Artifacts[i])
+			return
+		}
+	}
+	if task.ArtifactGCedAt != nil {
+		writeError(w, http.StatusGone, "artifacts were deleted after the retention period")
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("task has no artifact %q", name))
+}
+
+// serveArtifact streams a, range requests are honored so interrupted downloads of
+// large vmcores can resume
+func (s *Server) serveArtifact(w http.ResponseWriter, r *http.Request, task *model.Task, a *model.Artifact) {
+	f, info, err := s.artifacts.Get(r.Context(), a.Path)
+	if errors.Is(err, artifact.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "artifact is missing from the store")
+		return
+	}
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if enc := a.Compression; enc != "" {
+		w.Header().Set("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, enc) {
+			// decompress on the fly, ranges cannot be served without decoding from the start
+			zr, err := artifact.NewReader(f, enc)
+			if err != nil {
+				writeSchedulerError(w, r, err)
+				return
+			}
+			defer zr.Close()
+			if a.SHA256 != "" {
+				w.Header().Set("ETag", `"`+a.SHA256+`"`)
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+			w.WriteHeader(http.StatusOK)
+			if _, err := io.Copy(w, zr); err != nil {
+				slog.ErrorContext(r.Context(), "artifact: failed to stream decompressed artifact", append(logging.Task(task), "error", err)...)
+			}
+			return
+		}
+		w.Header().Set("Content-Encoding", enc)
+		if a.SHA256 != "" {
+			// another representation than the plain bytes, so another entity tag
+			w.Header().Set("ETag", `"`+a.SHA256+"-"+enc+`"`)
+		}
+	} else if a.SHA256 != "" {
+		w.Header().Set("ETag", `"`+a.SHA256+`"`)
+	}
+	// ServeContent takes care of Range and Content-Length and streams straight from the backend
+	http.ServeContent(w, r, a.Name, info.ModTime, f)
+}
+
+// acceptsEncoding reports whether the Accept-Encoding of r allows enc
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), enc) && strings.TrimSpace(coding) != "*" {
+				continue
+			}
+			// q=0 explicitly refuses the coding
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
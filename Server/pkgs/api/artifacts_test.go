@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Server/pkgs/model"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestArtifactUploadIsVerified(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+	content := "vmcore bytes"
+	sum := sha256.Sum256([]byte(content))
+
+	run := func() *model.Task {
+		rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+		created := decode[model.Task](t, rec)
+		task, err := srv.sched.Claim(ctx, "worker-1")
+		if err != nil || task == nil || task.ID != created.ID {
+			t.Fatalf("claim: %v %v", task, err)
+		}
+		req := httptest.NewRequest(http.MethodPut, "/tasks/"+task.ID+"/artifact?worker_id=worker-1&name=vmcore", strings.NewReader(content))
+		up := httptest.NewRecorder()
+		srv.ServeHTTP(up, req)
+		if up.Code != http.StatusCreated {
+			t.Fatalf("upload: %d %s", up.Code, up.Body)
+		}
+		return task
+	}
+
+	good := run()
+	rec := do(t, srv, http.MethodPost, "/tasks/"+good.ID+"/report", map[string]any{
+		"worker_id":       "worker-1",
+		"status":          model.StatusSuccess,
+		"artifact_name":   "vmcore",
+		"artifact_size":   len(content),
+		"artifact_sha256": hex.EncodeToString(sum[:]),
+	})
+	if got := decode[model.Task](t, rec); got.Status != model.StatusSuccess || got.ArtifactSHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("matching artifact: status=%s sha=%s", got.Status, got.ArtifactSHA256)
+	}
+
+	truncated := run()
+	rec = do(t, srv, http.MethodPost, "/tasks/"+truncated.ID+"/report", map[string]any{
+		"worker_id":       "worker-1",
+		"status":          model.StatusSuccess,
+		"artifact_name":   "vmcore",
+		"artifact_size":   len(content) + 1,
+		"artifact_sha256": hex.EncodeToString(sum[:]),
+	})
+	got := decode[model.Task](t, rec)
+	if got.Status != model.StatusFailed || !strings.Contains(got.Result, "mismatch") {
+		t.Fatalf("mismatched artifact: status=%s result=%q", got.Status, got.Result)
+	}
+}
+
+func TestDryRunRefusesArtifacts(t *testing.T) {
+	srv := newTestServer(t)
+	body := taskBody(model.TaskTypePatchApply, nil)
+	body["payload"].(model.TaskPayload).PatchApply.Mode = model.ApplyModeDryRun
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", body))
+	if _, err := srv.sched.Claim(context.Background(), "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/tasks/"+created.ID+"/artifact?worker_id=worker-1&name=image", strings.NewReader("bzImage"))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("dry-run upload: expected 400, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/tasks/"+created.ID+"/artifacts?worker_id=worker-1&name=build.log", strings.NewReader("applying"))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("dry-run extra artifact: expected 400, got %d", rec.Code)
+	}
+	if got := decode[[]model.Artifact](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifacts", nil)); len(got) != 0 {
+		t.Fatalf("dry-run task should have no artifacts, got %+v", got)
+	}
+
+	rec = do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{
+		"worker_id": "worker-1",
+		"status":    model.StatusSuccess,
+		"result":    "0001-fix.patch would apply cleanly",
+	})
+	if got := decode[model.Task](t, rec); got.Status != model.StatusSuccess || got.ArtifactPath != "" {
+		t.Fatalf("dry-run report: status=%s artifact=%q", got.Status, got.ArtifactPath)
+	}
+}
+
+func TestArtifactDownload(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+	content := "0123456789vmcore"
+	sum := sha256.Sum256([]byte(content))
+
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	if _, err := srv.sched.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if rec := do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifact", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("running task: expected 404, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/tasks/"+created.ID+"/artifact?worker_id=worker-1&name=vmcore.img", strings.NewReader(content))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{
+		"worker_id":       "worker-1",
+		"status":          model.StatusSuccess,
+		"artifact_name":   "vmcore.img",
+		"artifact_size":   len(content),
+		"artifact_sha256": hex.EncodeToString(sum[:]),
+	})
+
+	rec := do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifact", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != content {
+		t.Fatalf("download: %d %q", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=vmcore.img` {
+		t.Fatalf("unexpected Content-Disposition %q", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "16" {
+		t.Fatalf("unexpected Content-Length %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/tasks/"+created.ID+"/artifact", nil)
+	req.Header.Set("Range", "bytes=10-")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "vmcore" {
+		t.Fatalf("ranged download: %d %q", rec.Code, rec.Body)
+	}
+
+	if gc, err := srv.sched.CollectArtifacts(ctx, 0, false); err != nil || gc.Files != 1 {
+		t.Fatalf("collect: %+v %v", gc, err)
+	}
+	for _, target := range []string{"/artifact", "/artifacts/vmcore.img"} {
+		if rec := do(t, srv, http.MethodGet, "/tasks/"+created.ID+target, nil); rec.Code != http.StatusGone {
+			t.Fatalf("download of %s after gc: expected 410, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestCompressedArtifact(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+	content := strings.Repeat("vmcore page ", 1000)
+	sum := sha256.Sum256([]byte(content))
+
+	compress := map[string]func() []byte{
+		"gzip": func() []byte {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte(content))
+			zw.Close()
+			return buf.Bytes()
+		},
+		"zstd": func() []byte {
+			zw, _ := zstd.NewWriter(nil)
+			return zw.EncodeAll([]byte(content), nil)
+		},
+	}
+	for enc, encode := range compress {
+		compressed := encode()
+		created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+		if _, err := srv.sched.Claim(ctx, "worker-1"); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		upload := func(enc string, body []byte) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPut, "/tasks/"+created.ID+"/artifact?worker_id=worker-1&name=vmcore", bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", enc)
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			return rec
+		}
+		if rec := upload("br", compressed); rec.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("brotli upload: expected 415, got %d", rec.Code)
+		}
+		if rec := upload(enc, compressed[:len(compressed)/2]); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: truncated upload: expected 400, got %d %s", enc, rec.Code, rec.Body)
+		}
+		rec := upload(enc, compressed)
+		up := decode[uploadArtifactResponse](t, rec)
+		if rec.Code != http.StatusCreated || up.Size != int64(len(content)) || up.SHA256 != hex.EncodeToString(sum[:]) || up.StoredSize != int64(len(compressed)) {
+			t.Fatalf("%s upload: %d %+v", enc, rec.Code, up)
+		}
+
+		// the checksum the worker reports is the one of the uncompressed content
+		task := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{
+			"worker_id":       "worker-1",
+			"status":          model.StatusSuccess,
+			"artifact_name":   "vmcore",
+			"artifact_size":   len(content),
+			"artifact_sha256": hex.EncodeToString(sum[:]),
+		}))
+		if task.Status != model.StatusSuccess || task.ArtifactCompression != enc || task.ArtifactStoredSize != int64(len(compressed)) || task.ArtifactSize != int64(len(content)) {
+			t.Fatalf("%s report: %+v", enc, task)
+		}
+
+		rec = do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifact", nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != content || rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s: plain download: %d %q", enc, rec.Code, rec.Header().Get("Content-Encoding"))
+		}
+		req := httptest.NewRequest(http.MethodGet, "/tasks/"+created.ID+"/artifact", nil)
+		req.Header.Set("Accept-Encoding", "br, "+enc)
+		rec = httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), compressed) || rec.Header().Get("Content-Encoding") != enc {
+			t.Fatalf("%s: encoded download: %d %q", enc, rec.Code, rec.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestMultipleArtifacts(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)))
+	if _, err := srv.sched.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	upload := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/tasks/"+created.ID+path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := upload(http.MethodPost, "/artifacts?worker_id=worker-2&name=build.log", "", "x"); rec.Code != http.StatusForbidden {
+		t.Fatalf("upload by another worker: expected 403, got %d", rec.Code)
+	}
+	rec := upload(http.MethodPost, "/artifacts?worker_id=worker-1&name=build.log", "text/plain; charset=utf-8", "CC kernel/panic.o\n")
+	if got := decode[model.Artifact](t, rec); rec.Code != http.StatusCreated || got.Size != 18 || got.Primary || got.Attempt != 1 {
+		t.Fatalf("upload build log: %d %+v", rec.Code, got)
+	}
+	if rec := upload(http.MethodPost, "/artifacts?worker_id=worker-1&name=report.diff", "text/x-diff", "--- a\n+++ b\n"); rec.Code != http.StatusCreated {
+		t.Fatalf("upload diff report: %d %s", rec.Code, rec.Body)
+	}
+	image := "bzImage"
+	sum := sha256.Sum256([]byte(image))
+	if rec := upload(http.MethodPut, "/artifact?worker_id=worker-1&name=bzImage", "", image); rec.Code != http.StatusCreated {
+		t.Fatalf("upload image: %d %s", rec.Code, rec.Body)
+	}
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{
+		"worker_id":       "worker-1",
+		"status":          model.StatusSuccess,
+		"artifact_name":   "bzImage",
+		"artifact_size":   len(image),
+		"artifact_sha256": hex.EncodeToString(sum[:]),
+	})
+	if rec := upload(http.MethodPost, "/artifacts?worker_id=worker-1&name=late.log", "", "x"); rec.Code != http.StatusConflict {
+		t.Fatalf("upload after the task finished: expected 409, got %d", rec.Code)
+	}
+
+	list := decode[[]model.Artifact](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifacts", nil))
+	if len(list) != 3 || list[0].Name != "build.log" || list[2].Name != "bzImage" || !list[2].Primary || list[0].Primary {
+		t.Fatalf("artifacts: %+v", list)
+	}
+	task := decode[model.Task](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID, nil))
+	if task.ArtifactName != "bzImage" || len(task.Artifacts) != 3 {
+		t.Fatalf("task: artifact_name=%q artifacts=%d", task.ArtifactName, len(task.Artifacts))
+	}
+
+	rec = do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifacts/build.log", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "CC kernel/panic.o\n" || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("download build log: %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	if rec := do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifact", nil); rec.Code != http.StatusOK || rec.Body.String() != image {
+		t.Fatalf("download primary artifact: %d %q", rec.Code, rec.Body)
+	}
+	if rec := do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/artifacts/missing", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown artifact: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"Server/pkgs/scheduler"
+)
+
+// clientActor names the caller in the audit log, api keys are fingerprinted so the
+// log never holds a usable secret
+func clientActor(r *http.Request) string {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// asWorker attributes the changes of a worker endpoint to the worker itself
+func asWorker(ctx context.Context, workerID string) context.Context {
+	return scheduler.WithActor(ctx, "worker:"+workerID)
+}
+
+func (s *Server) taskAudit(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	events, err := s.sched.AuditLog(r.Context(), task.ID)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
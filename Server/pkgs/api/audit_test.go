@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Server/pkgs/model"
+)
+
+func TestTaskAuditLog(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+
+	body, _ := json.Marshal(taskBody(model.TaskTypeGetVmcore, map[string]any{"max_attempts": 2}))
+	req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+	req.Header.Set(APIKeyHeader, "secret-key")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	created := decode[model.Task](t, rec)
+
+	claim(srv, "worker-1", 0)
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusFailed, "result": "ssh refused"})
+	claim(srv, "worker-2", 0)
+	cancel := httptest.NewRequest(http.MethodPost, "/tasks/"+created.ID+"/cancel", nil)
+	cancel.Header.Set(APIKeyHeader, "admin-key")
+	srv.ServeHTTP(httptest.NewRecorder(), cancel)
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-2", "status": model.StatusCancelled})
+
+	events := decode[[]model.AuditEvent](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID+"/audit", nil))
+	want := []struct {
+		action model.AuditAction
+		actor  string
+	}{
+		{model.AuditCreated, clientActor(req)},
+		{model.AuditClaimed, "worker:worker-1"},
+		{model.AuditRetried, "worker:worker-1"},
+		{model.AuditClaimed, "worker:worker-2"},
+		{model.AuditCancelRequested, clientActor(cancel)},
+		{model.AuditFinished, "worker:worker-2"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		if events[i].Action != w.action || events[i].Actor != w.actor {
+			t.Errorf("event %d: got %s by %s, want %s by %s", i, events[i].Action, events[i].Actor, w.action, w.actor)
+		}
+	}
+	if events[0].Actor == "secret-key" || events[2].Details["reason"] != "ssh refused" || events[5].Details["status"] != string(model.StatusCancelled) {
+		t.Fatalf("unexpected event details %+v", events)
+	}
+
+	// a pending task is cancelled right away, audited as such with who asked for it
+	pending := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	req = httptest.NewRequest(http.MethodPost, "/tasks/"+pending.ID+"/cancel", nil)
+	req.Header.Set(APIKeyHeader, "admin-key")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	events = decode[[]model.AuditEvent](t, do(t, srv, http.MethodGet, "/tasks/"+pending.ID+"/audit", nil))
+	if len(events) != 2 || events[1].Action != model.AuditCancelled || events[1].Actor != clientActor(req) {
+		t.Fatalf("pending cancel: unexpected events %+v", events)
+	}
+
+	if rec := do(t, srv, http.MethodGet, "/tasks/6f1c1a52-3c53-4a43-9c43-54d8a1c8a0ff/audit", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown task: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+// WithWorkerAuth makes the worker endpoints require an Authorization: Bearer token issued
+// by POST /workers/register, the request then acts as the worker the token belongs to
+func WithWorkerAuth(required bool) Option {
+	return func(s *Server) {
+		s.workerTokens = required
+	}
+}
+
+type workerKey struct{}
+
+// authenticatedWorker is the worker the request's token belongs to, empty without worker auth
+func authenticatedWorker(ctx context.Context) string {
+	id, _ := ctx.Value(workerKey{}).(string)
+	return id
+}
+
+// workerOnly answers 401 unless the request carries a valid worker token
+func (s *Server) workerOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.workerTokens {
+			h(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "worker token required")
+			return
+		}
+		workerID, err := s.sched.AuthenticateWorker(r.Context(), token)
+		if errors.Is(err, scheduler.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			writeSchedulerError(w, r, err)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), workerKey{}, workerID)))
+	}
+}
+
+// boundWorker checks the worker id a request names against its token, an omitted id
+// is taken from the token. It answers the request itself when they disagree.
+func boundWorker(w http.ResponseWriter, r *http.Request, claimed string) (string, bool) {
+	authenticated := authenticatedWorker(r.Context())
+	switch {
+	case authenticated == "":
+		return claimed, true
+	case claimed == "":
+		return authenticated, true
+	case claimed != authenticated:
+		writeError(w, http.StatusForbidden, "worker token belongs to another worker")
+		return "", false
+	}
+	return claimed, true
+}
+
+// maxWorkerIDLen matches the workers.id column
+const maxWorkerIDLen = 64
+
+type registerWorkerRequest struct {
+	WorkerID     string   `json:"worker_id"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type registerWorkerResponse struct {
+	Worker *model.Worker `json:"worker"`
+	// shown once, the server only keeps its hash
+	Token string `json:"token"`
+}
+
+// registerWorker issues a token for a worker, a worker may hold several e.g. while rotating
+func (s *Server) registerWorker(w http.ResponseWriter, r *http.Request) {
+	var req registerWorkerRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.WorkerID == "" || len(req.WorkerID) > maxWorkerIDLen {
+		writeError(w, http.StatusBadRequest, "worker_id is required and at most 64 characters")
+		return
+	}
+	token, worker, err := s.sched.IssueToken(r.Context(), req.WorkerID, req.Capabilities)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, registerWorkerResponse{Worker: worker, Token: token})
+}
+
+type revokeTokensResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+func (s *Server) revokeWorkerTokens(w http.ResponseWriter, r *http.Request) {
+	n, err := s.sched.RevokeTokens(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revokeTokensResponse{Revoked: n})
+}
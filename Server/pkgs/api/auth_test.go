@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"Server/pkgs/model"
+)
+
+func TestWorkerTokens(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	WithWorkerAuth(true)(srv)
+
+	register := func(workerID string) string {
+		t.Helper()
+		rec := doWithHeader(t, srv, http.MethodPost, "/workers/register", registerWorkerRequest{WorkerID: workerID}, APIKeyHeader, "admin-key")
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("register %s: %d %s", workerID, rec.Code, rec.Body)
+		}
+		resp := decode[registerWorkerResponse](t, rec)
+		if resp.Token == "" || resp.Worker.ID != workerID {
+			t.Fatalf("unexpected registration %+v", resp)
+		}
+		return "Bearer " + resp.Token
+	}
+	if rec := do(t, srv, http.MethodPost, "/workers/register", registerWorkerRequest{WorkerID: "worker-1"}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("register without an admin key: expected 401, got %d", rec.Code)
+	}
+	worker1, worker2 := register("worker-1"), register("worker-2")
+
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	claimBody := map[string]any{"wait_seconds": 0}
+	if rec := do(t, srv, http.MethodPost, "/tasks/claim", claimBody); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("claim without a token: expected 401, got %d", rec.Code)
+	}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/claim", claimBody, "Authorization", "Bearer guessed"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("claim with an unknown token: expected 401, got %d", rec.Code)
+	}
+	impersonate := map[string]any{"worker_id": "worker-1", "wait_seconds": 0}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/claim", impersonate, "Authorization", worker2); rec.Code != http.StatusForbidden {
+		t.Fatalf("claim as another worker: expected 403, got %d", rec.Code)
+	}
+	rec := doWithHeader(t, srv, http.MethodPost, "/tasks/claim", claimBody, "Authorization", worker1)
+	if rec.Code != http.StatusOK || decode[model.Task](t, rec).WorkerID != "worker-1" {
+		t.Fatalf("claim with a token: %d %s", rec.Code, rec.Body)
+	}
+
+	// worker-2 knows the task id but holds no claim on it, whatever worker_id it sends
+	for _, body := range []map[string]any{
+		{"status": model.StatusSuccess},
+		{"worker_id": "worker-2", "status": model.StatusSuccess},
+		{"worker_id": "worker-1", "status": model.StatusSuccess},
+	} {
+		if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", body, "Authorization", worker2); rec.Code != http.StatusForbidden {
+			t.Fatalf("cross-worker report %v: expected 403, got %d", body, rec.Code)
+		}
+	}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/renew", map[string]any{}, "Authorization", worker2); rec.Code != http.StatusForbidden {
+		t.Fatalf("cross-worker renew: expected 403, got %d", rec.Code)
+	}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/workers/worker-1/heartbeat", nil, "Authorization", worker2); rec.Code != http.StatusForbidden {
+		t.Fatalf("heartbeat for another worker: expected 403, got %d", rec.Code)
+	}
+	if rec := do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/renew", map[string]any{"worker_id": "worker-1"}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("renew without a token: expected 401, got %d", rec.Code)
+	}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/renew", map[string]any{}, "Authorization", worker1); rec.Code != http.StatusOK {
+		t.Fatalf("renew: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := doWithHeader(t, srv, http.MethodDelete, "/workers/worker-1/tokens", nil, APIKeyHeader, "admin-key"); rec.Code != http.StatusOK ||
+		decode[revokeTokensResponse](t, rec).Revoked != 1 {
+		t.Fatalf("revoke: %d", rec.Code)
+	}
+	report := map[string]any{"status": model.StatusSuccess}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", report, "Authorization", worker1); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("report with a revoked token: expected 401, got %d", rec.Code)
+	}
+	worker1 = register("worker-1")
+	rec = doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", report, "Authorization", worker1)
+	if rec.Code != http.StatusOK || decode[model.Task](t, rec).Status != model.StatusSuccess {
+		t.Fatalf("report with a new token: %d %s", rec.Code, rec.Body)
+	}
+}
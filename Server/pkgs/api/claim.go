@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+const (
+	defaultClaimWait = 30 * time.Second
+	maxClaimWait     = 60 * time.Second
+	// retry delays expire without any transition, so waiters also look again on their own
+	claimPollInterval = time.Second
+)
+
+type claimRequest struct {
+	WorkerID string `json:"worker_id"`
+	// omitted capabilities keep whatever the worker announced before
+	Capabilities []string `json:"capabilities"`
+	// how long to wait for work, 0 returns immediately, omitted uses the default
+	WaitSeconds *int `json:"wait_seconds"`
+}
+
+func (req claimRequest) wait() (time.Duration, error) {
+	if req.WaitSeconds == nil {
+		return defaultClaimWait, nil
+	}
+	if *req.WaitSeconds < 0 {
+		return 0, fmt.Errorf("wait_seconds must not be negative")
+	}
+	return min(time.Duration(*req.WaitSeconds)*time.Second, maxClaimWait), nil
+}
+
+// wakeup lets claims blocked in a long-poll know that a task became pending
+type wakeup struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newWakeup() *wakeup {
+	return &wakeup{ch: make(chan struct{})}
+}
+
+func (w *wakeup) wait() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ch
+}
+
+func (w *wakeup) notify(t scheduler.Transition) {
+	if t.To != model.StatusPending {
+		return
+	}
+	w.broadcast()
+}
+
+// broadcast wakes everyone waiting right now
+func (w *wakeup) broadcast() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.ch)
+	w.ch = make(chan struct{})
+}
+
+// claimTask hands the worker its next task, waiting up to wait_seconds for one to show up
+func (s *Server) claimTask(w http.ResponseWriter, r *http.Request) {
+	var req claimRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	var ok bool
+	if req.WorkerID, ok = boundWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+	if req.WorkerID == "" {
+		writeError(w, http.StatusBadRequest, "worker_id is required")
+		return
+	}
+	wait, err := req.wait()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx := asWorker(r.Context(), req.WorkerID)
+	if _, err := s.sched.Heartbeat(ctx, req.WorkerID, req.Capabilities); err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	poll := time.NewTicker(claimPollInterval)
+	defer poll.Stop()
+	for {
+		// subscribe before looking so a task submitted in between is not missed
+		woken := s.wakeup.wait()
+		task, err := s.sched.Claim(ctx, req.WorkerID)
+		if err != nil {
+			writeSchedulerError(w, r, err)
+			return
+		}
+		if task != nil {
+			writeJSON(w, http.StatusOK, task)
+			return
+		}
+		select {
+		case <-woken:
+		case <-poll.C:
+		case <-deadline.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		// the worker polls again, nothing was claimed
+		case <-s.closing:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
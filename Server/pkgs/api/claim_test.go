@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+func claim(srv *Server, workerID string, wait int) (*httptest.ResponseRecorder, error) {
+	body, err := json.Marshal(map[string]any{"worker_id": workerID, "wait_seconds": wait})
+	if err != nil {
+		return nil, err
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/claim", bytes.NewReader(body)))
+	return rec, nil
+}
+
+func TestConcurrentClaimsNeverShareATask(t *testing.T) {
+	srv := newTestServer(t)
+	const tasks, workers = 20, 5
+	for range tasks {
+		if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)); rec.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	var mu sync.Mutex
+	claimedBy := make(map[string]string)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			for {
+				rec, err := claim(srv, workerID, 0)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if rec.Code == http.StatusNoContent {
+					return
+				}
+				var task model.Task
+				if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &task) != nil {
+					errs <- fmt.Errorf("claim: %d %s", rec.Code, rec.Body)
+					return
+				}
+				if task.Status != model.StatusRunning || task.WorkerID != workerID || task.StartedAt == nil {
+					errs <- fmt.Errorf("claimed task not stamped: %+v", task)
+					return
+				}
+				mu.Lock()
+				if other, ok := claimedBy[task.ID]; ok {
+					errs <- fmt.Errorf("task %s claimed by %s and %s", task.ID, other, workerID)
+				}
+				claimedBy[task.ID] = workerID
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("worker-%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(claimedBy) != tasks {
+		t.Fatalf("expected %d claimed tasks, got %d", tasks, len(claimedBy))
+	}
+}
+
+func TestClaimLongPoll(t *testing.T) {
+	srv := newTestServer(t)
+
+	start := time.Now()
+	rec, _ := claim(srv, "worker-1", 1)
+	if rec.Code != http.StatusNoContent || time.Since(start) < time.Second {
+		t.Fatalf("empty queue: expected 204 after the wait, got %d after %s", rec.Code, time.Since(start))
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec, _ := claim(srv, "worker-1", 10)
+		done <- rec
+	}()
+	time.Sleep(100 * time.Millisecond)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+
+	select {
+	case rec := <-done:
+		if got := decode[model.Task](t, rec); got.ID != created.ID {
+			t.Fatalf("long-poll returned %q, want %q", got.ID, created.ID)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("long-poll was not woken up by the new task")
+	}
+}
+
+func TestClaimCarriesEnv(t *testing.T) {
+	srv := newTestServer(t)
+	env := map[string]string{"DEBUGINFO_DIR": "/srv/debuginfo/6.1.0-13", "KDUMP_LEVEL": "31"}
+	if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, map[string]any{"env": env})); rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+
+	rec, _ := claim(srv, "worker-1", 0)
+	got := decode[model.Task](t, rec)
+	if len(got.Env) != 2 || got.Env["DEBUGINFO_DIR"] != env["DEBUGINFO_DIR"] {
+		t.Fatalf("claim response env = %v", got.Env)
+	}
+
+	for _, bad := range []map[string]string{
+		{"debug_dir": "/tmp"},
+		{"1ST": "x"},
+		{"LD-PRELOAD": "x"},
+		{"BIG": strings.Repeat("x", maxEnvBytes)},
+	} {
+		if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, map[string]any{"env": bad})); rec.Code != http.StatusBadRequest {
+			t.Errorf("env %.20v: expected 400, got %d", bad, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"Server/pkgs/model"
+
+	"github.com/google/uuid"
+)
+
+const maxExperimentNameLen = 128
+
+type createExperimentRequest struct {
+	// optional client-chosen id
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (req createExperimentRequest) validate() error {
+	if req.ID != "" {
+		if err := uuid.Validate(req.ID); err != nil {
+			return fmt.Errorf("invalid experiment id %q", req.ID)
+		}
+	}
+	if req.Name == "" || len(req.Name) > maxExperimentNameLen {
+		return fmt.Errorf("name must be between 1 and %d bytes", maxExperimentNameLen)
+	}
+	return nil
+}
+
+func (s *Server) createExperiment(w http.ResponseWriter, r *http.Request) {
+	var req createExperimentRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e := &model.Experiment{ID: req.ID, Name: req.Name, Description: req.Description}
+	if err := s.sched.CreateExperiment(r.Context(), e); err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func (s *Server) getExperiment(w http.ResponseWriter, r *http.Request) {
+	e, err := s.sched.GetExperiment(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"Server/pkgs/model"
+)
+
+func TestExperimentGroupsTasks(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	rec := doWithHeader(t, srv, http.MethodPost, "/experiments", map[string]any{"name": "kdump regression"}, APIKeyHeader, "admin-key")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create experiment: %d %s", rec.Code, rec.Body)
+	}
+	exp := decode[model.Experiment](t, rec)
+
+	for _, taskType := range []model.TaskType{model.TaskTypeGetVmcore, model.TaskTypePatchApply} {
+		if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(taskType, map[string]any{"experiment_id": exp.ID})); rec.Code != http.StatusCreated {
+			t.Fatalf("create task: %d %s", rec.Code, rec.Body)
+		}
+	}
+	do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, map[string]any{"experiment_id": "6f1c1a52-3c53-4a43-9c43-54d8a1c8a0ff"})); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown experiment: expected 400, got %d", rec.Code)
+	}
+
+	tasks := decode[[]model.Task](t, do(t, srv, http.MethodGet, "/tasks?experiment_id="+exp.ID, nil))
+	if len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks of the experiment, got %d", len(tasks))
+	}
+
+	got := decode[model.Experiment](t, do(t, srv, http.MethodGet, "/experiments/"+exp.ID, nil))
+	if got.Name != "kdump regression" || got.Status != model.StatusPending || got.TaskCounts[model.StatusPending] != 2 {
+		t.Fatalf("unexpected experiment %+v", got)
+	}
+
+	claim(srv, "worker-1", 0)
+	if got := decode[model.Experiment](t, do(t, srv, http.MethodGet, "/experiments/"+exp.ID, nil)); got.Status != model.StatusRunning {
+		t.Fatalf("expected a running experiment, got %s", got.Status)
+	}
+	if rec := do(t, srv, http.MethodGet, "/experiments/6f1c1a52-3c53-4a43-9c43-54d8a1c8a0ff", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown experiment: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// readyTimeout bounds the readiness probe so a hung database cannot hang it
+const readyTimeout = 2 * time.Second
+
+// ReadyCheck reports whether a subsystem the server depends on is working
+type ReadyCheck func(ctx context.Context) error
+
+type readyResponse struct {
+	Status       string            `json:"status"`
+	Checks       map[string]string `json:"checks"`
+	PendingTasks *int64            `json:"pending_tasks,omitempty"`
+	Workers      *int64            `json:"workers,omitempty"`
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	resp := readyResponse{Status: "ok", Checks: map[string]string{}}
+	fail := func(name string, err error) {
+		resp.Status = "unavailable"
+		resp.Checks[name] = err.Error()
+	}
+
+	if err := s.sched.Ping(ctx); err != nil {
+		fail("database", err)
+	} else {
+		resp.Checks["database"] = "ok"
+		if n, err := s.sched.CountPending(ctx); err != nil {
+			fail("database", err)
+		} else {
+			resp.PendingTasks = &n
+		}
+		if n, err := s.sched.CountWorkers(ctx); err != nil {
+			fail("database", err)
+		} else {
+			resp.Workers = &n
+		}
+	}
+	for name, check := range s.readyChecks {
+		if err := check(ctx); err != nil {
+			fail(name, err)
+		} else {
+			resp.Checks[name] = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	db := newTestDB(t)
+	srv := New(scheduler.New(db, scheduler.RetryPolicy{MaxAttempts: 1}), artifact.NewMemory())
+	if rec := do(t, srv, http.MethodGet, "/healthz", nil); rec.Code != http.StatusOK {
+		t.Fatalf("healthz: %d", rec.Code)
+	}
+
+	do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	do(t, srv, http.MethodPost, "/workers/worker-1/heartbeat", nil)
+	rec := do(t, srv, http.MethodGet, "/readyz", nil)
+	got := decode[readyResponse](t, rec)
+	if rec.Code != http.StatusOK || got.Checks["database"] != "ok" || *got.PendingTasks != 1 || *got.Workers != 1 {
+		t.Fatalf("readyz: %d %+v", rec.Code, got)
+	}
+
+	srv.readyChecks["reaper"] = func(context.Context) error { return errors.New("reaper is not running") }
+	if rec := do(t, srv, http.MethodGet, "/readyz", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stopped reaper: expected 503, got %d", rec.Code)
+	}
+	delete(srv.readyChecks, "reaper")
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	rec = do(t, srv, http.MethodGet, "/readyz", nil)
+	if got := decode[readyResponse](t, rec); rec.Code != http.StatusServiceUnavailable || got.Checks["database"] == "ok" {
+		t.Fatalf("closed database: %d %+v", rec.Code, got)
+	}
+	if rec := do(t, srv, http.MethodGet, "/healthz", nil); rec.Code != http.StatusOK {
+		t.Fatalf("liveness must not depend on the database: %d", rec.Code)
+	}
+}
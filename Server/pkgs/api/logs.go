@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+const (
+	// chunks loaded per query, followers page through larger backlogs
+	logPageSize = 500
+	// appends to another server process do not wake followers here, so they look again on their own
+	logPollInterval = time.Second
+)
+
+type appendLogRequest struct {
+	WorkerID string `json:"worker_id"`
+	Data     string `json:"data"`
+}
+
+type appendLogResponse struct {
+	Seq    int64 `json:"seq"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// appendLog adds a chunk of output to a running task, only its worker may write
+func (s *Server) appendLog(w http.ResponseWriter, r *http.Request) {
+	var req appendLogRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	var ok bool
+	if req.WorkerID, ok = boundWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+	if req.WorkerID == "" {
+		writeError(w, http.StatusBadRequest, "worker_id is required")
+		return
+	}
+	chunk, err := s.sched.AppendLog(asWorker(r.Context(), req.WorkerID), r.PathValue("id"), req.WorkerID, req.Data)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	s.logWakeup.broadcast()
+	writeJSON(w, http.StatusCreated, appendLogResponse{Seq: chunk.Seq, Offset: chunk.ByteOffset, Size: chunk.Size})
+}
+
+type taskLogsResponse struct {
+	// leading bytes of the output dropped to stay within the cap
+	TruncatedBytes int64           `json:"truncated_bytes"`
+	Chunks         []model.TaskLog `json:"chunks"`
+	// more chunks follow, ask again with after set to the last seq
+	More bool `json:"more"`
+}
+
+// taskLogs returns the output of a task after the seq given by after, with follow=true
+// it keeps streaming new chunks as server-sent events until the task finishes
+func (s *Server) taskLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after, err := logCursor(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	if q.Get("follow") == "true" {
+		s.followLogs(w, r, task, after)
+		return
+	}
+	chunks, truncated, err := s.sched.Logs(r.Context(), task.ID, after, logPageSize+1)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	more := len(chunks) > logPageSize
+	if more {
+		chunks = chunks[:logPageSize]
+	}
+	writeJSON(w, http.StatusOK, taskLogsResponse{TruncatedBytes: truncated, Chunks: chunks, More: more})
+}
+
+// logCursor is the after parameter, a reconnecting event source sends Last-Event-ID instead
+func logCursor(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("after")
+	if v == "" {
+		v = r.Header.Get("Last-Event-ID")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	after, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || after < 0 {
+		return 0, fmt.Errorf("invalid log position %q", v)
+	}
+	return after, nil
+}
+
+// followLogs sends every chunk as a `log` event with its seq as the event id and ends
+// with an `end` event carrying the task once it finished and the log is drained
+func (s *Server) followLogs(w http.ResponseWriter, r *http.Request, task *model.Task, after int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	id := task.ID
+	poll := time.NewTicker(logPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		// subscribe before reading so a chunk appended in between is not missed
+		woken := s.logWakeup.wait()
+		// the status is read first, a task seen finished has no chunks beyond the ones read next
+		finished := task.Status.Terminal()
+		chunks, _, err := s.sched.Logs(r.Context(), id, after, logPageSize)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "logs: failed to load chunks", "task_id", id, "error", err)
+			return
+		}
+		for _, chunk := range chunks {
+			data, _ := json.Marshal(chunk)
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", chunk.Seq, data); err != nil {
+				return
+			}
+			after = chunk.Seq
+		}
+		flusher.Flush()
+		if len(chunks) == logPageSize {
+			continue
+		}
+		if finished {
+			data, _ := json.Marshal(task)
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-woken:
+		case <-poll.C:
+		}
+		if task, err = s.sched.Get(r.Context(), id); err != nil {
+			slog.ErrorContext(r.Context(), "logs: failed to reload task", "task_id", id, "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+func TestTaskLogs(t *testing.T) {
+	srv := newTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)))
+	claim(srv, "worker-1", 0)
+	logs := "/tasks/" + created.ID + "/logs"
+	appendLog := func(workerID, data string) *httptest.ResponseRecorder {
+		return do(t, srv, http.MethodPost, logs, appendLogRequest{WorkerID: workerID, Data: data})
+	}
+	if rec := appendLog("worker-2", "not mine\n"); rec.Code != http.StatusForbidden {
+		t.Fatalf("append by another worker: expected 403, got %d", rec.Code)
+	}
+	if rec := appendLog("worker-1", "applying 0001.patch\n"); rec.Code != http.StatusCreated || decode[appendLogResponse](t, rec).Seq != 1 {
+		t.Fatalf("append: %d %s", rec.Code, rec.Body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+logs+"?follow=true", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewScanner(resp.Body)
+	next := func() (string, string) {
+		t.Helper()
+		var event string
+		for events.Scan() {
+			line := events.Text()
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = name
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				return event, data
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return "", ""
+	}
+	chunkOf := func(data string) model.TaskLog {
+		var chunk model.TaskLog
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode chunk: %v", err)
+		}
+		return chunk
+	}
+	if event, data := next(); event != "log" || chunkOf(data).Data != "applying 0001.patch\n" {
+		t.Fatalf("backlog: %s %s", event, data)
+	}
+
+	// chunks appended while following arrive in order
+	for _, line := range []string{"applying 0002.patch\n", "building\n"} {
+		if rec := appendLog("worker-1", line); rec.Code != http.StatusCreated {
+			t.Fatalf("append: %d %s", rec.Code, rec.Body)
+		}
+	}
+	for i, want := range []string{"applying 0002.patch\n", "building\n"} {
+		if event, data := next(); event != "log" || chunkOf(data).Data != want || chunkOf(data).Seq != int64(i+2) {
+			t.Fatalf("live chunk %d: %s %s", i, event, data)
+		}
+	}
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusSuccess})
+	if event, data := next(); event != "end" || !strings.Contains(data, string(model.StatusSuccess)) {
+		t.Fatalf("expected the stream to end with the finished task, got %s %s", event, data)
+	}
+
+	// still readable once finished, and resumable after a seq
+	rec := do(t, srv, http.MethodGet, logs+"?after=1", nil)
+	if got := decode[taskLogsResponse](t, rec); rec.Code != http.StatusOK || len(got.Chunks) != 2 || got.Chunks[0].Seq != 2 || got.TruncatedBytes != 0 {
+		t.Fatalf("logs of the finished task: %d %+v", rec.Code, got)
+	}
+	if rec := appendLog("worker-1", "too late\n"); rec.Code != http.StatusConflict {
+		t.Fatalf("append to a finished task: expected 409, got %d", rec.Code)
+	}
+}
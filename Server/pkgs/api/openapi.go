@@ -0,0 +1,386 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+
+	"gorm.io/gorm"
+)
+
+// the spec is generated from the request and response types themselves, so field
+// names and enum values cannot drift from what the handlers actually decode and send
+
+// enums maps the string types with a closed value set onto their values
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(model.TaskType("")):    enumValues(model.TaskTypes),
+	reflect.TypeOf(model.TaskStatus("")):  enumValues(model.TaskStatuses),
+	reflect.TypeOf(model.AuditAction("")): enumValues(model.AuditActions),
+	reflect.TypeOf(model.ApplyMode("")):   enumValues(model.ApplyModes),
+}
+
+func enumValues[T ~string](values []T) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = string(v)
+	}
+	return out
+}
+
+type param struct {
+	name     string
+	in       string // path | query | header
+	schema   any
+	desc     string
+	required bool
+}
+
+type response struct {
+	desc string
+	body any // value of the json body type, nil for none
+	// content type of a non-json body
+	raw string
+}
+
+type operation struct {
+	method, path, summary, tag string
+	params                     []param
+	body                       any // value of the json request body type
+	optionalBody               bool
+	rawBody                    string
+	responses                  map[int]response
+	// takes a worker token when the server requires them
+	workerAuth bool
+}
+
+func pathID(desc string) param {
+	return param{name: "id", in: "path", schema: "", desc: desc, required: true}
+}
+
+func query(name string, schema any, desc string) param {
+	return param{name: name, in: "query", schema: schema, desc: desc}
+}
+
+var pageParams = []param{
+	query("limit", 0, "page size, capped by the server"),
+	query("offset", 0, "entries to skip"),
+}
+
+var taskFilterParams = append([]param{
+	query("status", model.TaskStatus(""), "only tasks in this status"),
+	query("type", model.TaskType(""), "only tasks of this type"),
+	query("worker_id", "", "only tasks held by this worker"),
+	query("experiment_id", "", "only tasks of this experiment"),
+	query("include_archived", false, "also list archived tasks"),
+}, pageParams...)
+
+func errorResponses(codes ...int) map[int]response {
+	out := make(map[int]response, len(codes))
+	for _, code := range codes {
+		out[code] = response{desc: http.StatusText(code), body: errorResponse{}}
+	}
+	return out
+}
+
+func with(base map[int]response, code int, r response) map[int]response {
+	base[code] = r
+	return base
+}
+
+func operations() []operation {
+	task := response{desc: "the task", body: model.Task{}}
+	tasks := response{desc: "matching tasks, newest first", body: []model.Task{}}
+	worker := response{desc: "the worker", body: model.Worker{}}
+	workerID := query("worker_id", "", "worker performing the upload")
+	return []operation{
+		{method: "GET", path: "/healthz", tag: "health", summary: "Liveness probe",
+			responses: map[int]response{200: {desc: "the process is up", body: map[string]string{}}}},
+		{method: "GET", path: "/readyz", tag: "health", summary: "Readiness probe covering the database and background jobs",
+			responses: map[int]response{200: {desc: "ready", body: readyResponse{}}, 503: {desc: "a dependency is down", body: readyResponse{}}}},
+		{method: "GET", path: "/openapi.json", tag: "health", summary: "This document",
+			responses: map[int]response{200: {desc: "openapi 3 spec", body: map[string]any{}}}},
+
+		{method: "POST", path: "/tasks", tag: "tasks", summary: "Submit a task", body: createTaskRequest{},
+			responses: with(with(errorResponses(400, 413, 422, 429), 201, response{desc: "created", body: model.Task{}}),
+				200, response{desc: "the task submitted earlier with the same idempotency key", body: model.Task{}})},
+		{method: "POST", path: "/tasks/batch", tag: "tasks", summary: "Submit tasks atomically, all or none", body: []createTaskRequest{},
+			responses: with(errorResponses(400, 413, 422, 429), 201, response{desc: "ids in request order", body: batchResponse{}})},
+		{method: "POST", path: "/tasks/claim", tag: "workers", summary: "Long-poll for the next task a worker can run", body: claimRequest{},
+			workerAuth: true,
+			responses:  with(with(errorResponses(400, 401, 403), 200, task), 204, response{desc: "no task became available in time"})},
+		{method: "GET", path: "/tasks", tag: "tasks", summary: "List tasks", params: taskFilterParams,
+			responses: with(errorResponses(400), 200, tasks)},
+		{method: "GET", path: "/tasks/stream", tag: "tasks", summary: "Server-sent events of task transitions",
+			params:    []param{query("type", model.TaskType(""), "only tasks of this type"), query("worker_id", "", "only tasks of this worker")},
+			responses: with(errorResponses(400), 200, response{desc: "a `task` event per transition carrying the task as json", raw: "text/event-stream"})},
+		{method: "GET", path: "/tasks/search", tag: "tasks", summary: "Case-insensitive substring search over results, artifact names and crash signatures",
+			params:    append([]param{{name: "q", in: "query", schema: "", desc: "text to look for", required: true}}, taskFilterParams...),
+			responses: with(errorResponses(400), 200, tasks)},
+		{method: "GET", path: "/tasks/dead-letter", tag: "tasks", summary: "List tasks that ran out of retries",
+			params:    append([]param{query("type", model.TaskType(""), "only tasks of this type")}, pageParams...),
+			responses: with(errorResponses(400), 200, tasks)},
+		{method: "GET", path: "/tasks/{id}", tag: "tasks", summary: "Get a task", params: []param{pathID("task id")},
+			responses: with(errorResponses(404), 200, task)},
+		{method: "GET", path: "/tasks/{id}/audit", tag: "tasks", summary: "Audit trail of a task, oldest first", params: []param{pathID("task id")},
+			responses: with(errorResponses(404), 200, response{desc: "audit events", body: []model.AuditEvent{}})},
+		{method: "POST", path: "/tasks/{id}/cancel", tag: "tasks", summary: "Cancel a task, running ones stop once their worker notices, needs an admin key", params: []param{pathID("task id")},
+			responses: with(errorResponses(401, 403, 404, 409), 200, task)},
+		{method: "POST", path: "/tasks/{id}/requeue", tag: "tasks", summary: "Put a dead-lettered task back into the queue, needs an admin key", params: []param{pathID("task id")},
+			responses: with(errorResponses(401, 403, 404, 409), 200, task)},
+		{method: "POST", path: "/tasks/{id}/rerun", tag: "tasks", summary: "Submit a copy of a finished task, needs an admin key", params: []param{pathID("task id")},
+			responses: with(errorResponses(401, 403, 404, 409, 429), 201, response{desc: "the new task", body: model.Task{}})},
+		{method: "POST", path: "/tasks/{id}/reassign", tag: "admin", summary: "Move a running task off its worker so another one runs it, needs an admin key",
+			params: []param{pathID("task id")}, body: reassignRequest{}, optionalBody: true,
+			responses: with(errorResponses(400, 401, 403, 404, 409), 200, task)},
+		{method: "PUT", path: "/tasks/{id}/artifact", tag: "workers", summary: "Upload the artifact of a running task",
+			params: []param{pathID("task id"), workerID, query("name", "", "file name of the artifact"),
+				{name: "Content-Encoding", in: "header", schema: "", desc: "gzip or zstd to store the body compressed"}},
+			rawBody:    "application/octet-stream",
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403, 404, 409, 415), 201, response{desc: "stored", body: uploadArtifactResponse{}})},
+		{method: "GET", path: "/tasks/{id}/artifact", tag: "tasks", summary: "Download the artifact of a task, compressed ones are decoded unless Accept-Encoding allows the stored encoding",
+			params:    []param{pathID("task id"), {name: "Accept-Encoding", in: "header", schema: "", desc: "gzip or zstd to receive the stored bytes"}},
+			responses: with(errorResponses(404, 410), 200, response{desc: "the artifact, ranges are supported", raw: "application/octet-stream"})},
+		{method: "POST", path: "/tasks/{id}/artifacts", tag: "workers", summary: "Upload one more file of a running task, e.g. a build log",
+			params: []param{pathID("task id"), workerID, query("name", "", "file name of the artifact"),
+				{name: "Content-Type", in: "header", schema: "", desc: "media type the file is served with later"},
+				{name: "Content-Encoding", in: "header", schema: "", desc: "gzip or zstd to store the body compressed"}},
+			rawBody:    "application/octet-stream",
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403, 404, 409, 415), 201, response{desc: "stored", body: model.Artifact{}})},
+		{method: "GET", path: "/tasks/{id}/artifacts", tag: "tasks", summary: "List the artifacts of a task", params: []param{pathID("task id")},
+			responses: with(errorResponses(404), 200, response{desc: "artifacts in upload order", body: []model.Artifact{}})},
+		{method: "GET", path: "/tasks/{id}/artifacts/{name}", tag: "tasks", summary: "Download an artifact of a task by name",
+			params: []param{pathID("task id"), {name: "name", in: "path", schema: "", desc: "artifact name", required: true},
+				{name: "Accept-Encoding", in: "header", schema: "", desc: "gzip or zstd to receive the stored bytes"}},
+			responses: with(errorResponses(404, 410), 200, response{desc: "the artifact, ranges are supported", raw: "application/octet-stream"})},
+		{method: "POST", path: "/tasks/{id}/report", tag: "workers", summary: "Report the outcome of a running task, repeating a report that already went through returns the task unchanged", params: []param{pathID("task id")}, body: reportTaskRequest{},
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403, 404, 409), 200, task)},
+		{method: "POST", path: "/tasks/{id}/progress", tag: "workers", summary: "Report how far a running task got", params: []param{pathID("task id")}, body: progressRequest{},
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403, 404, 409), 200, task)},
+		{method: "POST", path: "/tasks/{id}/renew", tag: "workers", summary: "Extend the lease of a running task", params: []param{pathID("task id")}, body: renewRequest{},
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403, 404, 409), 200, task)},
+		{method: "POST", path: "/tasks/{id}/logs", tag: "workers", summary: "Append a chunk of output to a running task", params: []param{pathID("task id")}, body: appendLogRequest{},
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403, 404, 409), 201, response{desc: "the position of the chunk in the log", body: appendLogResponse{}})},
+		{method: "GET", path: "/tasks/{id}/logs", tag: "tasks", summary: "Output of a task, oldest chunks are dropped past the size cap",
+			params: []param{pathID("task id"), query("after", 0, "only chunks after this seq"),
+				query("follow", false, "stream chunks as server-sent events until the task finishes"),
+				{name: "Last-Event-ID", in: "header", schema: "", desc: "resume a followed stream, same as after"}},
+			responses: with(errorResponses(400, 404), 200, response{desc: "the chunks, or `log` events carrying a chunk and a final `end` event carrying the task when following", body: taskLogsResponse{}})},
+
+		{method: "POST", path: "/experiments", tag: "experiments", summary: "Create an experiment, needs an admin key", body: createExperimentRequest{},
+			responses: with(errorResponses(400, 401, 403), 201, response{desc: "created", body: model.Experiment{}})},
+		{method: "GET", path: "/experiments/{id}", tag: "experiments", summary: "Get an experiment with its aggregate status", params: []param{pathID("experiment id")},
+			responses: with(errorResponses(404), 200, response{desc: "the experiment", body: model.Experiment{}})},
+
+		{method: "GET", path: "/concurrency", tag: "workers", summary: "Running tasks against the concurrency limit of each type",
+			responses: map[int]response{200: {desc: "usage per task type", body: []scheduler.TypeUsage{}}}},
+		{method: "GET", path: "/queue/stats", tag: "tasks", summary: "Queue depth and estimated wait per task type, refreshed every few seconds",
+			responses: map[int]response{200: {desc: "stats per task type", body: []scheduler.QueueStats{}}}},
+		{method: "GET", path: "/task-types", tag: "tasks", summary: "Task types and the defaults of the fields a task leaves unset",
+			responses: map[int]response{200: {desc: "every task type", body: []taskTypeResponse{}}}},
+		{method: "POST", path: "/dispatch/preview", tag: "tasks", summary: "Where prospective tasks would land if submitted now, nothing is created", body: []scheduler.ProspectiveTask{},
+			responses: with(errorResponses(400, 413), 200, response{desc: "the worker and queue position of every task", body: scheduler.DispatchPreview{}})},
+		{method: "GET", path: "/workers", tag: "workers", summary: "List workers",
+			responses: map[int]response{200: {desc: "workers", body: []workerResponse{}}}},
+		{method: "GET", path: "/workers/{id}/stats", tag: "workers", summary: "Runs of a worker by outcome and its busy time, over its lifetime and the rolling window", params: []param{pathID("worker id")},
+			responses: with(errorResponses(404), 200, response{desc: "the worker's stats", body: scheduler.WorkerStats{}})},
+		{method: "POST", path: "/workers/{id}/heartbeat", tag: "workers", summary: "Announce a worker is alive", params: []param{pathID("worker id")}, body: heartbeatRequest{}, optionalBody: true,
+			workerAuth: true,
+			responses:  with(errorResponses(400, 401, 403), 200, response{desc: "the worker and the tasks it must abort", body: heartbeatResponse{}})},
+		{method: "POST", path: "/workers/register", tag: "admin", summary: "Register a worker and issue it a token, needs an admin key", body: registerWorkerRequest{},
+			responses: with(errorResponses(400, 401, 403), 201, response{desc: "the worker and its token", body: registerWorkerResponse{}})},
+		{method: "POST", path: "/workers/{id}/drain", tag: "admin", summary: "Stop handing new tasks to a worker, needs an admin key", params: []param{pathID("worker id")},
+			responses: with(errorResponses(401, 403, 404), 200, worker)},
+		{method: "DELETE", path: "/workers/{id}/drain", tag: "admin", summary: "Let a drained worker claim tasks again, needs an admin key", params: []param{pathID("worker id")},
+			responses: with(errorResponses(401, 403, 404), 200, worker)},
+		{method: "DELETE", path: "/workers/{id}/tokens", tag: "admin", summary: "Revoke every token of a worker, needs an admin key", params: []param{pathID("worker id")},
+			responses: with(errorResponses(401, 403), 200, response{desc: "how many tokens were revoked", body: revokeTokensResponse{}})},
+	}
+}
+
+// spec builds the openapi 3 document of the api
+func spec() map[string]any {
+	g := &schemaGen{components: map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, op := range operations() {
+		o := map[string]any{
+			"summary":     op.summary,
+			"tags":        []string{op.tag},
+			"operationId": operationID(op.method, op.path),
+		}
+		var params []any
+		for _, p := range op.params {
+			params = append(params, map[string]any{
+				"name": p.name, "in": p.in, "required": p.required,
+				"description": p.desc, "schema": g.schema(reflect.TypeOf(p.schema)),
+			})
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		switch {
+		case op.body != nil:
+			o["requestBody"] = map[string]any{"required": !op.optionalBody, "content": map[string]any{
+				"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(op.body))},
+			}}
+		case op.rawBody != "":
+			o["requestBody"] = map[string]any{"required": true, "content": map[string]any{
+				op.rawBody: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}}
+		}
+		responses := map[string]any{}
+		for code, r := range op.responses {
+			resp := map[string]any{"description": r.desc}
+			switch {
+			case r.body != nil:
+				resp["content"] = map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(r.body))}}
+			case r.raw != "":
+				resp["content"] = map[string]any{r.raw: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+			}
+			responses[strconv.Itoa(code)] = resp
+		}
+		o["responses"] = responses
+		if op.workerAuth {
+			o["security"] = []any{map[string]any{}, map[string]any{"workerToken": []string{}}}
+		}
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = o
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "DumpMind task server",
+			"description": "Errors are always answered with an ErrorResponse body, a 422 lists the rejected payload fields. Every response carries an " + RequestIDHeader + " header.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		// the key is optional, it only identifies the client for rate limiting and auditing
+		"security": []any{map[string]any{}, map[string]any{"apiKey": []string{}}},
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"apiKey":      map[string]any{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+				"workerToken": map[string]any{"type": "http", "scheme": "bearer", "description": "issued by POST /workers/register"},
+			},
+		},
+	}
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, spec())
+}
+
+// operationID turns "POST /tasks/{id}/cancel" into "postTasksIdCancel"
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '{' || r == '}' || r == '-' || r == '.' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+)
+
+// schemaGen derives json schemas from go types, named types become components
+type schemaGen struct {
+	components map[string]any
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return map[string]any{"type": "string", "format": "date-time", "nullable": true}
+	}
+	if values, ok := enums[t]; ok {
+		return g.component(t, func() map[string]any {
+			return map[string]any{"type": "string", "enum": values}
+		})
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			// siblings of $ref are ignored in openapi 3.0, wrap it to mark it nullable
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.component(t, func() map[string]any { return g.object(t) })
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	}
+	// interfaces, any json value
+	return map[string]any{}
+}
+
+// component registers the schema of a named type once and returns a reference to it
+func (g *schemaGen) component(t reflect.Type, build func() map[string]any) map[string]any {
+	name := t.Name()
+	if name == "" {
+		return build()
+	}
+	name = strings.ToUpper(name[:1]) + name[1:]
+	if _, ok := g.components[name]; !ok {
+		// placeholder first so recursive types terminate
+		g.components[name] = nil
+		g.components[name] = build()
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// object lists the json fields of a struct, embedded structs are flattened like encoding/json does
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if tag == "-" || !f.IsExported() && !f.Anonymous {
+				continue
+			}
+			if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if tag == "" {
+				tag = f.Name
+			}
+			props[tag] = g.schema(f.Type)
+		}
+	}
+	walk(t)
+	return map[string]any{"type": "object", "properties": props}
+}
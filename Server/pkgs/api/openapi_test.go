@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"Server/pkgs/model"
+)
+
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	srv := newTestServer(t)
+	rec := do(t, srv, http.MethodGet, "/openapi.json", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("openapi.json: %d", rec.Code)
+	}
+	doc := decode[struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Enum       []string       `json:"enum"`
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}](t, rec)
+
+	documented := 0
+	for _, ops := range doc.Paths {
+		documented += len(ops)
+	}
+	if documented != len(srv.patterns) {
+		t.Errorf("spec documents %d operations, server routes %d", documented, len(srv.patterns))
+	}
+	for _, pattern := range srv.patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("route %s is not documented", pattern)
+		}
+	}
+
+	schemas := doc.Components.Schemas
+	if got := schemas["TaskType"].Enum; !slices.Equal(got, enumValues(model.TaskTypes)) {
+		t.Errorf("TaskType enum = %v", got)
+	}
+	if got := schemas["TaskStatus"].Enum; !slices.Equal(got, []string{"pending", "running", "success", "failed", "cancelled", "dead_lettered"}) {
+		t.Errorf("TaskStatus enum = %v", got)
+	}
+	for _, field := range []string{"id", "type", "status", "payload", "result_data", "lease_expires_at", "archived_at"} {
+		if _, ok := schemas["Task"].Properties[field]; !ok {
+			t.Errorf("Task schema lacks %s", field)
+		}
+	}
+	for _, name := range []string{"CreateTaskRequest", "GetVmcorePayload", "PatchApplyPayload", "ErrorResponse", "WorkerResponse"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("missing schema %s", name)
+		}
+	}
+	// embedded structs are flattened the way encoding/json does
+	if _, ok := schemas["WorkerResponse"].Properties["last_seen_at"]; !ok {
+		t.Errorf("WorkerResponse does not flatten model.Worker: %v", schemas["WorkerResponse"].Properties)
+	}
+}
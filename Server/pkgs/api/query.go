@@ -0,0 +1,29 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// parsePage reads limit/offset from the query string, applying the default and the hard cap
+func parsePage(q url.Values) (limit, offset int, err error) {
+	limit = defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+		limit = min(limit, maxListLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	return limit, offset, nil
+}
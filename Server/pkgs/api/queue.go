@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"Server/pkgs/scheduler"
+)
+
+// queueStats answers how deep the queue of every task type is and how long it takes to drain
+func (s *Server) queueStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.sched.QueueStats(r.Context())
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// previewDispatch tells where a batch of tasks would land if it were submitted now,
+// nothing is created or claimed
+func (s *Server) previewDispatch(w http.ResponseWriter, r *http.Request) {
+	var tasks []scheduler.ProspectiveTask
+	if err := decodeJSON(w, r, &tasks); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if len(tasks) == 0 {
+		writeError(w, http.StatusBadRequest, "batch is empty")
+		return
+	}
+	if len(tasks) > s.maxBatchSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch of %d tasks exceeds the limit of %d", len(tasks), s.maxBatchSize))
+		return
+	}
+	preview, err := s.sched.PreviewDispatch(r.Context(), tasks)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+func TestPreviewDispatch(t *testing.T) {
+	srv := newTestServer(t)
+	srv.maxBatchSize = 2
+	do(t, srv, http.MethodPost, "/workers/worker-1/heartbeat", heartbeatRequest{Capabilities: []string{"kdump"}})
+
+	for body, code := range map[string]int{
+		`[]`:                                     http.StatusBadRequest,
+		`[{"type":"reboot"}]`:                    http.StatusBadRequest,
+		`[{"type":"get_vmcore"},{},{}]`:          http.StatusRequestEntityTooLarge,
+		`[{"type":"get_vmcore","unknown":true}]`: http.StatusBadRequest,
+	} {
+		if rec := do(t, srv, http.MethodPost, "/dispatch/preview", json.RawMessage(body)); rec.Code != code {
+			t.Errorf("%s: expected %d, got %d", body, code, rec.Code)
+		}
+	}
+
+	rec := do(t, srv, http.MethodPost, "/dispatch/preview", []scheduler.ProspectiveTask{
+		{Type: model.TaskTypeGetVmcore, RequiredCapabilities: []string{"kdump"}},
+		{Type: model.TaskTypeGetVmcore, RequiredCapabilities: []string{"arm"}},
+	})
+	preview := decode[scheduler.DispatchPreview](t, rec)
+	if rec.Code != http.StatusOK || len(preview.Placements) != 2 || preview.Placements[0].WorkerID != "worker-1" || preview.Placements[1].Satisfiable {
+		t.Fatalf("preview: %d %+v", rec.Code, preview)
+	}
+	if tasks := decode[[]model.Task](t, do(t, srv, http.MethodGet, "/tasks", nil)); len(tasks) != 0 {
+		t.Fatalf("preview created tasks: %+v", tasks)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// APIKeyHeader identifies the client for rate limiting and admin access
+const APIKeyHeader = "X-API-Key"
+
+// rateLimited answers 429 once the caller's api key ran out of tokens
+func (s *Server) rateLimited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter != nil {
+			if ok, wait := s.limiter.Allow(r.Header.Get(APIKeyHeader)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+		}
+		h(w, r)
+	}
+}
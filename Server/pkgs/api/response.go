@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+// maxBodyBytes bounds every json request body
+const maxBodyBytes = 1 << 20
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// index of the offending entry of a batch request
+	Index *int `json:"index,omitempty"`
+	// rejected payload fields, paths start at the request body
+	Fields []model.FieldError `json:"fields,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+// taskError describes a rejected task, a payload validation error is listed field by field
+func taskError(err error, index *int) errorResponse {
+	resp := errorResponse{Error: err.Error(), Index: index}
+	var invalid *model.ValidationError
+	if errors.As(err, &invalid) {
+		for _, f := range invalid.Fields {
+			field := "payload"
+			if f.Field != "" {
+				field += "." + f.Field
+			}
+			resp.Fields = append(resp.Fields, model.FieldError{Field: field, Message: f.Message})
+		}
+	}
+	return resp
+}
+
+// writeSchedulerError maps scheduler errors onto http status codes
+func writeSchedulerError(w http.ResponseWriter, r *http.Request, err error) {
+	var batchErr *scheduler.BatchError
+	if errors.As(err, &batchErr) && errors.Is(err, scheduler.ErrInvalidTask) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error(), Index: &batchErr.Index})
+		return
+	}
+
+	switch {
+	case errors.Is(err, scheduler.ErrInvalidTask):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, scheduler.ErrNotFound), errors.Is(err, scheduler.ErrWorkerNotFound), errors.Is(err, scheduler.ErrExperimentNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, scheduler.ErrInvalidState), errors.Is(err, scheduler.ErrConflict):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, scheduler.ErrNotOwner):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, scheduler.ErrClosed):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "internal error", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
+// writeDecodeError answers a body decodeJSON refused
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
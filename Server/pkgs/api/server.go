@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/config"
+	"Server/pkgs/logging"
+	"Server/pkgs/ratelimit"
+	"Server/pkgs/scheduler"
+	"Server/pkgs/validation"
+
+	"github.com/google/uuid"
+)
+
+const defaultMaxBatchSize = 500
+
+type Server struct {
+	sched        *scheduler.Scheduler
+	artifacts    artifact.Store
+	mux          *http.ServeMux
+	hub          *hub
+	wakeup       *wakeup
+	logWakeup    *wakeup
+	limiter      *ratelimit.Limiter
+	payloads     *validation.Registry
+	readyChecks  map[string]ReadyCheck
+	adminKeys    []string
+	workerTokens bool
+	maxBatchSize int
+	// closed once shutdown starts, ends the long polls and event streams
+	closing   chan struct{}
+	closeOnce sync.Once
+	// patterns of the api routes, the openapi spec must cover each of them
+	patterns []string
+}
+
+type Option func(*Server)
+
+// WithMaxBatchSize caps the number of tasks accepted by a single batch submission
+func WithMaxBatchSize(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxBatchSize = n
+		}
+	}
+}
+
+// WithRateLimiter throttles task creation per api key
+func WithRateLimiter(l *ratelimit.Limiter) Option {
+	return func(s *Server) {
+		s.limiter = l
+	}
+}
+
+// WithPayloadValidation replaces the built-in payload limits and validators
+func WithPayloadValidation(r *validation.Registry) Option {
+	return func(s *Server) {
+		s.payloads = r
+	}
+}
+
+// WithReadyCheck adds a subsystem to the readiness probe next to the database
+func WithReadyCheck(name string, check ReadyCheck) Option {
+	return func(s *Server) {
+		s.readyChecks[name] = check
+	}
+}
+
+func New(sched *scheduler.Scheduler, artifacts artifact.Store, opts ...Option) *Server {
+	s := &Server{
+		sched:        sched,
+		artifacts:    artifacts,
+		mux:          http.NewServeMux(),
+		hub:          newHub(),
+		wakeup:       newWakeup(),
+		logWakeup:    newWakeup(),
+		readyChecks:  make(map[string]ReadyCheck),
+		maxBatchSize: defaultMaxBatchSize,
+		closing:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.payloads == nil {
+		s.payloads, _ = validation.New(config.PayloadConfig{})
+	}
+	sched.OnTransition(s.hub.publish)
+	sched.OnTransition(s.wakeup.notify)
+	// log followers end once their task finished
+	sched.OnTransition(func(t scheduler.Transition) {
+		if t.To.Terminal() {
+			s.logWakeup.broadcast()
+		}
+	})
+	s.routes()
+	return s
+}
+
+func (s *Server) routes() {
+	s.route("GET /healthz", s.healthz)
+	s.route("GET /readyz", s.readyz)
+	s.route("GET /openapi.json", s.openAPI)
+	s.route("POST /tasks", s.rateLimited(s.createTask))
+	s.route("POST /tasks/batch", s.rateLimited(s.createTaskBatch))
+	s.route("POST /tasks/claim", s.workerOnly(s.claimTask))
+	s.route("GET /tasks", s.listTasks)
+	s.route("GET /tasks/stream", s.streamTasks)
+	s.route("GET /tasks/search", s.searchTasks)
+	s.route("GET /tasks/dead-letter", s.listDeadLetter)
+	s.route("GET /tasks/{id}", s.getTask)
+	s.route("GET /tasks/{id}/audit", s.taskAudit)
+	s.route("POST /tasks/{id}/cancel", s.adminOnly(s.cancelTask))
+	s.route("POST /tasks/{id}/requeue", s.adminOnly(s.requeueTask))
+	s.route("POST /tasks/{id}/rerun", s.adminOnly(s.rateLimited(s.rerunTask)))
+	s.route("POST /tasks/{id}/reassign", s.adminOnly(s.reassignTask))
+	s.route("PUT /tasks/{id}/artifact", s.workerOnly(s.uploadArtifact))
+	s.route("GET /tasks/{id}/artifact", s.downloadArtifact)
+	s.route("POST /tasks/{id}/artifacts", s.workerOnly(s.addArtifact))
+	s.route("GET /tasks/{id}/artifacts", s.listArtifacts)
+	s.route("GET /tasks/{id}/artifacts/{name}", s.downloadNamedArtifact)
+	s.route("POST /tasks/{id}/report", s.workerOnly(s.reportTask))
+	s.route("POST /tasks/{id}/progress", s.workerOnly(s.reportProgress))
+	s.route("POST /tasks/{id}/renew", s.workerOnly(s.renewLease))
+	s.route("POST /tasks/{id}/logs", s.workerOnly(s.appendLog))
+	s.route("GET /tasks/{id}/logs", s.taskLogs)
+	s.route("POST /experiments", s.adminOnly(s.createExperiment))
+	s.route("GET /experiments/{id}", s.getExperiment)
+	s.route("GET /concurrency", s.concurrency)
+	s.route("GET /queue/stats", s.queueStats)
+	s.route("GET /task-types", s.taskTypes)
+	s.route("POST /dispatch/preview", s.previewDispatch)
+	s.route("GET /workers", s.listWorkers)
+	s.route("GET /workers/{id}/stats", s.workerStats)
+	s.route("POST /workers/register", s.adminOnly(s.registerWorker))
+	s.route("POST /workers/{id}/heartbeat", s.workerOnly(s.heartbeat))
+	s.route("POST /workers/{id}/drain", s.adminOnly(s.drainWorker))
+	s.route("DELETE /workers/{id}/drain", s.adminOnly(s.resumeWorker))
+	s.route("DELETE /workers/{id}/tokens", s.adminOnly(s.revokeWorkerTokens))
+}
+
+func (s *Server) route(pattern string, h http.HandlerFunc) {
+	s.patterns = append(s.patterns, pattern)
+	s.mux.HandleFunc(pattern, h)
+}
+
+// Handle mounts an extra handler, e.g. the metrics endpoint, next to the api routes
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Serve answers on ln until ctx is done, then shuts down gracefully: no new connections are
+// accepted, long polls and event streams end, and the requests in flight get up to drain to
+// finish before their connections are closed
+func (s *Server) Serve(ctx context.Context, ln net.Listener, drain time.Duration) error {
+	hs := &http.Server{Handler: s}
+	hs.RegisterOnShutdown(s.close)
+	served := make(chan error, 1)
+	go func() { served <- hs.Serve(ln) }()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drain)
+	defer cancel()
+	err := hs.Shutdown(shutdownCtx)
+	if err != nil {
+		hs.Close()
+		err = fmt.Errorf("requests still in flight after %s: %w", drain, err)
+	}
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
+
+// close ends the long polls and event streams, which would otherwise hold up a shutdown
+func (s *Server) close() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// RequestIDHeader carries the correlation id of a request, one is generated when the client sends none
+const RequestIDHeader = "X-Request-ID"
+
+// longest client supplied request id that is kept
+const maxRequestIDLen = 128
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLen {
+		id = uuid.NewString()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	ctx := logging.WithRequestID(r.Context(), id)
+	s.mux.ServeHTTP(w, r.WithContext(scheduler.WithActor(ctx, clientActor(r))))
+}
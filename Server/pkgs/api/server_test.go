@@ -0,0 +1,318 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/config"
+	"Server/pkgs/database"
+	"Server/pkgs/logging"
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+
+	"gorm.io/gorm"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	artifacts := artifact.NewMemory()
+	sched := scheduler.New(newTestDB(t), scheduler.RetryPolicy{MaxAttempts: 1}, scheduler.WithArtifactStore(artifacts))
+	return New(sched, artifacts)
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.Open(config.DatabaseConfig{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000",
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// taskBody builds a create request with a valid payload for taskType plus any extra fields
+func taskBody(taskType model.TaskType, extra map[string]any) map[string]any {
+	body := map[string]any{"type": taskType}
+	switch taskType {
+	case model.TaskTypeGetVmcore:
+		body["payload"] = model.TaskPayload{GetVmcore: &model.GetVmcorePayload{
+			TargetHost: "crash-host-01",
+			Endpoint:   "ssh://root@crash-host-01:22",
+			CrashTime:  time.Date(2025, 7, 1, 3, 4, 5, 0, time.UTC),
+			DumpPath:   "/var/crash/vmcore",
+		}}
+	case model.TaskTypePatchApply:
+		body["payload"] = model.TaskPayload{PatchApply: &model.PatchApplyPayload{
+			Patches:      []model.PatchRef{{URL: "https://lore.kernel.org/fix.patch"}},
+			TargetKernel: "6.1.0",
+		}}
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return body
+}
+
+func do(t *testing.T, h http.Handler, method, target string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return doWithHeader(t, h, method, target, body, "", "")
+}
+
+// doWithHeader is do with one request header set, e.g. a key or token
+func doWithHeader(t *testing.T, h http.Handler, method, target string, body any, header, value string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+func TestListTasksFilters(t *testing.T) {
+	srv := newTestServer(t)
+	for _, taskType := range []model.TaskType{model.TaskTypeGetVmcore, model.TaskTypeGetVmcore, model.TaskTypePatchApply} {
+		if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(taskType, nil)); rec.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := do(t, srv, http.MethodGet, "/tasks?type=get-vmcore&status=pending", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body)
+	}
+	if tasks := decode[[]model.Task](t, rec); len(tasks) != 2 {
+		t.Fatalf("expected 2 vmcore tasks, got %d", len(tasks))
+	}
+
+	rec = do(t, srv, http.MethodGet, "/tasks?limit=1&offset=1", nil)
+	if tasks := decode[[]model.Task](t, rec); len(tasks) != 1 {
+		t.Fatalf("expected a single paged task, got %d", len(tasks))
+	}
+
+	for _, target := range []string{"/tasks?status=bogus", "/tasks?type=bogus", "/tasks?limit=-1"} {
+		if rec := do(t, srv, http.MethodGet, target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestCreateTaskValidatesPayload(t *testing.T) {
+	srv := newTestServer(t)
+	if rec := do(t, srv, http.MethodPost, "/tasks", map[string]any{"type": model.TaskTypeGetVmcore}); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("vmcore task without payload: expected 422, got %d", rec.Code)
+	}
+	bad := taskBody(model.TaskTypeGetVmcore, nil)
+	bad["payload"].(model.TaskPayload).GetVmcore.DumpPath = "relative/vmcore"
+	bad["payload"].(model.TaskPayload).GetVmcore.TargetHost = "127.0.0.1"
+	rec := do(t, srv, http.MethodPost, "/tasks", bad)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("relative dump path: expected 422, got %d", rec.Code)
+	}
+	var fields []string
+	for _, f := range decode[errorResponse](t, rec).Fields {
+		fields = append(fields, f.Field)
+	}
+	if want := []string{"payload.get_vmcore.target_host", "payload.get_vmcore.dump_path"}; !slices.Equal(fields, want) {
+		t.Fatalf("rejected fields %v, want %v", fields, want)
+	}
+	if rec := do(t, srv, http.MethodPost, "/tasks", map[string]any{"type": "make-coffee"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown type: expected 400, got %d", rec.Code)
+	}
+
+	rec = do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("valid vmcore task: %d %s", rec.Code, rec.Body)
+	}
+	if task := decode[model.Task](t, rec); task.Payload == nil || task.Payload.GetVmcore.TargetHost != "crash-host-01" {
+		t.Fatalf("payload not stored: %+v", task.Payload)
+	}
+}
+
+func transitionOf(task model.Task) scheduler.Transition {
+	return scheduler.Transition{Task: task, To: task.Status}
+}
+
+func TestRequestIDIsLoggedWithTransitions(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(config.LogConfig{Level: "info"}, &buf)
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	srv := newTestServer(t)
+	body, _ := json.Marshal(taskBody(model.TaskTypeGetVmcore, nil))
+	req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+	req.Header.Set(RequestIDHeader, "req-42")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Header().Get(RequestIDHeader) != "req-42" {
+		t.Fatalf("request id not echoed: %q", rec.Header().Get(RequestIDHeader))
+	}
+	created := decode[model.Task](t, rec)
+
+	var found bool
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("log line is not json: %s", line)
+		}
+		if entry["msg"] == "task transition" && entry["task_id"] == created.ID {
+			found = entry["request_id"] == "req-42" && entry["status"] == string(model.StatusPending) && entry["task_type"] == string(model.TaskTypeGetVmcore)
+		}
+	}
+	if !found {
+		t.Fatalf("no correlated transition for %s in:\n%s", created.ID, buf.String())
+	}
+
+	// without a client id one is generated
+	if rec := do(t, srv, http.MethodGet, "/tasks", nil); rec.Header().Get(RequestIDHeader) == "" {
+		t.Fatal("no request id generated")
+	}
+}
+
+func TestServeDrainsInFlightRequestsOnShutdown(t *testing.T) {
+	srv := newTestServer(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	// submits the task only once released, so the write happens after shutdown started
+	srv.Handle("POST /slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		r.URL.Path = "/tasks"
+		srv.ServeHTTP(w, r)
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, ln, 5*time.Second) }()
+
+	base := "http://" + ln.Addr().String()
+	post := func(path string, body any) <-chan *http.Response {
+		data, _ := json.Marshal(body)
+		done := make(chan *http.Response, 1)
+		go func() {
+			resp, err := http.Post(base+path, "application/json", bytes.NewReader(data))
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+			} else {
+				resp.Body.Close()
+			}
+			done <- resp
+		}()
+		return done
+	}
+	slow := post("/slow", taskBody(model.TaskTypePatchApply, nil))
+	<-started
+	// a long poll would hold up the shutdown for its whole wait
+	polled := post("/tasks/claim", map[string]any{"worker_id": "worker-1", "wait_seconds": 60})
+	for n, _ := srv.sched.CountWorkers(ctx); n == 0; n, _ = srv.sched.CountWorkers(ctx) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case resp := <-polled:
+		if resp == nil || resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("long poll should end with 204 on shutdown, got %v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("long poll still waiting after shutdown started")
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second); err == nil {
+		t.Fatalf("new connections should be refused once shutdown started")
+	}
+
+	close(release)
+	if resp := <-slow; resp == nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("in flight request should complete, got %v", resp)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if err := srv.sched.Close(context.Background()); err != nil {
+		t.Fatalf("close scheduler: %v", err)
+	}
+	if n, _ := srv.sched.CountPending(context.Background()); n != 1 {
+		t.Fatalf("the drained request should have submitted its task, %d pending", n)
+	}
+}
+
+func TestServeCutsRequestsShortAfterDrainTimeout(t *testing.T) {
+	srv := newTestServer(t)
+	started := make(chan struct{})
+	srv.Handle("GET /hang", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, ln, 50*time.Millisecond) }()
+
+	hung := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/hang")
+		if err == nil {
+			resp.Body.Close()
+		}
+		hung <- err
+	}()
+	<-started
+	cancel()
+	if err := <-served; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to time out, got %v", err)
+	}
+	if err := <-hung; err == nil {
+		t.Fatalf("the hung request should have lost its connection")
+	}
+}
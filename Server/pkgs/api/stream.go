@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"Server/pkgs/logging"
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+const (
+	// events a client may lag behind before newer ones are dropped for it
+	streamBuffer    = 64
+	streamKeepAlive = 30 * time.Second
+)
+
+// hub fans task transitions out to the connected stream clients
+type hub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	taskType model.TaskType
+	workerID string
+	events   chan model.Task
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[*subscriber]struct{})}
+}
+
+func (h *hub) subscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// publish never blocks, a client whose buffer is full simply misses the event
+func (h *hub) publish(t scheduler.Transition) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.wants(&t.Task) {
+			continue
+		}
+		select {
+		case sub.events <- t.Task:
+		default:
+		}
+	}
+}
+
+func (sub *subscriber) wants(task *model.Task) bool {
+	return (sub.taskType == "" || sub.taskType == task.Type) &&
+		(sub.workerID == "" || sub.workerID == task.WorkerID)
+}
+
+// streamTasks pushes every task status change as a server-sent event
+func (s *Server) streamTasks(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	q := r.URL.Query()
+	sub := &subscriber{
+		taskType: model.TaskType(q.Get("type")),
+		workerID: q.Get("worker_id"),
+		events:   make(chan model.Task, streamBuffer),
+	}
+	if sub.taskType != "" && !sub.taskType.Valid() {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown task type %q", sub.taskType))
+		return
+	}
+
+	s.hub.subscribe(sub)
+	defer s.hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case task := <-sub.events:
+			data, err := json.Marshal(task)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "stream: failed to encode task", append(logging.Task(&task), "error", err)...)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: task\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
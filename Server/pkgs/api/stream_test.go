@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+func TestStreamReceivesStateChanges(t *testing.T) {
+	srv := newTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/tasks/stream?type=patch-apply", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream: %d", resp.StatusCode)
+	}
+
+	// filtered out by type
+	do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)))
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var task model.Task
+		if err := json.Unmarshal([]byte(line), &task); err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		if task.ID != created.ID || task.Status != model.StatusPending {
+			t.Fatalf("unexpected event for %s (%s), want %s", task.ID, task.Type, created.ID)
+		}
+		return
+	}
+	t.Fatalf("stream ended without an event: %v", scanner.Err())
+}
+
+func TestHubDropsForSlowConsumers(t *testing.T) {
+	h := newHub()
+	sub := &subscriber{events: make(chan model.Task, 1)}
+	h.subscribe(sub)
+
+	done := make(chan struct{})
+	go func() {
+		for range 10 {
+			h.publish(transitionOf(model.Task{ID: "t"}))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("publish blocked on a slow consumer")
+	}
+	if len(sub.events) != 1 {
+		t.Fatalf("expected the buffer to hold a single event, got %d", len(sub.events))
+	}
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxIdempotencyKeyLen = 128
+	// bounds the env of a task, counting the bytes of every key and value
+	maxEnvBytes = 16 << 10
+	// longest q accepted by the search endpoint
+	maxSearchQueryLen = 256
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+type createTaskRequest struct {
+	// optional client-chosen id, lets a caller reference tasks it is about to submit
+	ID                   string             `json:"id"`
+	Type                 model.TaskType     `json:"type"`
+	Payload              *model.TaskPayload `json:"payload"`
+	IdempotencyKey       string             `json:"idempotency_key"`
+	Priority             *int               `json:"priority"` // omitted takes the default of the type
+	MaxAttempts          int                `json:"max_attempts"`
+	TimeoutSeconds       int                `json:"timeout_seconds"`
+	DependsOn            []string           `json:"depends_on"`
+	RequiredCapabilities []string           `json:"required_capabilities"`
+	NotBefore            *time.Time         `json:"not_before"` // RFC 3339, not dispatched before
+	ExpiresAt            *time.Time         `json:"expires_at"` // RFC 3339, failed when still unclaimed by then
+	PendingTTLSeconds    int                `json:"pending_ttl_seconds"`
+	ExperimentID         string             `json:"experiment_id"`
+	Env                  map[string]string  `json:"env"` // free-form worker tuning, unlike the typed payload
+}
+
+func (req createTaskRequest) validate() error {
+	if req.ID != "" {
+		if err := uuid.Validate(req.ID); err != nil {
+			return fmt.Errorf("invalid task id %q", req.ID)
+		}
+	}
+	if !req.Type.Valid() {
+		return fmt.Errorf("unknown task type %q", req.Type)
+	}
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLen {
+		return fmt.Errorf("idempotency_key must be at most %d bytes", maxIdempotencyKeyLen)
+	}
+	if req.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	if req.TimeoutSeconds < 0 {
+		return fmt.Errorf("timeout_seconds must not be negative")
+	}
+	if req.PendingTTLSeconds < 0 {
+		return fmt.Errorf("pending_ttl_seconds must not be negative")
+	}
+	if req.ExpiresAt != nil && req.PendingTTLSeconds > 0 {
+		return fmt.Errorf("expires_at and pending_ttl_seconds are mutually exclusive")
+	}
+	if req.ExpiresAt != nil && req.NotBefore != nil && !req.ExpiresAt.After(*req.NotBefore) {
+		return fmt.Errorf("expires_at must be after not_before")
+	}
+	return validateEnv(req.Env)
+}
+
+func validateEnv(env map[string]string) error {
+	size := 0
+	for k, v := range env {
+		if !envKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid env key %q, keys must match %s", k, envKeyPattern)
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxEnvBytes {
+		return fmt.Errorf("env must be at most %d bytes", maxEnvBytes)
+	}
+	return nil
+}
+
+// checkTask answers 400 for a malformed request and 422 for a payload the validators reject
+func (s *Server) checkTask(req createTaskRequest) (int, error) {
+	if err := req.validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := s.payloads.Validate(req.Type, req.Payload); err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	return 0, nil
+}
+
+// newTask builds the task to submit, the scheduler fills in the other unset fields
+func (s *Server) newTask(req createTaskRequest) *model.Task {
+	var key *string
+	if req.IdempotencyKey != "" {
+		key = &req.IdempotencyKey
+	}
+	priority := s.sched.TaskType(req.Type).Priority
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	return &model.Task{
+		ID:                   req.ID,
+		Type:                 req.Type,
+		Payload:              req.Payload,
+		IdempotencyKey:       key,
+		Priority:             priority,
+		MaxAttempts:          req.MaxAttempts,
+		TimeoutSeconds:       req.TimeoutSeconds,
+		DependsOn:            req.DependsOn,
+		RequiredCapabilities: req.RequiredCapabilities,
+		NotBefore:            req.NotBefore,
+		ExpiresAt:            req.ExpiresAt,
+		PendingTTLSeconds:    req.PendingTTLSeconds,
+		ExperimentID:         req.ExperimentID,
+		Env:                  req.Env,
+	}
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	var req createTaskRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if status, err := s.checkTask(req); err != nil {
+		writeJSON(w, status, taskError(err, nil))
+		return
+	}
+
+	task := s.newTask(req)
+	err := s.sched.Submit(r.Context(), task)
+	if errors.Is(err, scheduler.ErrAlreadySubmitted) {
+		writeJSON(w, http.StatusOK, task)
+		return
+	}
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, task)
+}
+
+type batchResponse struct {
+	IDs []string `json:"ids"`
+}
+
+func (s *Server) createTaskBatch(w http.ResponseWriter, r *http.Request) {
+	var reqs []createTaskRequest
+	if err := decodeJSON(w, r, &reqs); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if len(reqs) == 0 {
+		writeError(w, http.StatusBadRequest, "batch is empty")
+		return
+	}
+	if len(reqs) > s.maxBatchSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch of %d tasks exceeds the limit of %d", len(reqs), s.maxBatchSize))
+		return
+	}
+
+	tasks := make([]*model.Task, len(reqs))
+	for i, req := range reqs {
+		if status, err := s.checkTask(req); err != nil {
+			writeJSON(w, status, taskError(fmt.Errorf("task %d: %w", i, err), &i))
+			return
+		}
+		tasks[i] = s.newTask(req)
+	}
+	if err := s.sched.SubmitBatch(r.Context(), tasks); err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+
+	resp := batchResponse{IDs: make([]string, len(tasks))}
+	for i, task := range tasks {
+		resp.IDs[i] = task.ID
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tasks, err := s.sched.List(r.Context(), filter)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+func (s *Server) searchTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseTaskFilter(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Search = strings.TrimSpace(q.Get("q"))
+	if filter.Search == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	if len(filter.Search) > maxSearchQueryLen {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("q is longer than %d bytes", maxSearchQueryLen))
+		return
+	}
+	tasks, err := s.sched.List(r.Context(), filter)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// parseTaskFilter reads the filters shared by the listing and search endpoints
+func parseTaskFilter(q url.Values) (scheduler.TaskFilter, error) {
+	filter := scheduler.TaskFilter{
+		Status:       model.TaskStatus(q.Get("status")),
+		Type:         model.TaskType(q.Get("type")),
+		WorkerID:     q.Get("worker_id"),
+		ExperimentID: q.Get("experiment_id"),
+	}
+	if v := q.Get("include_archived"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid include_archived %q", v)
+		}
+		filter.IncludeArchived = include
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		return filter, fmt.Errorf("unknown task status %q", filter.Status)
+	}
+	if filter.Type != "" && !filter.Type.Valid() {
+		return filter, fmt.Errorf("unknown task type %q", filter.Type)
+	}
+	var err error
+	filter.Limit, filter.Offset, err = parsePage(q)
+	return filter, err
+}
+
+func (s *Server) cancelTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Cancel(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+type reportTaskRequest struct {
+	WorkerID       string            `json:"worker_id"`
+	Status         model.TaskStatus  `json:"status"`
+	Result         string            `json:"result"`
+	ResultData     *model.TaskResult `json:"result_data"`
+	ArtifactName   string            `json:"artifact_name"`
+	ArtifactSize   int64             `json:"artifact_size"`
+	ArtifactSHA256 string            `json:"artifact_sha256"`
+}
+
+func (s *Server) reportTask(w http.ResponseWriter, r *http.Request) {
+	var req reportTaskRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	var ok bool
+	if req.WorkerID, ok = boundWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+	if req.WorkerID == "" {
+		writeError(w, http.StatusBadRequest, "worker_id is required")
+		return
+	}
+
+	task, err := s.sched.Report(asWorker(r.Context(), req.WorkerID), r.PathValue("id"), scheduler.Report{
+		WorkerID:       req.WorkerID,
+		Status:         req.Status,
+		Result:         req.Result,
+		ResultData:     req.ResultData,
+		ArtifactName:   req.ArtifactName,
+		ArtifactSize:   req.ArtifactSize,
+		ArtifactSHA256: req.ArtifactSHA256,
+	})
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+type progressRequest struct {
+	WorkerID string `json:"worker_id"`
+	Progress int    `json:"progress"`
+}
+
+func (s *Server) reportProgress(w http.ResponseWriter, r *http.Request) {
+	var req progressRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	var ok bool
+	if req.WorkerID, ok = boundWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+	task, err := s.sched.UpdateProgress(asWorker(r.Context(), req.WorkerID), r.PathValue("id"), req.WorkerID, req.Progress)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+type renewRequest struct {
+	WorkerID string `json:"worker_id"`
+}
+
+// renewLease keeps a long running task from being reclaimed, workers call it well within the lease
+func (s *Server) renewLease(w http.ResponseWriter, r *http.Request) {
+	var req renewRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	var ok bool
+	if req.WorkerID, ok = boundWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+	task, err := s.sched.Renew(asWorker(r.Context(), req.WorkerID), r.PathValue("id"), req.WorkerID)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// listDeadLetter pages through tasks that ran out of retries, most recent first
+func (s *Server) listDeadLetter(w http.ResponseWriter, r *http.Request) {
+	filter := scheduler.TaskFilter{Status: model.StatusDeadLettered, Type: model.TaskType(r.URL.Query().Get("type"))}
+	if filter.Type != "" && !filter.Type.Valid() {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown task type %q", filter.Type))
+		return
+	}
+	var err error
+	if filter.Limit, filter.Offset, err = parsePage(r.URL.Query()); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tasks, err := s.sched.List(r.Context(), filter)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+func (s *Server) requeueTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Requeue(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (s *Server) rerunTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.sched.Rerun(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, task)
+}
+
+type taskTypeResponse struct {
+	scheduler.TaskTypeConfig
+	MaxPayloadBytes int `json:"max_payload_bytes"`
+}
+
+// taskTypes lists every task type with the defaults its tasks start from
+func (s *Server) taskTypes(w http.ResponseWriter, r *http.Request) {
+	types := s.sched.TaskTypes()
+	resp := make([]taskTypeResponse, len(types))
+	for i, c := range types {
+		resp[i] = taskTypeResponse{TaskTypeConfig: c, MaxPayloadBytes: s.payloads.MaxBytes(c.Type)}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,439 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+	"Server/pkgs/ratelimit"
+	"Server/pkgs/scheduler"
+	"Server/pkgs/validation"
+)
+
+func TestCreateTaskBatch(t *testing.T) {
+	srv := newTestServer(t)
+	srv.maxBatchSize = 3
+
+	vmcoreID := "6f1c1a52-3c53-4a43-9c43-54d8a1c8a001"
+	rec := do(t, srv, http.MethodPost, "/tasks/batch", []map[string]any{
+		taskBody(model.TaskTypePatchApply, map[string]any{"depends_on": []string{vmcoreID}}),
+		taskBody(model.TaskTypeGetVmcore, map[string]any{"id": vmcoreID}),
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("batch: %d %s", rec.Code, rec.Body)
+	}
+	resp := decode[batchResponse](t, rec)
+	if len(resp.IDs) != 2 || resp.IDs[1] != vmcoreID {
+		t.Fatalf("unexpected ids %v", resp.IDs)
+	}
+
+	rec = do(t, srv, http.MethodPost, "/tasks/batch", []map[string]any{
+		taskBody(model.TaskTypeGetVmcore, nil),
+		{"type": "bogus"},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid batch: %d %s", rec.Code, rec.Body)
+	}
+	if e := decode[errorResponse](t, rec); e.Index == nil || *e.Index != 1 {
+		t.Fatalf("expected the offending index 1, got %+v", e)
+	}
+
+	rec = do(t, srv, http.MethodPost, "/tasks/batch", []map[string]any{
+		taskBody(model.TaskTypeGetVmcore, nil),
+		taskBody(model.TaskTypeGetVmcore, map[string]any{"depends_on": []string{"unknown"}}),
+	})
+	if e := decode[errorResponse](t, rec); rec.Code != http.StatusBadRequest || e.Index == nil || *e.Index != 1 {
+		t.Fatalf("unknown dependency: %d %+v", rec.Code, e)
+	}
+
+	// neither failed batch may leave anything behind
+	rec = do(t, srv, http.MethodGet, "/tasks", nil)
+	if tasks := decode[[]model.Task](t, rec); len(tasks) != 2 {
+		t.Fatalf("expected only the first batch to be stored, got %d tasks", len(tasks))
+	}
+
+	rec = do(t, srv, http.MethodPost, "/tasks/batch", make([]map[string]any, 4))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized batch: expected 413, got %d", rec.Code)
+	}
+}
+
+func TestCreateTaskIdempotencyKey(t *testing.T) {
+	srv := newTestServer(t)
+	body := taskBody(model.TaskTypeGetVmcore, map[string]any{"idempotency_key": "triage-42"})
+
+	first := do(t, srv, http.MethodPost, "/tasks", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first create: %d %s", first.Code, first.Body)
+	}
+	second := do(t, srv, http.MethodPost, "/tasks", body)
+	if second.Code != http.StatusOK {
+		t.Fatalf("replayed create: expected 200, got %d %s", second.Code, second.Body)
+	}
+	if a, b := decode[model.Task](t, first), decode[model.Task](t, second); a.ID != b.ID {
+		t.Fatalf("replay created a new task: %s != %s", a.ID, b.ID)
+	}
+
+	// the key is scoped by type
+	other := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, map[string]any{"idempotency_key": "triage-42"}))
+	if other.Code != http.StatusCreated {
+		t.Fatalf("same key for another type: expected 201, got %d", other.Code)
+	}
+	// tasks without a key never collide
+	for range 2 {
+		if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)); rec.Code != http.StatusCreated {
+			t.Fatalf("keyless create: %d %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestTaskProgress(t *testing.T) {
+	srv := newTestServer(t)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	path := "/tasks/" + created.ID + "/progress"
+
+	if rec := do(t, srv, http.MethodPost, path, map[string]any{"worker_id": "worker-1", "progress": 10}); rec.Code != http.StatusConflict {
+		t.Fatalf("pending task: expected 409, got %d", rec.Code)
+	}
+	if rec, _ := claim(srv, "worker-1", 0); rec.Code != http.StatusOK {
+		t.Fatalf("claim: %d", rec.Code)
+	}
+
+	for _, tc := range []struct {
+		worker   string
+		progress int
+		code     int
+	}{
+		{"worker-1", 40, http.StatusOK},
+		{"worker-1", 40, http.StatusOK},
+		{"worker-1", 20, http.StatusBadRequest},
+		{"worker-1", 101, http.StatusBadRequest},
+		{"worker-2", 60, http.StatusForbidden},
+	} {
+		rec := do(t, srv, http.MethodPost, path, map[string]any{"worker_id": tc.worker, "progress": tc.progress})
+		if rec.Code != tc.code {
+			t.Fatalf("progress %d from %s: expected %d, got %d %s", tc.progress, tc.worker, tc.code, rec.Code, rec.Body)
+		}
+	}
+	if got := decode[model.Task](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID, nil)); got.Progress != 40 {
+		t.Fatalf("expected progress 40, got %d", got.Progress)
+	}
+
+	rec := do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusSuccess})
+	if got := decode[model.Task](t, rec); got.Progress != 100 {
+		t.Fatalf("success should set progress to 100, got %d", got.Progress)
+	}
+}
+
+func TestCreateTaskRateLimit(t *testing.T) {
+	srv := newTestServer(t)
+	srv.limiter = ratelimit.New(config.RateLimitConfig{
+		RateLimitRule: config.RateLimitRule{RequestsPerMinute: 1, Burst: 1},
+		Keys:          map[string]config.RateLimitRule{"trusted": {RequestsPerMinute: 600, Burst: 5}},
+	})
+
+	create := func(key string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(taskBody(model.TaskTypePatchApply, nil))
+		req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := create("script"); rec.Code != http.StatusCreated {
+		t.Fatalf("first request: %d %s", rec.Code, rec.Body)
+	}
+	rec := create("script")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 429 with Retry-After 60, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := create(""); rec.Code != http.StatusCreated {
+		t.Fatalf("anonymous caller shares no bucket with keys: %d", rec.Code)
+	}
+	if rec := create(""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("anonymous callers should be throttled globally, got %d", rec.Code)
+	}
+	for i := range 5 {
+		if rec := create("trusted"); rec.Code != http.StatusCreated {
+			t.Fatalf("trusted request %d: %d", i, rec.Code)
+		}
+	}
+}
+
+func TestDeadLetterRequeue(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)))
+	do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	claim(srv, "worker-1", 0)
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusFailed, "result": "hunk 3 rejected"})
+
+	dead := decode[[]model.Task](t, do(t, srv, http.MethodGet, "/tasks/dead-letter", nil))
+	if len(dead) != 1 || dead[0].ID != created.ID || dead[0].Result != "hunk 3 rejected" {
+		t.Fatalf("unexpected dead-letter listing %+v", dead)
+	}
+
+	rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/requeue", nil, APIKeyHeader, "admin-key")
+	if got := decode[model.Task](t, rec); rec.Code != http.StatusOK || got.Status != model.StatusPending || got.Attempts != 0 {
+		t.Fatalf("requeue: %d %+v", rec.Code, got)
+	}
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/requeue", nil, APIKeyHeader, "admin-key"); rec.Code != http.StatusConflict {
+		t.Fatalf("requeue of a pending task: expected 409, got %d", rec.Code)
+	}
+	if dead := decode[[]model.Task](t, do(t, srv, http.MethodGet, "/tasks/dead-letter", nil)); len(dead) != 0 {
+		t.Fatalf("requeued task still dead-lettered: %+v", dead)
+	}
+}
+
+func TestCreateTaskNotBefore(t *testing.T) {
+	srv := newTestServer(t)
+	rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, map[string]any{"not_before": "2030-01-01T02:00:00+08:00"}))
+	got := decode[model.Task](t, rec)
+	if rec.Code != http.StatusCreated || got.NotBefore == nil || got.NotBefore.UTC().Hour() != 18 {
+		t.Fatalf("create: %d not_before=%v", rec.Code, got.NotBefore)
+	}
+	if rec, _ := claim(srv, "worker-1", 0); rec.Code != http.StatusNoContent {
+		t.Fatalf("delayed task dispatched: %d", rec.Code)
+	}
+	if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, map[string]any{"not_before": "tonight"})); rec.Code != http.StatusBadRequest {
+		t.Fatalf("malformed not_before: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestCreateTaskExpiry(t *testing.T) {
+	srv := newTestServer(t)
+	rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, map[string]any{"pending_ttl_seconds": 3600}))
+	got := decode[model.Task](t, rec)
+	if rec.Code != http.StatusCreated || got.ExpiresAt == nil || got.ExpiresAt.Sub(got.CreatedAt) != time.Hour {
+		t.Fatalf("create: %d expires_at=%v created_at=%v", rec.Code, got.ExpiresAt, got.CreatedAt)
+	}
+
+	for _, bad := range []map[string]any{
+		{"pending_ttl_seconds": -1},
+		{"pending_ttl_seconds": 60, "expires_at": "2030-01-01T00:00:00Z"},
+		{"not_before": "2030-01-01T00:00:00Z", "expires_at": "2029-12-31T00:00:00Z"},
+	} {
+		if rec := do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, bad)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", bad, rec.Code)
+		}
+	}
+}
+
+func TestConcurrentCompletionOnlyOneWins(t *testing.T) {
+	srv := newTestServer(t)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)))
+	claim(srv, "worker-1", 0)
+
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, status := range []model.TaskStatus{model.StatusSuccess, model.StatusFailed} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]any{"worker_id": "worker-1", "status": status})
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+created.ID+"/report", bytes.NewReader(body)))
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	got := map[int]int{}
+	for code := range codes {
+		got[code]++
+	}
+	if got[http.StatusOK] != 1 || got[http.StatusConflict] != 1 {
+		t.Fatalf("expected one winner and one 409, got %v", got)
+	}
+	if task := decode[model.Task](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID, nil)); task.Version != 2 {
+		t.Fatalf("expected version 2 after claim and completion, got %d", task.Version)
+	}
+}
+
+func TestRetriedCompletionIsIdempotent(t *testing.T) {
+	srv := newTestServer(t)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypePatchApply, nil)))
+	claim(srv, "worker-1", 0)
+	up := httptest.NewRecorder()
+	srv.ServeHTTP(up, httptest.NewRequest(http.MethodPut, "/tasks/"+created.ID+"/artifact?worker_id=worker-1&name=bzImage", strings.NewReader("bzImage")))
+	if up.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", up.Code, up.Body)
+	}
+	uploaded := decode[uploadArtifactResponse](t, up)
+
+	report := map[string]any{
+		"worker_id":       "worker-1",
+		"status":          model.StatusSuccess,
+		"result":          "booted",
+		"artifact_name":   "bzImage",
+		"artifact_size":   uploaded.Size,
+		"artifact_sha256": uploaded.SHA256,
+	}
+	first := do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", report)
+	second := do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", report)
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("report twice: %d then %d %s", first.Code, second.Code, second.Body)
+	}
+	a, b := decode[model.Task](t, first), decode[model.Task](t, second)
+	if b.Status != model.StatusSuccess || b.Version != a.Version || !b.FinishedAt.Equal(*a.FinishedAt) || b.ArtifactPath != a.ArtifactPath {
+		t.Fatalf("the retry changed the task: %+v after %+v", b, a)
+	}
+
+	report["artifact_sha256"] = strings.Repeat("0", 64)
+	if rec := do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", report); rec.Code != http.StatusConflict {
+		t.Fatalf("conflicting report: expected 409, got %d", rec.Code)
+	}
+	if got := decode[model.Task](t, do(t, srv, http.MethodGet, "/tasks/"+created.ID, nil)); got.Status != model.StatusSuccess || got.ArtifactSHA256 != uploaded.SHA256 {
+		t.Fatalf("final state: %+v", got)
+	}
+}
+
+func TestRerunClonesTheWork(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, map[string]any{
+		"priority":              7,
+		"required_capabilities": []string{"kdump"},
+		"idempotency_key":       "crash-42",
+	})))
+	if rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/rerun", nil, APIKeyHeader, "admin-key"); rec.Code != http.StatusConflict {
+		t.Fatalf("rerun of a pending task: expected 409, got %d", rec.Code)
+	}
+
+	if _, err := srv.sched.Heartbeat(context.Background(), "worker-1", []string{"kdump"}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	claim(srv, "worker-1", 0)
+	do(t, srv, http.MethodPost, "/tasks/"+created.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusSuccess, "result": "vmcore fetched"})
+
+	rec := doWithHeader(t, srv, http.MethodPost, "/tasks/"+created.ID+"/rerun", nil, APIKeyHeader, "admin-key")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("rerun: %d %s", rec.Code, rec.Body)
+	}
+	clone := decode[model.Task](t, rec)
+	if clone.ID == created.ID || clone.RerunOf != created.ID || clone.Status != model.StatusPending {
+		t.Fatalf("unexpected clone identity: %+v", clone)
+	}
+	if clone.Priority != 7 || clone.Payload == nil || clone.Payload.GetVmcore.TargetHost != created.Payload.GetVmcore.TargetHost || len(clone.RequiredCapabilities) != 1 {
+		t.Fatalf("clone lost the work description: %+v", clone)
+	}
+	if clone.Result != "" || clone.StartedAt != nil || clone.FinishedAt != nil || clone.Attempts != 0 || clone.IdempotencyKey != nil {
+		t.Fatalf("clone copied execution state: %+v", clone)
+	}
+}
+
+func TestSearchTasks(t *testing.T) {
+	srv := newTestServer(t)
+	reports := []map[string]any{
+		{"status": model.StatusFailed, "result": "BUG: unable to handle page fault in ext4_writepages"},
+		{"status": model.StatusSuccess, "result": "vmcore fetched", "result_data": model.NewVmcoreResult(model.VmcoreResult{
+			KernelVersion: "6.6.30", CrashSignature: "RIP: nfs_commit_release+0x1c",
+		})},
+		{"status": model.StatusSuccess, "result": "fetched 100% of the dump"},
+	}
+	ids := make([]string, len(reports))
+	for i, report := range reports {
+		do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+		rec, _ := claim(srv, "worker-1", 0)
+		task := decode[model.Task](t, rec)
+		report["worker_id"] = "worker-1"
+		if rec := do(t, srv, http.MethodPost, "/tasks/"+task.ID+"/report", report); rec.Code != http.StatusOK {
+			t.Fatalf("report: %d %s", rec.Code, rec.Body)
+		}
+		ids[i] = task.ID
+	}
+
+	search := func(query string) []string {
+		t.Helper()
+		rec := do(t, srv, http.MethodGet, "/tasks/search?"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("search %q: %d %s", query, rec.Code, rec.Body)
+		}
+		var found []string
+		for _, task := range decode[[]model.Task](t, rec) {
+			found = append(found, task.ID)
+		}
+		return found
+	}
+	for query, want := range map[string][]string{
+		"q=EXT4_WRITEPAGES":          {ids[0]},
+		"q=nfs_commit":               {ids[1]},
+		"q=100%25":                   {ids[2]},
+		"q=%25":                      {ids[2]}, // wildcards are matched literally
+		"q=ext4&status=success":      nil,
+		"q=fetched&status=success":   {ids[2], ids[1]},
+		"q=fetched&limit=1&offset=1": {ids[1]},
+	} {
+		if got := search(query); !slices.Equal(got, want) {
+			t.Errorf("search %q = %v, want %v", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"", "q=++", "q=" + strings.Repeat("a", maxSearchQueryLen+1), "q=bug&type=nope"} {
+		if rec := do(t, srv, http.MethodGet, "/tasks/search?"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("search %q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestRenewLease(t *testing.T) {
+	srv := newTestServer(t)
+	created := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	path := "/tasks/" + created.ID + "/renew"
+
+	if rec := do(t, srv, http.MethodPost, path, map[string]any{"worker_id": "worker-1"}); rec.Code != http.StatusConflict {
+		t.Fatalf("pending task: expected 409, got %d", rec.Code)
+	}
+	rec, _ := claim(srv, "worker-1", 0)
+	claimed := decode[model.Task](t, rec)
+	if claimed.LeaseExpiresAt == nil {
+		t.Fatalf("claim granted no lease: %+v", claimed)
+	}
+
+	if rec := do(t, srv, http.MethodPost, path, map[string]any{"worker_id": "worker-2"}); rec.Code != http.StatusForbidden {
+		t.Fatalf("renewal by another worker: expected 403, got %d", rec.Code)
+	}
+	rec = do(t, srv, http.MethodPost, path, map[string]any{"worker_id": "worker-1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("renew: %d %s", rec.Code, rec.Body)
+	}
+	if renewed := decode[model.Task](t, rec); renewed.LeaseExpiresAt.Before(*claimed.LeaseExpiresAt) {
+		t.Fatalf("lease moved backwards: %v -> %v", claimed.LeaseExpiresAt, renewed.LeaseExpiresAt)
+	}
+}
+
+func TestTaskTypeDefaults(t *testing.T) {
+	artifacts := artifact.NewMemory()
+	sched := scheduler.New(newTestDB(t), scheduler.RetryPolicy{MaxAttempts: 1}, scheduler.WithArtifactStore(artifacts),
+		scheduler.WithTaskTypes([]scheduler.TaskTypeConfig{{Type: model.TaskTypeGetVmcore, TimeoutSeconds: 600, Priority: 5}}))
+	srv := New(sched, artifacts)
+
+	defaulted := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil)))
+	if defaulted.Priority != 5 || defaulted.TimeoutSeconds != 600 {
+		t.Fatalf("defaults not applied: %+v", defaulted)
+	}
+	explicit := decode[model.Task](t, do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, map[string]any{"priority": 0})))
+	if explicit.Priority != 0 {
+		t.Fatalf("explicit priority 0 was replaced by %d", explicit.Priority)
+	}
+
+	types := decode[[]taskTypeResponse](t, do(t, srv, http.MethodGet, "/task-types", nil))
+	if len(types) != len(model.TaskTypes) || types[0].Type != model.TaskTypeGetVmcore || types[0].Priority != 5 ||
+		types[0].MaxAttempts != 1 || types[0].MaxPayloadBytes != validation.DefaultMaxPayloadBytes || types[1].TimeoutSeconds != 30*60 {
+		t.Fatalf("unexpected task types %+v", types)
+	}
+}
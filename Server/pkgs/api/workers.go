@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+
+	"Server/pkgs/model"
+)
+
+type heartbeatResponse struct {
+	Worker *model.Worker `json:"worker"`
+	// running tasks the worker must abort without uploading an artifact
+	CancelTasks []string `json:"cancel_tasks"`
+}
+
+type heartbeatRequest struct {
+	// omitted capabilities keep whatever the worker announced before
+	Capabilities []string `json:"capabilities"`
+}
+
+func (s *Server) heartbeat(w http.ResponseWriter, r *http.Request) {
+	var req heartbeatRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+	}
+	if _, ok := boundWorker(w, r, r.PathValue("id")); !ok {
+		return
+	}
+	worker, err := s.sched.Heartbeat(r.Context(), r.PathValue("id"), req.Capabilities)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	cancels, err := s.sched.PendingCancels(r.Context(), worker.ID)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, heartbeatResponse{Worker: worker, CancelTasks: cancels})
+}
+
+// worker states shown by the listing
+const (
+	workerActive   = "active"
+	workerDraining = "draining"
+	// draining and idle, safe to shut down
+	workerDrained = "drained"
+)
+
+type workerResponse struct {
+	model.Worker
+	Status       string `json:"status"`
+	RunningTasks int64  `json:"running_tasks"`
+}
+
+func (s *Server) listWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, err := s.sched.ListWorkers(r.Context())
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	running, err := s.sched.RunningByWorker(r.Context())
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+
+	resp := make([]workerResponse, len(workers))
+	for i, worker := range workers {
+		status := workerActive
+		if worker.Draining {
+			status = workerDraining
+			if running[worker.ID] == 0 {
+				status = workerDrained
+			}
+		}
+		resp[i] = workerResponse{Worker: worker, Status: status, RunningTasks: running[worker.ID]}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// workerStats shows how many runs of each outcome a worker had and how busy it was
+func (s *Server) workerStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.sched.WorkerStats(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) drainWorker(w http.ResponseWriter, r *http.Request) {
+	s.setDraining(w, r, true)
+}
+
+func (s *Server) resumeWorker(w http.ResponseWriter, r *http.Request) {
+	s.setDraining(w, r, false)
+}
+
+func (s *Server) setDraining(w http.ResponseWriter, r *http.Request, draining bool) {
+	worker, err := s.sched.SetDraining(r.Context(), r.PathValue("id"), draining)
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, worker)
+}
+
+// concurrency shows how close every task type is to its running limit
+func (s *Server) concurrency(w http.ResponseWriter, r *http.Request) {
+	usage, err := s.sched.ConcurrencyUsage(r.Context())
+	if err != nil {
+		writeSchedulerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
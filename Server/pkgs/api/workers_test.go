@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+func TestWorkerDrain(t *testing.T) {
+	srv := newTestServer(t)
+	WithAdminKeys([]string{"admin-key"})(srv)
+	admin := func(method, target string) *httptest.ResponseRecorder {
+		return doWithHeader(t, srv, method, target, nil, APIKeyHeader, "admin-key")
+	}
+	for range 2 {
+		do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	}
+	if rec, _ := claim(srv, "worker-1", 0); rec.Code != http.StatusOK {
+		t.Fatalf("claim: %d", rec.Code)
+	}
+	running := decode[[]model.Task](t, do(t, srv, http.MethodGet, "/tasks?status=running", nil))[0]
+
+	if rec := admin(http.MethodPost, "/workers/ghost/drain"); rec.Code != http.StatusNotFound {
+		t.Fatalf("drain of an unknown worker: expected 404, got %d", rec.Code)
+	}
+	if rec := admin(http.MethodPost, "/workers/worker-1/drain"); rec.Code != http.StatusOK || !decode[model.Worker](t, rec).Draining {
+		t.Fatalf("drain: %d", rec.Code)
+	}
+	if rec, _ := claim(srv, "worker-1", 0); rec.Code != http.StatusNoContent {
+		t.Fatalf("draining worker was handed a task: %d", rec.Code)
+	}
+	// heartbeats must not clear the flag
+	do(t, srv, http.MethodPost, "/workers/worker-1/heartbeat", nil)
+
+	status := func() workerResponse {
+		workers := decode[[]workerResponse](t, do(t, srv, http.MethodGet, "/workers", nil))
+		if len(workers) != 1 {
+			t.Fatalf("unexpected workers %+v", workers)
+		}
+		return workers[0]
+	}
+	if got := status(); got.Status != workerDraining || got.RunningTasks != 1 {
+		t.Fatalf("before finishing: %+v", got)
+	}
+	do(t, srv, http.MethodPost, "/tasks/"+running.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusSuccess})
+	if got := status(); got.Status != workerDrained || got.RunningTasks != 0 {
+		t.Fatalf("after finishing: %+v", got)
+	}
+
+	admin(http.MethodDelete, "/workers/worker-1/drain")
+	if rec, _ := claim(srv, "worker-1", 0); rec.Code != http.StatusOK {
+		t.Fatalf("resumed worker got no task: %d", rec.Code)
+	}
+}
+
+func TestConcurrencyUsage(t *testing.T) {
+	artifacts := artifact.NewMemory()
+	sched := scheduler.New(newTestDB(t), scheduler.RetryPolicy{MaxAttempts: 1},
+		scheduler.WithArtifactStore(artifacts),
+		scheduler.WithConcurrencyLimits(map[model.TaskType]int{model.TaskTypeGetVmcore: 1}))
+	srv := New(sched, artifacts)
+	for range 2 {
+		do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	}
+	claim(srv, "worker-1", 0)
+	if rec, _ := claim(srv, "worker-2", 0); rec.Code != http.StatusNoContent {
+		t.Fatalf("claim past the limit: %d", rec.Code)
+	}
+
+	usage := decode[[]scheduler.TypeUsage](t, do(t, srv, http.MethodGet, "/concurrency", nil))
+	if len(usage) != len(model.TaskTypes) || usage[0] != (scheduler.TypeUsage{Type: model.TaskTypeGetVmcore, Running: 1, Limit: 1}) {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	srv := newTestServer(t)
+	if rec := do(t, srv, http.MethodGet, "/workers/ghost/stats", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("stats of an unknown worker: expected 404, got %d", rec.Code)
+	}
+	for range 2 {
+		do(t, srv, http.MethodPost, "/tasks", taskBody(model.TaskTypeGetVmcore, nil))
+	}
+	rec, _ := claim(srv, "worker-1", 0)
+	first := decode[model.Task](t, rec)
+	claim(srv, "worker-1", 0)
+	do(t, srv, http.MethodPost, "/tasks/"+first.ID+"/report", map[string]any{"worker_id": "worker-1", "status": model.StatusSuccess})
+
+	rec = do(t, srv, http.MethodGet, "/workers/worker-1/stats", nil)
+	stats := decode[scheduler.WorkerStats](t, rec)
+	if rec.Code != http.StatusOK || stats.Running != 1 || stats.Lifetime.Total != 1 || stats.Window.Outcomes[model.RunSucceeded] != 1 {
+		t.Fatalf("stats: %d %+v", rec.Code, stats)
+	}
+}
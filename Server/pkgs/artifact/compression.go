@@ -0,0 +1,115 @@
+package artifact
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// content encodings an artifact may be stored in, empty means uncompressed
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Encodings lists every storage encoding, uncompressed first
+var Encodings = []string{"", Gzip, Zstd}
+
+var ErrCorrupt = errors.New("corrupt compressed artifact")
+
+func ValidEncoding(enc string) bool {
+	return enc == "" || enc == Gzip || enc == Zstd
+}
+
+// EncodedKey is where an artifact stored with enc lives. Compressed artifacts get their own
+// directory level, names cannot contain a slash so the keys never collide.
+func EncodedKey(taskID, name, enc string) string {
+	if enc == "" {
+		return Key(taskID, name)
+	}
+	return taskID + "/" + enc + "/" + name
+}
+
+// NewReader decompresses r stored with enc
+func NewReader(r io.Reader, enc string) (io.ReadCloser, error) {
+	switch enc {
+	case "":
+		return io.NopCloser(r), nil
+	case Gzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		return zr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported artifact encoding %q", enc)
+}
+
+// Upload describes a stored artifact, Size and SHA256 are of the uncompressed content
+type Upload struct {
+	Size       int64
+	SHA256     string
+	StoredSize int64
+}
+
+// PutEncoded stores r, compressed with enc, as is and decompresses it on the fly to
+// checksum the content. A stream that fails to decompress is not kept.
+func PutEncoded(ctx context.Context, s Store, key string, r io.Reader, enc string) (Upload, error) {
+	if enc == "" {
+		size, sum, err := s.Put(ctx, key, r)
+		return Upload{Size: size, SHA256: sum, StoredSize: size}, err
+	}
+
+	type content struct {
+		size int64
+		sum  string
+		err  error
+	}
+	pr, pw := io.Pipe()
+	done := make(chan content, 1)
+	go func() {
+		var c content
+		defer func() { done <- c }()
+		zr, err := NewReader(pr, enc)
+		if err != nil {
+			c.err = err
+			pr.CloseWithError(err)
+			return
+		}
+		defer zr.Close()
+		hash := sha256.New()
+		if c.size, err = io.Copy(hash, zr); err != nil {
+			c.err = fmt.Errorf("%w: %w", ErrCorrupt, err)
+			pr.CloseWithError(c.err)
+			return
+		}
+		c.sum = hex.EncodeToString(hash.Sum(nil))
+		// anything after the end of the stream is stored too, keep the writer unblocked
+		io.Copy(io.Discard, pr)
+	}()
+
+	stored, _, err := s.Put(ctx, key, io.TeeReader(r, pw))
+	pw.CloseWithError(err)
+	c := <-done
+	if err != nil && (c.err == nil || errors.Is(c.err, err)) {
+		// the store failed on its own, the decoder only saw the pipe close
+		return Upload{}, err
+	}
+	if c.err != nil {
+		s.Delete(ctx, key)
+		return Upload{}, c.err
+	}
+	return Upload{Size: c.size, SHA256: c.sum, StoredSize: stored}, nil
+}
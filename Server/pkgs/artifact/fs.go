@@ -0,0 +1,124 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS keeps artifacts on the local filesystem under <dir>/<task id>/<name>
+type FS struct {
+	dir string
+}
+
+func NewFS(dir string) *FS {
+	return &FS{dir: dir}
+}
+
+// path maps a key to a file below dir. Tasks stored before keys existed carry the
+// full file path instead, those are accepted as long as they point into dir.
+func (s *FS) path(key string) (string, error) {
+	root := filepath.Clean(s.dir)
+	if legacy := filepath.Clean(key); strings.HasPrefix(legacy, root+string(filepath.Separator)) {
+		if rel, err := filepath.Rel(root, legacy); err == nil && validKey(filepath.ToSlash(rel)) {
+			return legacy, nil
+		}
+	}
+	if !validKey(key) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(root, filepath.FromSlash(key)), nil
+}
+
+func (s *FS) Put(_ context.Context, key string, r io.Reader) (int64, string, error) {
+	if !validKey(key) {
+		return 0, "", ErrInvalidName
+	}
+	path, err := s.path(key)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, "", err
+	}
+
+	// write to a temp file first so a broken upload never replaces a good artifact
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to write artifact %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *FS) Get(_ context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Info{}, notFound(key, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	return f, Info{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *FS) Stat(_ context.Context, key string) (Info, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, notFound(key, err)
+	}
+	return Info{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *FS) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return notFound(key, err)
+	}
+	// drop the per task directories once they are empty, failure just means they are not
+	root := filepath.Clean(s.dir)
+	for dir := filepath.Dir(path); len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func notFound(key string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Memory keeps artifacts in memory, it is meant for tests
+type Memory struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]memoryObject)}
+}
+
+func (s *Memory) Put(_ context.Context, key string, r io.Reader) (int64, string, error) {
+	if !validKey(key) {
+		return 0, "", ErrInvalidName
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to write artifact %s: %w", key, err)
+	}
+	s.mu.Lock()
+	s.objects[key] = memoryObject{data: data, modTime: time.Now()}
+	s.mu.Unlock()
+	sum := sha256.Sum256(data)
+	return int64(len(data)), hex.EncodeToString(sum[:]), nil
+}
+
+func (s *Memory) Get(_ context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	obj, err := s.lookup(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return nopCloser{bytes.NewReader(obj.data)}, obj.info(), nil
+}
+
+func (s *Memory) Stat(_ context.Context, key string) (Info, error) {
+	obj, err := s.lookup(key)
+	if err != nil {
+		return Info{}, err
+	}
+	return obj.info(), nil
+}
+
+func (s *Memory) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *Memory) lookup(key string) (memoryObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return memoryObject{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return obj, nil
+}
+
+func (o memoryObject) info() Info {
+	return Info{Size: int64(len(o.data)), ModTime: o.modTime}
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
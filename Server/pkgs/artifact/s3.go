@@ -0,0 +1,115 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"Server/pkgs/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// multipart chunk size, bounds the memory an upload of unknown length needs
+const s3PartSize = 16 << 20
+
+// S3 keeps artifacts in an S3 compatible bucket under <prefix>/<task id>/<name>
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3(cfg config.S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 artifact backend needs an endpoint and a bucket")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3) object(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidName
+	}
+	return path.Join(s.prefix, key), nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, string, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	info, err := s.client.PutObject(ctx, s.bucket, name, io.TeeReader(r, hash), -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s3PartSize,
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to write artifact %s: %w", key, err)
+	}
+	return info.Size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	// the object fetches lazily and turns seeks into ranged requests
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Info{}, s.notFound(key, err)
+	}
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, Info{}, s.notFound(key, err)
+	}
+	return obj, Info{Size: stat.Size, ModTime: stat.LastModified}, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s.notFound(key, err)
+	}
+	return Info{Size: stat.Size, ModTime: stat.LastModified}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	name, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	// removing a missing object is not an error in s3, stat first to keep the contract
+	if _, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{}); err != nil {
+		return s.notFound(key, err)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) notFound(key string, err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"Server/pkgs/config"
+)
+
+var (
+	ErrInvalidName = errors.New("invalid artifact name")
+	ErrNotFound    = errors.New("artifact not found")
+)
+
+// Store is an artifact storage backend, artifacts are addressed by a backend agnostic key
+// as returned by Key
+type Store interface {
+	// Put streams r into the store and returns the size and hex sha256 of what was written
+	Put(ctx context.Context, key string, r io.Reader) (int64, string, error)
+	// Get opens an artifact for reading, the caller has to close it
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Open builds the backend selected by the config, the local filesystem is the default
+func Open(cfg config.ArtifactConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "fs":
+		return NewFS(cfg.Dir), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unsupported artifact backend %q", cfg.Backend)
+	}
+}
+
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// Key is where the artifact name of a task lives in the store
+func Key(taskID, name string) string {
+	return taskID + "/" + name
+}
+
+func validKey(key string) bool {
+	for _, part := range strings.Split(key, "/") {
+		if !ValidName(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checksum reads back a stored artifact and returns the size and hex sha256 of its
+// content, decompressing it when it is stored with enc
+func Checksum(ctx context.Context, s Store, key, enc string) (int64, string, error) {
+	f, _, err := s.Get(ctx, key)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	r, err := NewReader(f, enc)
+	if err != nil {
+		return 0, "", err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		if enc != "" {
+			err = fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,117 @@
+package artifact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	key := Key("task-1", "vmcore")
+
+	size, sum, err := s.Put(ctx, key, strings.NewReader("vmcore bytes"))
+	if err != nil || size != 12 {
+		t.Fatalf("put: size=%d err=%v", size, err)
+	}
+	if gotSize, gotSum, err := Checksum(ctx, s, key, ""); err != nil || gotSize != size || gotSum != sum {
+		t.Fatalf("checksum: %d %s %v, want %d %s", gotSize, gotSum, err, size, sum)
+	}
+
+	r, info, err := s.Get(ctx, key)
+	if err != nil || info.Size != size {
+		t.Fatalf("get: %+v %v", info, err)
+	}
+	if _, err := r.Seek(7, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	rest, _ := io.ReadAll(r)
+	r.Close()
+	if string(rest) != "bytes" {
+		t.Fatalf("read after seek: %q", rest)
+	}
+
+	if _, _, err := s.Put(ctx, Key("task-1", "../escape"), strings.NewReader("x")); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("expected ErrInvalidName, got %v", err)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("stat after delete: expected ErrNotFound, got %v", err)
+	}
+	if err := s.Delete(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second delete: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFS(t *testing.T) {
+	testStore(t, NewFS(t.TempDir()))
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFSAcceptsLegacyPaths(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := NewFS(dir)
+	if _, _, err := s.Put(ctx, Key("task-1", "vmcore"), strings.NewReader("old")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// tasks finished before keys existed store the file path itself
+	info, err := s.Stat(ctx, filepath.Join(dir, "task-1", "vmcore"))
+	if err != nil || info.Size != 3 {
+		t.Fatalf("legacy stat: %+v %v", info, err)
+	}
+	if _, err := s.Stat(ctx, filepath.Join(dir, "..", "elsewhere")); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("path outside the store: expected ErrInvalidName, got %v", err)
+	}
+}
+
+func TestPutEncoded(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := NewFS(dir)
+	content := strings.Repeat("crash ", 500)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(content))
+	zw.Close()
+	compressed := buf.Bytes()
+
+	key := EncodedKey("task-1", "vmcore", Gzip)
+	up, err := PutEncoded(ctx, s, key, bytes.NewReader(compressed), Gzip)
+	if err != nil || up.Size != int64(len(content)) || up.StoredSize != int64(len(compressed)) {
+		t.Fatalf("put: %+v %v", up, err)
+	}
+	if size, sum, err := Checksum(ctx, s, key, Gzip); err != nil || size != up.Size || sum != up.SHA256 {
+		t.Fatalf("checksum: %d %s %v, want %+v", size, sum, err, up)
+	}
+
+	broken := EncodedKey("task-2", "vmcore", Gzip)
+	if _, err := PutEncoded(ctx, s, broken, bytes.NewReader(compressed[:len(compressed)-8]), Gzip); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("truncated stream: expected ErrCorrupt, got %v", err)
+	}
+	if _, err := s.Stat(ctx, broken); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("corrupt upload was kept: %v", err)
+	}
+
+	// deleting the last artifact of a task removes its directories too
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "task-1")); !os.IsNotExist(err) {
+		t.Fatalf("task directory left behind: %v", err)
+	}
+}
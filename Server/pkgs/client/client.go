@@ -0,0 +1,226 @@
+// Package client is a typed Go client for the task server api
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+// header names understood by the server, kept in sync with pkgs/api
+const (
+	APIKeyHeader    = "X-API-Key"
+	RequestIDHeader = "X-Request-ID"
+)
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+	apiKey  string
+	token   string
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, long-polling claims need a timeout above their wait
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.http = c
+	}
+}
+
+// WithAPIKey sends key on every request, it identifies the caller for rate limits and admin access
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithWorkerToken authenticates the worker calls with a token from POST /workers/register
+func WithWorkerToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client for the server at baseURL, e.g. http://dumpmind:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// TaskRequest is the body of a task submission, zero fields take the server defaults.
+// Priority and RequiredCapabilities have a meaningful zero, a nil one takes the default of
+// the type while an explicit 0 or empty list is kept.
+type TaskRequest struct {
+	ID                   string             `json:"id,omitempty"`
+	Type                 model.TaskType     `json:"type"`
+	Payload              *model.TaskPayload `json:"payload,omitempty"`
+	IdempotencyKey       string             `json:"idempotency_key,omitempty"`
+	Priority             *int               `json:"priority,omitempty"`
+	MaxAttempts          int                `json:"max_attempts,omitempty"`
+	TimeoutSeconds       int                `json:"timeout_seconds,omitempty"`
+	DependsOn            []string           `json:"depends_on,omitempty"`
+	RequiredCapabilities []string           `json:"required_capabilities"`
+	NotBefore            *time.Time         `json:"not_before,omitempty"`
+	ExpiresAt            *time.Time         `json:"expires_at,omitempty"`
+	PendingTTLSeconds    int                `json:"pending_ttl_seconds,omitempty"`
+	ExperimentID         string             `json:"experiment_id,omitempty"`
+	Env                  map[string]string  `json:"env,omitempty"`
+}
+
+// CreateTask submits a task. Resubmitting an idempotency key returns the existing task.
+func (c *Client) CreateTask(ctx context.Context, req TaskRequest) (*model.Task, error) {
+	var task model.Task
+	if err := c.do(ctx, http.MethodPost, "/tasks", nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (c *Client) GetTask(ctx context.Context, id string) (*model.Task, error) {
+	var task model.Task
+	if err := c.do(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id), nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListOptions filters a task listing, zero fields do not filter
+type ListOptions struct {
+	Status          model.TaskStatus
+	Type            model.TaskType
+	WorkerID        string
+	ExperimentID    string
+	IncludeArchived bool
+	Limit           int
+	Offset          int
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("status", string(o.Status))
+	set("type", string(o.Type))
+	set("worker_id", o.WorkerID)
+	set("experiment_id", o.ExperimentID)
+	if o.IncludeArchived {
+		q.Set("include_archived", "true")
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return q
+}
+
+func (c *Client) ListTasks(ctx context.Context, opts ListOptions) ([]model.Task, error) {
+	var tasks []model.Task
+	if err := c.do(ctx, http.MethodGet, "/tasks", opts.query(), nil, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// CancelTask cancels a pending task or asks the worker of a running one to stop, it needs an admin key
+func (c *Client) CancelTask(ctx context.Context, id string) (*model.Task, error) {
+	var task model.Task
+	if err := c.do(ctx, http.MethodPost, "/tasks/"+url.PathEscape(id)+"/cancel", nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ClaimRequest asks for work on behalf of a worker
+type ClaimRequest struct {
+	WorkerID string `json:"worker_id"`
+	// nil keeps whatever the worker announced before
+	Capabilities []string `json:"capabilities,omitempty"`
+	// how long the server may hold the request open waiting for work, nil uses its default
+	Wait *time.Duration `json:"-"`
+}
+
+// ClaimTask hands the worker its next task, it returns nil when there is no work
+func (c *Client) ClaimTask(ctx context.Context, req ClaimRequest) (*model.Task, error) {
+	body := struct {
+		ClaimRequest
+		WaitSeconds *int `json:"wait_seconds,omitempty"`
+	}{ClaimRequest: req}
+	if req.Wait != nil {
+		wait := int(req.Wait.Seconds())
+		body.WaitSeconds = &wait
+	}
+	var task *model.Task
+	if err := c.do(ctx, http.MethodPost, "/tasks/claim", nil, body, &task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// do sends a json request and decodes a json response into out, a 204 leaves out untouched
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set(APIKeyHeader, c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return newAPIError(resp)
+	}
+	if resp.StatusCode == http.StatusNoContent || out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
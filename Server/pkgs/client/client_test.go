@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Server/pkgs/api"
+	"Server/pkgs/artifact"
+	"Server/pkgs/config"
+	"Server/pkgs/database"
+	"Server/pkgs/model"
+	"Server/pkgs/ratelimit"
+	"Server/pkgs/scheduler"
+)
+
+func newTestServer(t *testing.T, opts ...api.Option) *httptest.Server {
+	t.Helper()
+	return newTestServerWithTypes(t, nil, opts...)
+}
+
+// newTestServerWithTypes is newTestServer with task type defaults for the scheduler
+func newTestServerWithTypes(t *testing.T, types []scheduler.TaskTypeConfig, opts ...api.Option) *httptest.Server {
+	t.Helper()
+	db, err := database.Open(config.DatabaseConfig{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000",
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	artifacts := artifact.NewMemory()
+	sched := scheduler.New(db, scheduler.RetryPolicy{MaxAttempts: 1}, scheduler.WithArtifactStore(artifacts), scheduler.WithTaskTypes(types))
+	srv := httptest.NewServer(api.New(sched, artifacts, opts...))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t, api.WithAdminKeys([]string{"admin-key"}))
+	c := New(srv.URL+"/", WithHTTPClient(srv.Client()), WithAPIKey("admin-key"))
+
+	priority := 5
+	created, err := c.CreateTask(ctx, TaskRequest{
+		Type:     model.TaskTypePatchApply,
+		Priority: &priority,
+		Payload: &model.TaskPayload{PatchApply: &model.PatchApplyPayload{
+			Patches:      []model.PatchRef{{URL: "https://lore.kernel.org/fix.patch"}},
+			TargetKernel: "6.1.0",
+		}},
+		Env: map[string]string{"KDUMP_LEVEL": "31"},
+	})
+	if err != nil || created.ID == "" || created.Status != model.StatusPending {
+		t.Fatalf("create: %+v %v", created, err)
+	}
+
+	got, err := c.GetTask(ctx, created.ID)
+	if err != nil || got.ID != created.ID || got.Priority != 5 || got.Payload.PatchApply.TargetKernel != "6.1.0" {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+	list, err := c.ListTasks(ctx, ListOptions{Status: model.StatusPending, Type: model.TaskTypePatchApply})
+	if err != nil || len(list) != 1 || list[0].ID != created.ID {
+		t.Fatalf("list: %+v %v", list, err)
+	}
+
+	noWait := time.Duration(0)
+	claimed, err := c.ClaimTask(ctx, ClaimRequest{WorkerID: "worker-1", Wait: &noWait})
+	if err != nil || claimed == nil || claimed.ID != created.ID || claimed.Env["KDUMP_LEVEL"] != "31" {
+		t.Fatalf("claim: %+v %v", claimed, err)
+	}
+	if claimed, err := c.ClaimTask(ctx, ClaimRequest{WorkerID: "worker-1", Wait: &noWait}); err != nil || claimed != nil {
+		t.Fatalf("claim on an empty queue: %+v %v", claimed, err)
+	}
+
+	cancelled, err := c.CancelTask(ctx, created.ID)
+	if err != nil || !cancelled.CancelRequested {
+		t.Fatalf("cancel: %+v %v", cancelled, err)
+	}
+}
+
+func TestExplicitZeroesOverrideTypeDefaults(t *testing.T) {
+	ctx := context.Background()
+	priority := 7
+	types, err := scheduler.NewTaskTypes(map[string]config.TaskTypeConfig{
+		string(model.TaskTypeGetVmcore): {Priority: &priority, RequiredCapabilities: []string{"kdump"}},
+	})
+	if err != nil {
+		t.Fatalf("task types: %v", err)
+	}
+	srv := newTestServerWithTypes(t, types)
+	c := New(srv.URL, WithHTTPClient(srv.Client()))
+
+	payload := &model.TaskPayload{GetVmcore: &model.GetVmcorePayload{
+		TargetHost: "crash-host-01",
+		Endpoint:   "ssh://root@crash-host-01:22",
+		CrashTime:  time.Date(2025, 7, 1, 3, 4, 5, 0, time.UTC),
+		DumpPath:   "/var/crash/vmcore",
+	}}
+	defaulted, err := c.CreateTask(ctx, TaskRequest{Type: model.TaskTypeGetVmcore, Payload: payload})
+	if err != nil || defaulted.Priority != 7 || len(defaulted.RequiredCapabilities) != 1 || defaulted.RequiredCapabilities[0] != "kdump" {
+		t.Fatalf("omitted fields should take the type defaults: %+v %v", defaulted, err)
+	}
+
+	zero := 0
+	explicit, err := c.CreateTask(ctx, TaskRequest{Type: model.TaskTypeGetVmcore, Payload: payload, Priority: &zero, RequiredCapabilities: []string{}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	got, err := c.GetTask(ctx, explicit.ID)
+	if err != nil || got.Priority != 0 || len(got.RequiredCapabilities) != 0 {
+		t.Fatalf("explicit zeroes should be kept: %+v %v", got, err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	ctx := context.Background()
+	limiter := ratelimit.New(config.RateLimitConfig{RateLimitRule: config.RateLimitRule{RequestsPerMinute: 1, Burst: 1}})
+	srv := newTestServer(t, api.WithRateLimiter(limiter), api.WithAdminKeys([]string{"tools"}))
+	c := New(srv.URL, WithHTTPClient(srv.Client()), WithAPIKey("tools"))
+
+	_, err := c.GetTask(ctx, "00000000-0000-0000-0000-000000000000")
+	var apiErr *APIError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.RequestID == "" {
+		t.Fatalf("missing task: expected ErrNotFound with a request id, got %v", err)
+	}
+
+	vmcore := TaskRequest{Type: model.TaskTypeGetVmcore, Payload: &model.TaskPayload{GetVmcore: &model.GetVmcorePayload{
+		TargetHost: "crash-host-01",
+		Endpoint:   "ssh://root@crash-host-01:22",
+		CrashTime:  time.Date(2025, 7, 1, 3, 4, 5, 0, time.UTC),
+		DumpPath:   "/var/crash/vmcore",
+	}}}
+	created, err := c.CreateTask(ctx, vmcore)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := c.CreateTask(ctx, vmcore); !errors.Is(err, ErrRateLimited) ||
+		!errors.As(err, &apiErr) || apiErr.RetryAfter <= 0 {
+		t.Fatalf("second create: expected ErrRateLimited with a retry-after, got %v", err)
+	}
+
+	if _, err := c.CancelTask(ctx, created.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, err := c.CancelTask(ctx, created.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("cancel of a cancelled task: expected ErrConflict, got %v", err)
+	}
+
+	bad := TaskRequest{Type: model.TaskTypePatchApply, Payload: &model.TaskPayload{PatchApply: &model.PatchApplyPayload{TargetKernel: "6.1.0"}}}
+	// a key of its own, the first one is out of tokens
+	other := New(srv.URL, WithHTTPClient(srv.Client()), WithAPIKey("other-tools"))
+	if _, err := other.CreateTask(ctx, bad); !errors.Is(err, ErrInvalidPayload) || !errors.As(err, &apiErr) ||
+		len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "payload.patch_apply.patches" {
+		t.Fatalf("patch task without patches: expected ErrInvalidPayload naming the field, got %v", err)
+	}
+
+	cancelled, stop := context.WithCancel(ctx)
+	stop()
+	if _, err := c.ListTasks(cancelled, ListOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled context: expected context.Canceled, got %v", err)
+	}
+}
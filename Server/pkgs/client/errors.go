@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrRateLimited = errors.New("rate limited")
+	// ErrInvalidPayload means the payload validators rejected the task, see APIError.Fields
+	ErrInvalidPayload = errors.New("invalid payload")
+)
+
+// APIError is a non-2xx answer from the server, errors.Is matches it against
+// ErrNotFound, ErrConflict, ErrRateLimited and ErrInvalidPayload by status code
+type APIError struct {
+	StatusCode int
+	Message    string
+	RequestID  string
+	// how long the server asked to back off, only set for 429
+	RetryAfter time.Duration
+	// the rejected payload fields of a 422
+	Fields []model.FieldError
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnprocessableEntity:
+		return ErrInvalidPayload
+	}
+	return nil
+}
+
+// errors are small, anything past this is not an error body
+const maxErrorBytes = 64 << 10
+
+func newAPIError(resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get(RequestIDHeader),
+	}
+	var body struct {
+		Error  string             `json:"error"`
+		Fields []model.FieldError `json:"fields"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+	if json.Unmarshal(raw, &body) == nil && body.Error != "" {
+		e.Message = body.Error
+		e.Fields = body.Fields
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const DefaultPath = "config/server.toml"
+
+type Config struct {
+	Server      ServerConfig      `toml:"server"`
+	Database    DatabaseConfig    `toml:"database"`
+	Retry       RetryConfig       `toml:"retry"`
+	Reaper      ReaperConfig      `toml:"reaper"`
+	Artifact    ArtifactConfig    `toml:"artifact"`
+	Webhook     WebhookConfig     `toml:"webhook"`
+	Archive     ArchiveConfig     `toml:"archive"`
+	RateLimit   RateLimitConfig   `toml:"rate_limit"`
+	Concurrency ConcurrencyConfig `toml:"concurrency"`
+	Payload     PayloadConfig     `toml:"payload"`
+	TaskLogs    TaskLogConfig     `toml:"task_logs"`
+	Workers     WorkerConfig      `toml:"workers"`
+	// defaults per task type, keyed by task type
+	TaskTypes map[string]TaskTypeConfig `toml:"task_types"`
+	Log       LogConfig                 `toml:"log"`
+}
+
+// http server config
+type ServerConfig struct {
+	Addr         string `toml:"addr"`
+	MaxBatchSize int    `toml:"max_batch_size"`
+	// api keys allowed to call the admin endpoints, none disables them
+	AdminKeys []string `toml:"admin_keys"`
+	// worker endpoints need a token from POST /workers/register
+	RequireWorkerTokens bool `toml:"require_worker_tokens"`
+	// seconds in flight requests get to finish on SIGINT/SIGTERM
+	ShutdownTimeout int `toml:"shutdown_timeout"`
+}
+
+func (c ServerConfig) ShutdownTimeoutDuration() time.Duration {
+	return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+// database config
+type DatabaseConfig struct {
+	Driver string `toml:"driver"` // postgres | sqlite
+	DSN    string `toml:"dsn"`
+	// SearchIndex builds a trigram index for task search, postgres only and needs pg_trgm
+	SearchIndex bool `toml:"search_index"`
+}
+
+// retry config, delays are in seconds
+type RetryConfig struct {
+	MaxAttempts   int     `toml:"max_attempts"`
+	BaseDelay     int     `toml:"base_delay"`
+	BackoffFactor float64 `toml:"backoff_factor"`
+	MaxDelay      int     `toml:"max_delay"`
+}
+
+func (c RetryConfig) BaseDelayDuration() time.Duration {
+	return time.Duration(c.BaseDelay) * time.Second
+}
+
+func (c RetryConfig) MaxDelayDuration() time.Duration {
+	return time.Duration(c.MaxDelay) * time.Second
+}
+
+// reaper config, durations are in seconds
+type ReaperConfig struct {
+	Interval int `toml:"interval"`
+	// Lease is how long a claim holds a task before the worker has to renew it
+	Lease int `toml:"lease"`
+}
+
+func (c ReaperConfig) IntervalDuration() time.Duration {
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c ReaperConfig) LeaseDuration() time.Duration {
+	return time.Duration(c.Lease) * time.Second
+}
+
+// artifact storage config, backend is fs or s3
+type ArtifactConfig struct {
+	Backend string           `toml:"backend"`
+	Dir     string           `toml:"dir"`
+	S3      S3Config         `toml:"s3"`
+	GC      ArtifactGCConfig `toml:"gc"`
+}
+
+// artifact garbage collection config, the files of tasks finished more than retention_days
+// ago are deleted every interval seconds, zero retention keeps them forever
+type ArtifactGCConfig struct {
+	Interval      int `toml:"interval"`
+	RetentionDays int `toml:"retention_days"`
+	// only log what would be deleted
+	DryRun bool `toml:"dry_run"`
+}
+
+func (c ArtifactGCConfig) IntervalDuration() time.Duration {
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c ArtifactGCConfig) RetentionDuration() time.Duration {
+	return time.Duration(c.RetentionDays) * 24 * time.Hour
+}
+
+// s3 compatible object storage config
+type S3Config struct {
+	Endpoint  string `toml:"endpoint"`
+	Bucket    string `toml:"bucket"`
+	Region    string `toml:"region"`
+	Prefix    string `toml:"prefix"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	UseSSL    bool   `toml:"use_ssl"`
+}
+
+// task output config, workers stream it while a task runs
+type TaskLogConfig struct {
+	// output kept per task, the oldest chunks are dropped past it
+	MaxBytes int64 `toml:"max_bytes"`
+}
+
+// defaults of one task type for tasks that leave the fields unset, keys left out keep
+// the built-in default
+type TaskTypeConfig struct {
+	TimeoutSeconds       int      `toml:"timeout_seconds"`
+	MaxAttempts          int      `toml:"max_attempts"`
+	Priority             *int     `toml:"priority"`
+	RequiredCapabilities []string `toml:"required_capabilities"`
+	// a timed out attempt is retried like a failure report instead of failing the task
+	RetryOnTimeout *bool `toml:"retry_on_timeout"`
+}
+
+// worker config, durations are in seconds
+type WorkerConfig struct {
+	// rolling window of GET /workers/{id}/stats
+	StatsWindow int `toml:"stats_window"`
+}
+
+func (c WorkerConfig) StatsWindowDuration() time.Duration {
+	return time.Duration(c.StatsWindow) * time.Second
+}
+
+// log config, level is debug | info | warn | error
+type LogConfig struct {
+	Level string `toml:"level"`
+}
+
+// webhook config, durations are in seconds
+type WebhookConfig struct {
+	URLs        []string `toml:"urls"`
+	Secret      string   `toml:"secret"`
+	Timeout     int      `toml:"timeout"`
+	MaxAttempts int      `toml:"max_attempts"`
+	BaseDelay   int      `toml:"base_delay"`
+	MaxDelay    int      `toml:"max_delay"`
+	QueueSize   int      `toml:"queue_size"`
+}
+
+func (c WebhookConfig) TimeoutDuration() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c WebhookConfig) BaseDelayDuration() time.Duration {
+	return time.Duration(c.BaseDelay) * time.Second
+}
+
+func (c WebhookConfig) MaxDelayDuration() time.Duration {
+	return time.Duration(c.MaxDelay) * time.Second
+}
+
+// archive config, tasks finished more than retention_days ago are archived every interval seconds
+type ArchiveConfig struct {
+	Interval      int `toml:"interval"`
+	RetentionDays int `toml:"retention_days"`
+}
+
+func (c ArchiveConfig) IntervalDuration() time.Duration {
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c ArchiveConfig) RetentionDuration() time.Duration {
+	return time.Duration(c.RetentionDays) * 24 * time.Hour
+}
+
+// concurrency config, limits caps the running tasks per task type, missing types are unlimited
+type ConcurrencyConfig struct {
+	Limits map[string]int `toml:"limits"`
+}
+
+// task payload limits, sizes are bytes of the serialized payload
+type PayloadConfig struct {
+	MaxBytes int `toml:"max_bytes"`
+	// overrides of max_bytes keyed by task type
+	MaxBytesPerType map[string]int `toml:"max_bytes_per_type"`
+}
+
+// task creation rate limit per api key, keys without an override share the default rule
+// and anonymous callers share a single bucket
+type RateLimitConfig struct {
+	RateLimitRule
+	Keys map[string]RateLimitRule `toml:"keys"`
+}
+
+// token bucket refilled at requests_per_minute holding up to burst tokens, 0 requests_per_minute disables it
+type RateLimitRule struct {
+	RequestsPerMinute int `toml:"requests_per_minute"`
+	Burst             int `toml:"burst"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			MaxBatchSize:    500,
+			ShutdownTimeout: 30,
+		},
+		Database: DatabaseConfig{
+			Driver: "postgres",
+			DSN:    "host=127.0.0.1 port=5432 user=postgres password=postgres dbname=dumpmind sslmode=disable",
+		},
+		Retry: RetryConfig{
+			MaxAttempts:   3,
+			BaseDelay:     5,
+			BackoffFactor: 2,
+			MaxDelay:      300,
+		},
+		Reaper: ReaperConfig{
+			Interval: 15,
+			Lease:    60,
+		},
+		Artifact: ArtifactConfig{
+			Backend: "fs",
+			Dir:     "artifacts",
+			GC:      ArtifactGCConfig{Interval: 3600},
+		},
+		Webhook: WebhookConfig{
+			Timeout:     10,
+			MaxAttempts: 5,
+			BaseDelay:   1,
+			MaxDelay:    60,
+			QueueSize:   1024,
+		},
+		Archive: ArchiveConfig{
+			Interval:      3600,
+			RetentionDays: 30,
+		},
+		RateLimit: RateLimitConfig{
+			RateLimitRule: RateLimitRule{RequestsPerMinute: 600, Burst: 60},
+		},
+		Payload:  PayloadConfig{MaxBytes: 64 << 10},
+		TaskLogs: TaskLogConfig{MaxBytes: 1 << 20},
+		Workers:  WorkerConfig{StatsWindow: 24 * 60 * 60},
+		Log:      LogConfig{Level: "info"},
+	}
+}
+
+// Load reads the toml file at path on top of the defaults
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	if err := toml.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migration is one numbered schema change. Migrations declare their own snapshot of the
+// tables they touch instead of using the model types, so they keep producing the same
+// schema when the models move on. Applied migrations must never be edited, add a new one.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// schemaMigration records an applied migration in schema_migrations
+type schemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"type:varchar(128)"`
+	AppliedAt time.Time
+}
+
+// migrationLock serialises servers starting at the same time on postgres
+const migrationLock = 0x64756d70
+
+// Migrate applies every pending migration, each in its own transaction together with
+// its schema_migrations row
+func Migrate(db *gorm.DB) error {
+	return migrate(db, migrations)
+}
+
+func migrate(db *gorm.DB, migrations []migration) error {
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		if err := db.Migrator().CreateTable(&schemaMigration{}); err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+	}
+	for _, m := range migrations {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "postgres" {
+				if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLock).Error; err != nil {
+					return err
+				}
+			}
+			var applied int64
+			if err := tx.Model(&schemaMigration{}).Where("version = ?", m.version).Count(&applied).Error; err != nil {
+				return err
+			}
+			if applied > 0 {
+				return nil
+			}
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			slog.Info("applied database migration", "version", m.version, "name", m.name)
+			return tx.Create(&schemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion is the highest applied migration, 0 for an empty database
+func SchemaVersion(db *gorm.DB) (int, error) {
+	var version int
+	err := db.Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// databases created by AutoMigrate already have some of what a migration adds, so the
+// helpers below skip what exists and a first run against such a database converges
+
+func createTable(tx *gorm.DB, table any) error {
+	if tx.Migrator().HasTable(table) {
+		return nil
+	}
+	return tx.Migrator().CreateTable(table)
+}
+
+// addColumn reports whether the column was missing, backfills only apply to new columns
+func addColumn(tx *gorm.DB, table any, field string) (bool, error) {
+	if tx.Migrator().HasColumn(table, field) {
+		return false, nil
+	}
+	return true, tx.Migrator().AddColumn(table, field)
+}
+
+func addColumns(tx *gorm.DB, table any, fields ...string) error {
+	for _, field := range fields {
+		if _, err := addColumn(tx, table, field); err != nil {
+			return fmt.Errorf("add column %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// createIndexes takes index names or the fields of single column indexes
+func createIndexes(tx *gorm.DB, table any, names ...string) error {
+	for _, name := range names {
+		if tx.Migrator().HasIndex(table, name) {
+			continue
+		}
+		if err := tx.Migrator().CreateIndex(table, name); err != nil {
+			return fmt.Errorf("create index %s: %w", name, err)
+		}
+	}
+	return nil
+}
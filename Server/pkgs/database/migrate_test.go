@@ -0,0 +1,145 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+var models = []any{&model.Task{}, &model.Worker{}, &model.AuditEvent{}, &model.Experiment{}, &model.TypeLock{}, &model.Artifact{}, &model.WorkerToken{}, &model.TaskLog{}, &model.WorkerRun{}}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := Open(config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// schemaOf describes the columns and indexes of every model table in a comparable form
+func schemaOf(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	schema := make(map[string][]string)
+	for _, m := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			t.Fatalf("parse %T: %v", m, err)
+		}
+		table := stmt.Schema.Table
+		columns, err := db.Migrator().ColumnTypes(m)
+		if err != nil {
+			t.Fatalf("columns of %s: %v", table, err)
+		}
+		for _, c := range columns {
+			nullable, _ := c.Nullable()
+			def, _ := c.DefaultValue()
+			schema[table] = append(schema[table], fmt.Sprintf("column %s %s null=%v default=%q",
+				c.Name(), strings.ToLower(c.DatabaseTypeName()), nullable, def))
+		}
+		indexes, err := db.Migrator().GetIndexes(m)
+		if err != nil {
+			t.Fatalf("indexes of %s: %v", table, err)
+		}
+		for _, idx := range indexes {
+			unique, _ := idx.Unique()
+			schema[table] = append(schema[table], fmt.Sprintf("index %s %v unique=%v", idx.Name(), idx.Columns(), unique))
+		}
+		slices.Sort(schema[table])
+	}
+	return schema
+}
+
+func TestMigrationsMatchModels(t *testing.T) {
+	migrated := openTestDB(t)
+	if err := Migrate(migrated); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// a second run finds nothing to do
+	if err := Migrate(migrated); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+	if version, err := SchemaVersion(migrated); err != nil || version != migrations[len(migrations)-1].version {
+		t.Fatalf("schema version: %d %v", version, err)
+	}
+
+	// the models are the source of truth, a model change without a migration fails here
+	auto := openTestDB(t)
+	if err := auto.AutoMigrate(models...); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	got, want := schemaOf(t, migrated), schemaOf(t, auto)
+	for table := range want {
+		if !slices.Equal(got[table], want[table]) {
+			t.Errorf("table %s\nmigrated:\n  %s\nmodels:\n  %s", table,
+				strings.Join(got[table], "\n  "), strings.Join(want[table], "\n  "))
+		}
+	}
+}
+
+func TestMigrationVersionsAreIncreasing(t *testing.T) {
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version <= migrations[i-1].version {
+			t.Fatalf("migration %q has version %d after %d", migrations[i].name, migrations[i].version, migrations[i-1].version)
+		}
+	}
+}
+
+func TestMigrateBackfillsNewColumns(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db, migrations[:1]); err != nil {
+		t.Fatalf("initial migration: %v", err)
+	}
+	err := db.Exec(`INSERT INTO tasks (id, type, status, artifact_path, artifact_name, created_at, started_at, finished_at) VALUES
+		('00000000-0000-0000-0000-000000000001', 'get-vmcore', 'success', '/srv/artifacts/vmcore', 'vmcore', '2025-01-01', '2025-01-01', '2025-01-01'),
+		('00000000-0000-0000-0000-000000000002', 'get-vmcore', 'pending', '', '', '2025-01-01', NULL, NULL)`).Error
+	if err != nil {
+		t.Fatalf("insert old rows: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var tasks []model.Task
+	if err := db.Order("id").Find(&tasks).Error; err != nil {
+		t.Fatalf("load migrated rows: %v", err)
+	}
+	done, pending := tasks[0], tasks[1]
+	if done.Attempts != 1 || done.MaxAttempts != 1 || done.Progress != 100 || done.AttemptHistory == nil {
+		t.Fatalf("finished task: attempts=%d max=%d progress=%d history=%v", done.Attempts, done.MaxAttempts, done.Progress, done.AttemptHistory)
+	}
+	if pending.Attempts != 0 || pending.Version != 0 || pending.Blocked || pending.Priority != 0 {
+		t.Fatalf("pending task: %+v", pending)
+	}
+
+	var artifacts []model.Artifact
+	if err := db.Find(&artifacts).Error; err != nil {
+		t.Fatalf("load artifacts: %v", err)
+	}
+	if len(artifacts) != 1 || artifacts[0].TaskID != done.ID || !artifacts[0].Primary || artifacts[0].Path != "/srv/artifacts/vmcore" || artifacts[0].Attempt != 1 {
+		t.Fatalf("artifacts moved from the task rows: %+v", artifacts)
+	}
+}
+
+func TestMigrateAdoptsAutoMigratedDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var applied int64
+	if err := db.Model(&schemaMigration{}).Count(&applied).Error; err != nil || applied != int64(len(migrations)) {
+		t.Fatalf("applied migrations: %d %v", applied, err)
+	}
+}
@@ -0,0 +1,327 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations in the order they apply, the snapshot types are named after their table
+var migrations = []migration{
+	{1, "create tasks", func(tx *gorm.DB) error {
+		type task struct {
+			ID           string `gorm:"type:char(36);primaryKey"`
+			Type         string `gorm:"type:varchar(32)"`
+			Status       string `gorm:"type:varchar(32)"`
+			WorkerID     string `gorm:"type:varchar(64);index"`
+			Result       string `gorm:"type:text"`
+			ArtifactPath string `gorm:"type:text"`
+			ArtifactName string `gorm:"type:text"`
+			CreatedAt    time.Time
+			StartedAt    *time.Time
+			FinishedAt   *time.Time
+		}
+		return createTable(tx, &task{})
+	}},
+	{2, "task retries", func(tx *gorm.DB) error {
+		type task struct {
+			Attempts       int    `gorm:"not null;default:0"`
+			MaxAttempts    int    `gorm:"not null;default:1"`
+			AttemptHistory string `gorm:"type:text"`
+			NextRetryAt    *time.Time
+		}
+		added, err := addColumn(tx, &task{}, "Attempts")
+		if err != nil {
+			return err
+		}
+		if added {
+			// every task that got started before retries existed ran exactly once
+			if err := tx.Exec("UPDATE tasks SET attempts = 1 WHERE started_at IS NOT NULL").Error; err != nil {
+				return err
+			}
+		}
+		if added, err = addColumn(tx, &task{}, "AttemptHistory"); err != nil {
+			return err
+		}
+		if added {
+			if err := tx.Exec("UPDATE tasks SET attempt_history = '[]'").Error; err != nil {
+				return err
+			}
+		}
+		return addColumns(tx, &task{}, "MaxAttempts", "NextRetryAt")
+	}},
+	{3, "task priority", func(tx *gorm.DB) error {
+		type task struct {
+			Priority int `gorm:"not null;default:0;index"`
+		}
+		if err := addColumns(tx, &task{}, "Priority"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "Priority")
+	}},
+	{4, "workers", func(tx *gorm.DB) error {
+		type worker struct {
+			ID         string    `gorm:"type:varchar(64);primaryKey"`
+			LastSeenAt time.Time `gorm:"index"`
+			CreatedAt  time.Time
+		}
+		return createTable(tx, &worker{})
+	}},
+	{5, "task timeouts and cancellation", func(tx *gorm.DB) error {
+		type task struct {
+			TimeoutSeconds  int  `gorm:"not null;default:0"`
+			CancelRequested bool `gorm:"not null;default:false"`
+		}
+		return addColumns(tx, &task{}, "TimeoutSeconds", "CancelRequested")
+	}},
+	{6, "task dependencies", func(tx *gorm.DB) error {
+		type task struct {
+			DependsOn string `gorm:"type:text"`
+			Blocked   bool   `gorm:"not null;default:false;index"`
+		}
+		if err := addColumns(tx, &task{}, "DependsOn", "Blocked"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "Blocked")
+	}},
+	{7, "artifact checksums", func(tx *gorm.DB) error {
+		type task struct {
+			ArtifactSize   int64  `gorm:"not null;default:0"`
+			ArtifactSHA256 string `gorm:"type:char(64)"`
+		}
+		return addColumns(tx, &task{}, "ArtifactSize", "ArtifactSHA256")
+	}},
+	{8, "task payloads and results", func(tx *gorm.DB) error {
+		type task struct {
+			Payload    string `gorm:"type:text"`
+			ResultData string `gorm:"type:text"`
+		}
+		return addColumns(tx, &task{}, "Payload", "ResultData")
+	}},
+	{9, "capabilities", func(tx *gorm.DB) error {
+		type task struct {
+			RequiredCapabilities string `gorm:"type:text"`
+		}
+		type worker struct {
+			Capabilities string `gorm:"type:text"`
+		}
+		if err := addColumns(tx, &task{}, "RequiredCapabilities"); err != nil {
+			return err
+		}
+		return addColumns(tx, &worker{}, "Capabilities")
+	}},
+	{10, "task idempotency keys", func(tx *gorm.DB) error {
+		type task struct {
+			Type           string  `gorm:"type:varchar(32);uniqueIndex:idx_tasks_idempotency"`
+			IdempotencyKey *string `gorm:"type:varchar(128);uniqueIndex:idx_tasks_idempotency"`
+		}
+		if err := addColumns(tx, &task{}, "IdempotencyKey"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "idx_tasks_idempotency")
+	}},
+	{11, "task archive", func(tx *gorm.DB) error {
+		type task struct {
+			DeletedAt gorm.DeletedAt `gorm:"index"`
+		}
+		if err := addColumns(tx, &task{}, "DeletedAt"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "DeletedAt")
+	}},
+	{12, "task progress", func(tx *gorm.DB) error {
+		type task struct {
+			Progress int `gorm:"not null;default:0"`
+		}
+		added, err := addColumn(tx, &task{}, "Progress")
+		if err == nil && added {
+			err = tx.Exec("UPDATE tasks SET progress = 100 WHERE status = 'success'").Error
+		}
+		return err
+	}},
+	{13, "audit events", func(tx *gorm.DB) error {
+		type auditEvent struct {
+			ID        uint      `gorm:"primaryKey;autoIncrement"`
+			TaskID    string    `gorm:"type:varchar(64);index"`
+			Actor     string    `gorm:"type:varchar(128)"`
+			Action    string    `gorm:"type:varchar(32)"`
+			Details   string    `gorm:"type:text"`
+			Timestamp time.Time `gorm:"index"`
+		}
+		return createTable(tx, &auditEvent{})
+	}},
+	{14, "worker drain", func(tx *gorm.DB) error {
+		type worker struct {
+			Draining bool `gorm:"not null;default:false"`
+		}
+		return addColumns(tx, &worker{}, "Draining")
+	}},
+	{15, "task not_before", func(tx *gorm.DB) error {
+		type task struct {
+			NotBefore *time.Time `gorm:"index"`
+		}
+		if err := addColumns(tx, &task{}, "NotBefore"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "NotBefore")
+	}},
+	{16, "experiments", func(tx *gorm.DB) error {
+		type experiment struct {
+			ID          string `gorm:"type:char(36);primaryKey"`
+			Name        string `gorm:"type:varchar(128)"`
+			Description string `gorm:"type:text"`
+			CreatedAt   time.Time
+		}
+		type task struct {
+			ExperimentID string `gorm:"type:varchar(36);index"`
+		}
+		if err := createTable(tx, &experiment{}); err != nil {
+			return err
+		}
+		if err := addColumns(tx, &task{}, "ExperimentID"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "ExperimentID")
+	}},
+	{17, "task version", func(tx *gorm.DB) error {
+		type task struct {
+			Version int `gorm:"not null;default:0"`
+		}
+		return addColumns(tx, &task{}, "Version")
+	}},
+	{18, "task env", func(tx *gorm.DB) error {
+		type task struct {
+			Env string `gorm:"type:text"`
+		}
+		return addColumns(tx, &task{}, "Env")
+	}},
+	{19, "task reruns", func(tx *gorm.DB) error {
+		type task struct {
+			RerunOf string `gorm:"type:varchar(36);index"`
+		}
+		if err := addColumns(tx, &task{}, "RerunOf"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "RerunOf")
+	}},
+	{20, "type locks", func(tx *gorm.DB) error {
+		type typeLock struct {
+			Type     string `gorm:"type:varchar(32);primaryKey"`
+			LockedAt time.Time
+		}
+		return createTable(tx, &typeLock{})
+	}},
+	{21, "task leases", func(tx *gorm.DB) error {
+		// running tasks keep a NULL lease, the reaper treats them as leased from started_at
+		type task struct {
+			LeaseExpiresAt *time.Time `gorm:"index"`
+		}
+		if err := addColumns(tx, &task{}, "LeaseExpiresAt"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "LeaseExpiresAt")
+	}},
+	{22, "task excluded workers", func(tx *gorm.DB) error {
+		type task struct {
+			ExcludedWorkers string `gorm:"type:text"`
+		}
+		return addColumns(tx, &task{}, "ExcludedWorkers")
+	}},
+	{23, "artifact compression", func(tx *gorm.DB) error {
+		type task struct {
+			ArtifactStoredSize  int64  `gorm:"not null;default:0"`
+			ArtifactCompression string `gorm:"type:varchar(16)"`
+		}
+		added, err := addColumn(tx, &task{}, "ArtifactStoredSize")
+		if err != nil {
+			return err
+		}
+		if added {
+			// everything stored so far is uncompressed
+			if err := tx.Exec("UPDATE tasks SET artifact_stored_size = artifact_size").Error; err != nil {
+				return err
+			}
+		}
+		return addColumns(tx, &task{}, "ArtifactCompression")
+	}},
+	{24, "task expiry", func(tx *gorm.DB) error {
+		type task struct {
+			PendingTTLSeconds int        `gorm:"not null;default:0"`
+			ExpiresAt         *time.Time `gorm:"index"`
+		}
+		if err := addColumns(tx, &task{}, "PendingTTLSeconds", "ExpiresAt"); err != nil {
+			return err
+		}
+		return createIndexes(tx, &task{}, "ExpiresAt")
+	}},
+	{25, "task artifacts", func(tx *gorm.DB) error {
+		type artifact struct {
+			ID          uint   `gorm:"primaryKey;autoIncrement"`
+			TaskID      string `gorm:"type:char(36);uniqueIndex:idx_artifacts_task_name"`
+			Name        string `gorm:"type:varchar(255);uniqueIndex:idx_artifacts_task_name"`
+			Path        string `gorm:"type:text"`
+			Size        int64  `gorm:"not null;default:0"`
+			StoredSize  int64  `gorm:"not null;default:0"`
+			Compression string `gorm:"type:varchar(16)"`
+			SHA256      string `gorm:"type:char(64)"`
+			ContentType string `gorm:"type:varchar(128)"`
+			Primary     bool   `gorm:"column:is_primary;not null;default:false"`
+			Attempt     int    `gorm:"not null;default:0"`
+			CreatedAt   time.Time
+		}
+		if tx.Migrator().HasTable(&artifact{}) {
+			return nil
+		}
+		if err := tx.Migrator().CreateTable(&artifact{}); err != nil {
+			return err
+		}
+		// the single artifact of every finished task becomes its primary artifact
+		return tx.Exec(`INSERT INTO artifacts (task_id, name, path, size, stored_size, compression, sha256, content_type, is_primary, attempt, created_at)
+			SELECT id, artifact_name, artifact_path, artifact_size, artifact_stored_size, COALESCE(artifact_compression, ''),
+				COALESCE(artifact_sha256, ''), 'application/octet-stream', true, attempts, COALESCE(finished_at, created_at)
+			FROM tasks WHERE artifact_path <> ''`).Error
+	}},
+	{26, "worker tokens", func(tx *gorm.DB) error {
+		type workerToken struct {
+			ID         uint   `gorm:"primaryKey"`
+			WorkerID   string `gorm:"type:varchar(64);index"`
+			TokenHash  string `gorm:"type:char(64);uniqueIndex"`
+			CreatedAt  time.Time
+			LastUsedAt *time.Time
+		}
+		return createTable(tx, &workerToken{})
+	}},
+	{27, "task logs", func(tx *gorm.DB) error {
+		type taskLog struct {
+			ID         uint   `gorm:"primaryKey;autoIncrement"`
+			TaskID     string `gorm:"type:char(36);uniqueIndex:idx_task_logs_seq"`
+			Seq        int64  `gorm:"not null;uniqueIndex:idx_task_logs_seq"`
+			ByteOffset int64  `gorm:"not null;default:0"`
+			Size       int64  `gorm:"not null;default:0"`
+			Attempt    int    `gorm:"not null;default:0"`
+			Data       string `gorm:"type:text"`
+			CreatedAt  time.Time
+		}
+		return createTable(tx, &taskLog{})
+	}},
+	{28, "worker runs", func(tx *gorm.DB) error {
+		type workerRun struct {
+			ID         uint   `gorm:"primaryKey;autoIncrement"`
+			TaskID     string `gorm:"type:char(36);index"`
+			Attempt    int    `gorm:"not null;default:0"`
+			WorkerID   string `gorm:"type:varchar(64);index:idx_worker_runs_finished"`
+			Outcome    string `gorm:"type:varchar(16)"`
+			StartedAt  time.Time
+			FinishedAt time.Time `gorm:"index:idx_worker_runs_finished"`
+			BusyMillis int64     `gorm:"not null;default:0"`
+		}
+		return createTable(tx, &workerRun{})
+	}},
+	{29, "artifact gc", func(tx *gorm.DB) error {
+		type task struct {
+			ArtifactGCedAt *time.Time `gorm:"column:artifact_gced_at"`
+		}
+		_, err := addColumn(tx, &task{}, "ArtifactGCedAt")
+		return err
+	}},
+}
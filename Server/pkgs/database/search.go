@@ -0,0 +1,35 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// TaskSearchText is the lowercased text task search matches against: the result
+// summary, the artifact name and the crash signature of a vmcore result. The
+// postgres form is the one the trigram index is built on, so the two must match.
+func TaskSearchText(db *gorm.DB) string {
+	signature := "json_extract(result_data, '$.vmcore.crash_signature')"
+	if db.Dialector.Name() == "postgres" {
+		signature = "(result_data::jsonb -> 'vmcore' ->> 'crash_signature')"
+	}
+	// fields are joined by a newline so a query cannot match across two of them
+	return fmt.Sprintf("LOWER(COALESCE(result, '') || '\n' || COALESCE(artifact_name, '') || '\n' || COALESCE(%s, ''))", signature)
+}
+
+// EnsureSearchIndex adds a pg_trgm index so substring search does not scan the
+// whole table, other databases fall back to a plain LIKE scan
+func EnsureSearchIndex(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return fmt.Errorf("enable pg_trgm: %w", err)
+	}
+	stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_tasks_search ON tasks USING gin ((%s) gin_trgm_ops)", TaskSearchText(db))
+	if err := db.Exec(stmt).Error; err != nil {
+		return fmt.Errorf("create search index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+)
+
+func TestLevelAndRequestID(t *testing.T) {
+	if _, err := New(config.LogConfig{Level: "loud"}, nil); err == nil {
+		t.Fatal("expected an unknown level to be rejected")
+	}
+
+	var buf bytes.Buffer
+	logger, err := New(config.LogConfig{Level: "warn"}, &buf)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := WithRequestID(context.Background(), "req-1")
+	task := &model.Task{ID: "t1", Type: model.TaskTypePatchApply, Status: model.StatusRunning, WorkerID: "worker-1"}
+	logger.InfoContext(ctx, "dropped")
+	logger.With("component", "test").WarnContext(ctx, "kept", Task(task)...)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected exactly one json line, got %q", buf.String())
+	}
+	for k, want := range map[string]string{
+		"msg": "kept", "request_id": "req-1", "component": "test",
+		"task_id": "t1", "task_type": "patch-apply", "status": "running", "worker_id": "worker-1",
+	} {
+		if entry[k] != want {
+			t.Errorf("%s = %v, want %s", k, entry[k], want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"net/http"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics is fed from scheduler transitions, so it moves exactly when task status does
+type Metrics struct {
+	registry  *prometheus.Registry
+	created   *prometheus.CounterVec
+	completed *prometheus.CounterVec
+	queueWait *prometheus.HistogramVec
+	execution *prometheus.HistogramVec
+	active    *prometheus.GaugeVec
+}
+
+// durations from a few seconds of patch apply up to multi-hour vmcore pulls
+var durationBuckets = prometheus.ExponentialBuckets(1, 2.5, 12)
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dumpmind_tasks_created_total",
+			Help: "Tasks submitted, by type.",
+		}, []string{"type"}),
+		completed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dumpmind_tasks_completed_total",
+			Help: "Tasks that reached a terminal status, by type and status.",
+		}, []string{"type", "status"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dumpmind_task_queue_wait_seconds",
+			Help:    "Time from creation to being claimed (StartedAt - CreatedAt).",
+			Buckets: durationBuckets,
+		}, []string{"type"}),
+		execution: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dumpmind_task_execution_seconds",
+			Help:    "Time from being claimed to finishing (FinishedAt - StartedAt).",
+			Buckets: durationBuckets,
+		}, []string{"type", "status"}),
+		active: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dumpmind_tasks",
+			Help: "Tasks currently pending, running or dead-lettered, by type and status.",
+		}, []string{"type", "status"}),
+	}
+	m.registry.MustRegister(m.created, m.completed, m.queueWait, m.execution, m.active)
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Seed initialises the pending/running/dead-lettered gauges from the database at startup
+func (m *Metrics) Seed(counts map[model.TaskType]map[model.TaskStatus]int64) {
+	for taskType, byStatus := range counts {
+		for _, status := range []model.TaskStatus{model.StatusPending, model.StatusRunning, model.StatusDeadLettered} {
+			m.active.WithLabelValues(string(taskType), string(status)).Set(float64(byStatus[status]))
+		}
+	}
+}
+
+// Observe is registered as a scheduler.Listener
+func (m *Metrics) Observe(t scheduler.Transition) {
+	taskType := string(t.Task.Type)
+	if t.From == "" {
+		m.created.WithLabelValues(taskType).Inc()
+	}
+	if tracked(t.From) {
+		m.active.WithLabelValues(taskType, string(t.From)).Dec()
+	}
+	if tracked(t.To) {
+		m.active.WithLabelValues(taskType, string(t.To)).Inc()
+	}
+
+	task := t.Task
+	if t.To == model.StatusRunning && task.StartedAt != nil {
+		m.queueWait.WithLabelValues(taskType).Observe(task.StartedAt.Sub(task.CreatedAt).Seconds())
+	}
+	if t.To.Terminal() {
+		m.completed.WithLabelValues(taskType, string(t.To)).Inc()
+		if task.StartedAt != nil && task.FinishedAt != nil {
+			m.execution.WithLabelValues(taskType, string(t.To)).Observe(task.FinishedAt.Sub(*task.StartedAt).Seconds())
+		}
+	}
+}
+
+// tracked statuses are the ones tasks can leave again, their current counts are gauges
+func tracked(s model.TaskStatus) bool {
+	return s == model.StatusPending || s == model.StatusRunning || s == model.StatusDeadLettered
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLifecycleUpdatesMetrics(t *testing.T) {
+	m := New()
+	created := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	started := created.Add(30 * time.Second)
+	finished := started.Add(2 * time.Minute)
+
+	task := model.Task{ID: "t1", Type: model.TaskTypeGetVmcore, Status: model.StatusPending, CreatedAt: created}
+	m.Observe(scheduler.Transition{Task: task, From: "", To: model.StatusPending})
+	task.Status, task.StartedAt = model.StatusRunning, &started
+	m.Observe(scheduler.Transition{Task: task, From: model.StatusPending, To: model.StatusRunning})
+
+	vmcore := string(model.TaskTypeGetVmcore)
+	if got := testutil.ToFloat64(m.active.WithLabelValues(vmcore, "running")); got != 1 {
+		t.Fatalf("running gauge = %v, want 1", got)
+	}
+
+	task.Status, task.FinishedAt = model.StatusSuccess, &finished
+	m.Observe(scheduler.Transition{Task: task, From: model.StatusRunning, To: model.StatusSuccess})
+
+	if got := testutil.ToFloat64(m.created.WithLabelValues(vmcore)); got != 1 {
+		t.Errorf("created = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.completed.WithLabelValues(vmcore, "success")); got != 1 {
+		t.Errorf("completed = %v, want 1", got)
+	}
+	for _, status := range []string{"pending", "running"} {
+		if got := testutil.ToFloat64(m.active.WithLabelValues(vmcore, status)); got != 0 {
+			t.Errorf("%s gauge = %v, want 0", status, got)
+		}
+	}
+	if n := testutil.CollectAndCount(m.queueWait); n != 1 {
+		t.Errorf("queue wait series = %d, want 1", n)
+	}
+	if n := testutil.CollectAndCount(m.execution); n != 1 {
+		t.Errorf("execution series = %d, want 1", n)
+	}
+}
+
+func TestDeadLetterIsTrackedApartFromFailures(t *testing.T) {
+	m := New()
+	task := model.Task{ID: "t1", Type: model.TaskTypePatchApply, Status: model.StatusDeadLettered}
+	m.Observe(scheduler.Transition{Task: task, From: model.StatusRunning, To: model.StatusDeadLettered})
+
+	patch := string(model.TaskTypePatchApply)
+	if got := testutil.ToFloat64(m.completed.WithLabelValues(patch, "failed")); got != 0 {
+		t.Errorf("failed = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(m.active.WithLabelValues(patch, "dead_lettered")); got != 1 {
+		t.Errorf("dead-letter gauge = %v, want 1", got)
+	}
+
+	task.Status = model.StatusPending
+	m.Observe(scheduler.Transition{Task: task, From: model.StatusDeadLettered, To: model.StatusPending})
+	if got := testutil.ToFloat64(m.active.WithLabelValues(patch, "dead_lettered")); got != 0 {
+		t.Errorf("dead-letter gauge after requeue = %v, want 0", got)
+	}
+}
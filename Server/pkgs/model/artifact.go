@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+)
+
+// Artifact is one file a task produced. The report names the primary artifact, which is
+// mirrored into the artifact fields of the task, workers may upload any number of others.
+type Artifact struct {
+	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID      string    `json:"task_id" gorm:"type:char(36);uniqueIndex:idx_artifacts_task_name"`
+	Name        string    `json:"name" gorm:"type:varchar(255);uniqueIndex:idx_artifacts_task_name"`
+	Path        string    `json:"path" gorm:"type:text"`                 // 存储后端中的 key
+	Size        int64     `json:"size" gorm:"not null;default:0"`        // 解压后的字节数
+	StoredSize  int64     `json:"stored_size" gorm:"not null;default:0"` // 实际存储的字节数
+	Compression string    `json:"compression" gorm:"type:varchar(16)"`   // gzip | zstd, 空表示未压缩
+	SHA256      string    `json:"sha256" gorm:"type:char(64)"`           // 解压后内容的校验和
+	ContentType string    `json:"content_type" gorm:"type:varchar(128)"`
+	Primary     bool      `json:"primary" gorm:"column:is_primary;not null;default:false"` // 即任务上的 artifact_* 字段
+	Attempt     int       `json:"attempt" gorm:"not null;default:0"`                       // 上传时任务的第几次尝试
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PrimaryArtifact is the artifact the task fields describe, nil when the task has none
+func (t *Task) PrimaryArtifact() *Artifact {
+	if t.ArtifactPath == "" {
+		return nil
+	}
+	for i := range t.Artifacts {
+		if t.Artifacts[i].Primary {
+			return &t.Artifacts[i]
+		}
+	}
+	// artifacts were not loaded, the task fields carry everything but the content type
+	return &Artifact{
+		TaskID:      t.ID,
+		Name:        t.ArtifactName,
+		Path:        t.ArtifactPath,
+		Size:        t.ArtifactSize,
+		StoredSize:  t.ArtifactStoredSize,
+		Compression: t.ArtifactCompression,
+		SHA256:      t.ArtifactSHA256,
+		Primary:     true,
+		Attempt:     t.Attempts,
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditCreated         AuditAction = "created"
+	AuditClaimed         AuditAction = "claimed"
+	AuditCancelRequested AuditAction = "cancel_requested"
+	// a pending task was cancelled before any worker took it
+	AuditCancelled  AuditAction = "cancelled"
+	AuditRetried    AuditAction = "retried"
+	AuditFinished   AuditAction = "finished"
+	AuditRequeued   AuditAction = "requeued"
+	AuditReassigned AuditAction = "reassigned"
+)
+
+// AuditActions lists every audit action
+var AuditActions = []AuditAction{AuditCreated, AuditClaimed, AuditCancelRequested, AuditCancelled, AuditRetried, AuditFinished, AuditRequeued, AuditReassigned}
+
+// AuditEvent records who did what to a task, it is written in the transaction of the change itself
+type AuditEvent struct {
+	ID        uint         `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID    string       `json:"task_id" gorm:"type:varchar(64);index"`
+	Actor     string       `json:"actor" gorm:"type:varchar(128)"` // worker:<id>, key:<指纹>, anonymous 或 system
+	Action    AuditAction  `json:"action" gorm:"type:varchar(32)"`
+	Details   AuditDetails `json:"details" gorm:"type:text"`
+	Timestamp time.Time    `json:"timestamp" gorm:"index"`
+}
+
+// AuditDetails is free-form context of an audit event stored as a json object
+type AuditDetails map[string]any
+
+func (d AuditDetails) Value() (driver.Value, error) {
+	if d == nil {
+		return "{}", nil
+	}
+	return valueJSON(map[string]any(d))
+}
+
+func (d *AuditDetails) Scan(src any) error {
+	return scanJSON(src, (*map[string]any)(d))
+}
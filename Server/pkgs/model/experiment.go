@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+)
+
+// Experiment groups the tasks spawned for one investigation
+type Experiment struct {
+	ID          string    `json:"id" gorm:"type:char(36);primaryKey"` // UUID 字符串
+	Name        string    `json:"name" gorm:"type:varchar(128)"`
+	Description string    `json:"description" gorm:"type:text"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// derived from the tasks of the experiment, not stored
+	Status     TaskStatus           `json:"status" gorm:"-"`
+	TaskCounts map[TaskStatus]int64 `json:"task_counts" gorm:"-"`
+}
+
+// ExperimentStatus folds the status counts of an experiment's tasks into one status:
+// running while any task runs or some already started, pending while nothing started,
+// and once everything finished success only if every task succeeded, otherwise failed
+// over cancelled
+func ExperimentStatus(counts map[TaskStatus]int64) TaskStatus {
+	var total, finished int64
+	for status, n := range counts {
+		total += n
+		if status.Terminal() {
+			finished += n
+		}
+	}
+	switch {
+	case total == 0:
+		return StatusPending
+	case counts[StatusRunning] > 0:
+		return StatusRunning
+	case finished == 0:
+		return StatusPending
+	case finished < total:
+		return StatusRunning
+	case counts[StatusFailed] > 0 || counts[StatusDeadLettered] > 0:
+		return StatusFailed
+	case counts[StatusCancelled] > 0:
+		return StatusCancelled
+	default:
+		return StatusSuccess
+	}
+}
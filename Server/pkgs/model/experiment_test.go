@@ -0,0 +1,25 @@
+package model
+
+import "testing"
+
+func TestExperimentStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		counts map[TaskStatus]int64
+		want   TaskStatus
+	}{
+		{"no tasks", nil, StatusPending},
+		{"all pending", map[TaskStatus]int64{StatusPending: 3}, StatusPending},
+		{"one running", map[TaskStatus]int64{StatusPending: 2, StatusRunning: 1, StatusFailed: 1}, StatusRunning},
+		{"partly done", map[TaskStatus]int64{StatusPending: 1, StatusSuccess: 2}, StatusRunning},
+		{"all succeeded", map[TaskStatus]int64{StatusSuccess: 4}, StatusSuccess},
+		{"one failed", map[TaskStatus]int64{StatusSuccess: 3, StatusFailed: 1, StatusCancelled: 1}, StatusFailed},
+		{"dead-lettered", map[TaskStatus]int64{StatusSuccess: 1, StatusDeadLettered: 1}, StatusFailed},
+		{"cancelled", map[TaskStatus]int64{StatusSuccess: 1, StatusCancelled: 1}, StatusCancelled},
+		{"zero counts are ignored", map[TaskStatus]int64{StatusSuccess: 2, StatusFailed: 0}, StatusSuccess},
+	} {
+		if got := ExperimentStatus(tc.counts); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
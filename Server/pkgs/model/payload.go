@@ -0,0 +1,162 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TaskPayload is the input of a task, only the section matching the task type is set
+type TaskPayload struct {
+	GetVmcore  *GetVmcorePayload  `json:"get_vmcore,omitempty"`
+	PatchApply *PatchApplyPayload `json:"patch_apply,omitempty"`
+}
+
+// GetVmcorePayload tells a worker which machine crashed and where its dump lives
+type GetVmcorePayload struct {
+	TargetHost string    `json:"target_host"`
+	Endpoint   string    `json:"endpoint"` // ssh://user@host:port 或 agent 的 http(s) 地址
+	CrashTime  time.Time `json:"crash_time"`
+	DumpPath   string    `json:"dump_path"`
+}
+
+type ApplyMode string
+
+const (
+	ApplyModeApply  ApplyMode = "apply"
+	ApplyModeDryRun ApplyMode = "dry-run"
+)
+
+// ApplyModes lists every patch apply mode
+var ApplyModes = []ApplyMode{ApplyModeApply, ApplyModeDryRun}
+
+// PatchApplyPayload lists the patches to apply, in order, against a target kernel
+type PatchApplyPayload struct {
+	Patches      []PatchRef `json:"patches"`
+	TargetKernel string     `json:"target_kernel"`
+	Mode         ApplyMode  `json:"mode"` // 为空时等同 apply
+}
+
+// PatchRef points at a patch either by url or by the id of the task whose artifact it is
+type PatchRef struct {
+	URL        string `json:"url,omitempty"`
+	ArtifactID string `json:"artifact_id,omitempty"`
+}
+
+// CheckSection makes sure exactly the section matching the task type is set
+func (p *TaskPayload) CheckSection(t TaskType) error {
+	if p == nil {
+		p = &TaskPayload{}
+	}
+	var errs ValidationError
+	switch t {
+	case TaskTypeGetVmcore:
+		if p.GetVmcore == nil || p.PatchApply != nil {
+			errs.Add("get_vmcore", "%s task requires exactly a get_vmcore payload", t)
+		}
+	case TaskTypePatchApply:
+		if p.PatchApply == nil || p.GetVmcore != nil {
+			errs.Add("patch_apply", "%s task requires exactly a patch_apply payload", t)
+		}
+	default:
+		return fmt.Errorf("unknown task type %q", t)
+	}
+	return errs.Err()
+}
+
+// DryRun reports whether the task must only produce a would-apply report
+func (p *TaskPayload) DryRun() bool {
+	return p != nil && p.PatchApply != nil && p.PatchApply.Mode == ApplyModeDryRun
+}
+
+func (p *GetVmcorePayload) Validate() error {
+	var errs ValidationError
+	if err := checkHost(p.TargetHost); err != nil {
+		errs.Add("get_vmcore.target_host", "%v", err)
+	}
+	u, err := url.Parse(p.Endpoint)
+	switch {
+	case err != nil || u.Host == "":
+		errs.Add("get_vmcore.endpoint", "invalid endpoint %q", p.Endpoint)
+	case u.Scheme != "ssh" && u.Scheme != "http" && u.Scheme != "https":
+		errs.Add("get_vmcore.endpoint", "endpoint scheme must be ssh, http or https, got %q", u.Scheme)
+	default:
+		if err := checkHost(u.Hostname()); err != nil {
+			errs.Add("get_vmcore.endpoint", "%v", err)
+		}
+	}
+	if p.CrashTime.IsZero() {
+		errs.Add("get_vmcore.crash_time", "crash_time is required")
+	}
+	if !path.IsAbs(p.DumpPath) {
+		errs.Add("get_vmcore.dump_path", "dump_path must be absolute, got %q", p.DumpPath)
+	}
+	return errs.Err()
+}
+
+// checkHost accepts what could name another machine on the network: a dns name or an ip
+// address that is neither loopback nor unspecified
+func checkHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+			return fmt.Errorf("host %q is not a reachable address", host)
+		}
+		return nil
+	}
+	if host == "" || len(host) > 253 || strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !hostLabel.MatchString(label) {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+	return nil
+}
+
+var hostLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+func (p *PatchApplyPayload) Validate() error {
+	var errs ValidationError
+	if len(p.Patches) == 0 {
+		errs.Add("patch_apply.patches", "at least one patch is required")
+	}
+	for i, ref := range p.Patches {
+		field := fmt.Sprintf("patch_apply.patches[%d]", i)
+		if (ref.URL == "") == (ref.ArtifactID == "") {
+			errs.Add(field, "exactly one of url and artifact_id must be set")
+			continue
+		}
+		if ref.URL != "" {
+			u, err := url.Parse(ref.URL)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				errs.Add(field+".url", "invalid patch url %q", ref.URL)
+			}
+		}
+	}
+	if p.TargetKernel == "" {
+		errs.Add("patch_apply.target_kernel", "target_kernel is required")
+	}
+	switch p.Mode {
+	case "", ApplyModeApply, ApplyModeDryRun:
+	default:
+		errs.Add("patch_apply.mode", "unknown apply mode %q", p.Mode)
+	}
+	return errs.Err()
+}
+
+func (p *TaskPayload) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return valueJSON(*p)
+}
+
+func (p *TaskPayload) Scan(src any) error {
+	return scanJSON(src, p)
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func validVmcorePayload() *GetVmcorePayload {
+	return &GetVmcorePayload{
+		TargetHost: "crash-host-01",
+		Endpoint:   "ssh://root@crash-host-01:22",
+		CrashTime:  time.Date(2025, 7, 1, 3, 4, 5, 0, time.UTC),
+		DumpPath:   "/var/crash/vmcore",
+	}
+}
+
+func TestTaskPayloadRoundTrip(t *testing.T) {
+	in := &TaskPayload{GetVmcore: validVmcorePayload()}
+	v, err := in.Value()
+	if err != nil {
+		t.Fatalf("value: %v", err)
+	}
+	var out TaskPayload
+	if err := out.Scan(v); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, &out)
+	}
+
+	// the wire form the worker decodes
+	b, _ := json.Marshal(in)
+	var wire map[string]map[string]any
+	if err := json.Unmarshal(b, &wire); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if wire["get_vmcore"]["target_host"] != "crash-host-01" || wire["get_vmcore"]["crash_time"] != "2025-07-01T03:04:05Z" {
+		t.Fatalf("unexpected wire form %s", b)
+	}
+}
+
+func TestGetVmcorePayloadValidate(t *testing.T) {
+	cases := map[string]func(p *GetVmcorePayload){
+		"empty host":       func(p *GetVmcorePayload) { p.TargetHost = "" },
+		"bad endpoint":     func(p *GetVmcorePayload) { p.Endpoint = "crash-host-01" },
+		"ftp endpoint":     func(p *GetVmcorePayload) { p.Endpoint = "ftp://crash-host-01" },
+		"no crash time":    func(p *GetVmcorePayload) { p.CrashTime = time.Time{} },
+		"relative dump":    func(p *GetVmcorePayload) { p.DumpPath = "vmcore" },
+		"host with spaces": func(p *GetVmcorePayload) { p.TargetHost = "crash host" },
+		"loopback host":    func(p *GetVmcorePayload) { p.TargetHost = "127.0.0.1" },
+		"localhost":        func(p *GetVmcorePayload) { p.TargetHost = "localhost" },
+		"label with dash":  func(p *GetVmcorePayload) { p.TargetHost = "-crash.example.org" },
+		"endpoint to self": func(p *GetVmcorePayload) { p.Endpoint = "ssh://root@[::1]:22" },
+	}
+	if err := validVmcorePayload().Validate(); err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+	for _, host := range []string{"10.1.2.3", "crash-host-01.lab.example.org", "fe80::1"} {
+		p := validVmcorePayload()
+		p.TargetHost = host
+		if err := p.Validate(); err != nil {
+			t.Errorf("host %s rejected: %v", host, err)
+		}
+	}
+	for name, mutate := range cases {
+		p := validVmcorePayload()
+		mutate(p)
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPatchApplyPayloadValidate(t *testing.T) {
+	valid := func() *PatchApplyPayload {
+		return &PatchApplyPayload{
+			Patches:      []PatchRef{{URL: "https://lore.kernel.org/0001.patch"}, {ArtifactID: "b7e0f5d2"}},
+			TargetKernel: "6.1.0",
+			Mode:         ApplyModeDryRun,
+		}
+	}
+	p := &TaskPayload{PatchApply: valid()}
+	if err := p.PatchApply.Validate(); err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+	if !p.DryRun() {
+		t.Fatalf("expected a dry run")
+	}
+
+	cases := map[string]func(p *PatchApplyPayload){
+		"no patches":   func(p *PatchApplyPayload) { p.Patches = nil },
+		"no kernel":    func(p *PatchApplyPayload) { p.TargetKernel = "" },
+		"both refs":    func(p *PatchApplyPayload) { p.Patches[1].URL = "https://example.org/x.patch" },
+		"empty ref":    func(p *PatchApplyPayload) { p.Patches[1] = PatchRef{} },
+		"bad url":      func(p *PatchApplyPayload) { p.Patches[0].URL = "file:///tmp/x.patch" },
+		"unknown mode": func(p *PatchApplyPayload) { p.Mode = "yolo" },
+	}
+	for name, mutate := range cases {
+		p := valid()
+		mutate(p)
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCheckSection(t *testing.T) {
+	var none *TaskPayload
+	if err := none.CheckSection(TaskTypeGetVmcore); err == nil {
+		t.Errorf("missing payload accepted")
+	}
+	if err := (&TaskPayload{PatchApply: &PatchApplyPayload{}}).CheckSection(TaskTypeGetVmcore); err == nil {
+		t.Errorf("patch payload accepted for a vmcore task")
+	}
+	both := &TaskPayload{GetVmcore: validVmcorePayload(), PatchApply: &PatchApplyPayload{}}
+	if err := both.CheckSection(TaskTypeGetVmcore); err == nil {
+		t.Errorf("payload with both sections accepted")
+	}
+	if err := (&TaskPayload{GetVmcore: validVmcorePayload()}).CheckSection(TaskTypeGetVmcore); err != nil {
+		t.Errorf("matching section rejected: %v", err)
+	}
+}
+
+func TestValidationErrorListsEveryField(t *testing.T) {
+	p := &PatchApplyPayload{Patches: []PatchRef{{URL: "https://lore.kernel.org/0001.patch"}, {URL: "ftp://x"}, {}}, Mode: "yolo"}
+	err := p.Validate()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+	var fields []string
+	for _, f := range invalid.Fields {
+		fields = append(fields, f.Field)
+	}
+	want := []string{"patch_apply.patches[1].url", "patch_apply.patches[2]", "patch_apply.target_kernel", "patch_apply.mode"}
+	if !slices.Equal(fields, want) {
+		t.Fatalf("fields %v, want %v", fields, want)
+	}
+}
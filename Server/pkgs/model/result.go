@@ -0,0 +1,70 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// TaskResult is the machine readable outcome of a task, Kind selects which of the
+// type specific sections is set
+type TaskResult struct {
+	Kind       TaskType          `json:"kind"`
+	Vmcore     *VmcoreResult     `json:"vmcore,omitempty"`
+	PatchApply *PatchApplyResult `json:"patch_apply,omitempty"`
+}
+
+type VmcoreResult struct {
+	Size           int64  `json:"size"`
+	KernelVersion  string `json:"kernel_version"`
+	CrashSignature string `json:"crash_signature"`
+}
+
+type PatchApplyResult struct {
+	Applied  []string       `json:"applied"`
+	Rejected []RejectedHunk `json:"rejected"`
+}
+
+type RejectedHunk struct {
+	Patch string `json:"patch"`
+	File  string `json:"file"`
+	Hunk  int    `json:"hunk"`
+}
+
+func NewVmcoreResult(r VmcoreResult) *TaskResult {
+	return &TaskResult{Kind: TaskTypeGetVmcore, Vmcore: &r}
+}
+
+func NewPatchApplyResult(r PatchApplyResult) *TaskResult {
+	return &TaskResult{Kind: TaskTypePatchApply, PatchApply: &r}
+}
+
+// Validate checks the result belongs to a task of type t and only carries its own section
+func (r *TaskResult) Validate(t TaskType) error {
+	if r.Kind != t {
+		return fmt.Errorf("result kind %q does not match task type %q", r.Kind, t)
+	}
+	switch r.Kind {
+	case TaskTypeGetVmcore:
+		if r.Vmcore == nil || r.PatchApply != nil {
+			return fmt.Errorf("%s result must only carry the vmcore section", r.Kind)
+		}
+	case TaskTypePatchApply:
+		if r.PatchApply == nil || r.Vmcore != nil {
+			return fmt.Errorf("%s result must only carry the patch_apply section", r.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown result kind %q", r.Kind)
+	}
+	return nil
+}
+
+func (r *TaskResult) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return valueJSON(*r)
+}
+
+func (r *TaskResult) Scan(src any) error {
+	return scanJSON(src, r)
+}
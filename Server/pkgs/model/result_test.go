@@ -0,0 +1,64 @@
+package model_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"Server/pkgs/config"
+	"Server/pkgs/database"
+	"Server/pkgs/model"
+)
+
+func TestTaskResultRoundTrip(t *testing.T) {
+	db, err := database.Open(config.DatabaseConfig{Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	cases := map[string]*model.Task{
+		"vmcore": {ID: "vmcore", Type: model.TaskTypeGetVmcore, ResultData: model.NewVmcoreResult(model.VmcoreResult{
+			Size:           1 << 30,
+			KernelVersion:  "6.1.0",
+			CrashSignature: "BUG: KASAN: use-after-free in tcp_v4_rcv",
+		})},
+		"patch-apply": {ID: "patch-apply", Type: model.TaskTypePatchApply, ResultData: model.NewPatchApplyResult(model.PatchApplyResult{
+			Applied:  []string{"0001-fix.patch"},
+			Rejected: []model.RejectedHunk{{Patch: "0002-more.patch", File: "net/ipv4/tcp.c", Hunk: 3}},
+		})},
+		"none": {ID: "none", Type: model.TaskTypeGetVmcore},
+	}
+	for name, task := range cases {
+		t.Run(name, func(t *testing.T) {
+			if task.ResultData != nil {
+				if err := task.ResultData.Validate(task.Type); err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+			}
+			if err := db.Create(task).Error; err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			var got model.Task
+			if err := db.First(&got, "id = ?", task.ID).Error; err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if !reflect.DeepEqual(got.ResultData, task.ResultData) {
+				t.Fatalf("round trip mismatch: got %+v want %+v", got.ResultData, task.ResultData)
+			}
+		})
+	}
+}
+
+func TestTaskResultValidate(t *testing.T) {
+	r := model.NewVmcoreResult(model.VmcoreResult{})
+	if err := r.Validate(model.TaskTypePatchApply); err == nil {
+		t.Fatalf("expected kind mismatch to be rejected")
+	}
+	r.PatchApply = &model.PatchApplyResult{}
+	if err := r.Validate(model.TaskTypeGetVmcore); err == nil {
+		t.Fatalf("expected a foreign section to be rejected")
+	}
+}
@@ -1,7 +1,11 @@
 package model
 
 import (
+	"database/sql/driver"
+	"slices"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type TaskType string
@@ -11,25 +15,101 @@ const (
 	TaskTypePatchApply TaskType = "patch-apply"
 )
 
+// TaskTypes lists every known task type
+var TaskTypes = []TaskType{TaskTypeGetVmcore, TaskTypePatchApply}
+
+func (t TaskType) Valid() bool {
+	return slices.Contains(TaskTypes, t)
+}
+
 type TaskStatus string
 
 const (
-	StatusPending TaskStatus = "pending"
-	StatusRunning TaskStatus = "running"
-	StatusSuccess TaskStatus = "success"
-	StatusFailed  TaskStatus = "failed"
+	StatusPending   TaskStatus = "pending"
+	StatusRunning   TaskStatus = "running"
+	StatusSuccess   TaskStatus = "success"
+	StatusFailed    TaskStatus = "failed"
+	StatusCancelled TaskStatus = "cancelled"
+	// retries are exhausted, the task waits for inspection and a manual requeue
+	StatusDeadLettered TaskStatus = "dead_lettered"
 )
 
+// TaskStatuses lists every task status
+var TaskStatuses = []TaskStatus{StatusPending, StatusRunning, StatusSuccess, StatusFailed, StatusCancelled, StatusDeadLettered}
+
+func (s TaskStatus) Valid() bool {
+	switch s {
+	case StatusPending, StatusRunning, StatusSuccess, StatusFailed, StatusCancelled, StatusDeadLettered:
+		return true
+	}
+	return false
+}
+
+func (s TaskStatus) Terminal() bool {
+	return s == StatusSuccess || s == StatusFailed || s == StatusCancelled || s == StatusDeadLettered
+}
+
 type Task struct {
-	ID     string     `json:"id" gorm:"type:char(36);primaryKey"` // UUID 字符串
-	Type   TaskType   `json:"type" gorm:"type:varchar(32)"`
-	Status TaskStatus `json:"status" gorm:"type:varchar(32)"`
-	//Payload      CrashReport   `json:"payload" gorm:"type:json"`
-	WorkerID     string     `json:"worker_id" gorm:"type:varchar(64);index"`
-	Result       string     `json:"result" gorm:"type:text"`
-	ArtifactPath string     `json:"artifact_path" gorm:"type:text"`
-	ArtifactName string     `json:"artifact_name" gorm:"type:text"`
-	CreatedAt    time.Time  `json:"created_at"`
-	StartedAt    *time.Time `json:"started_at"`
-	FinishedAt   *time.Time `json:"finished_at"`
+	ID                   string         `json:"id" gorm:"type:char(36);primaryKey"` // UUID 字符串
+	Type                 TaskType       `json:"type" gorm:"type:varchar(32);uniqueIndex:idx_tasks_idempotency"`
+	Status               TaskStatus     `json:"status" gorm:"type:varchar(32)"`
+	Payload              *TaskPayload   `json:"payload" gorm:"type:text"`
+	IdempotencyKey       *string        `json:"idempotency_key,omitempty" gorm:"type:varchar(128);uniqueIndex:idx_tasks_idempotency"` // 同类型内唯一
+	ExperimentID         string         `json:"experiment_id" gorm:"type:varchar(36);index"`                                          // 所属实验, 可为空
+	RerunOf              string         `json:"rerun_of,omitempty" gorm:"type:varchar(36);index"`                                     // 由哪个任务重跑而来
+	WorkerID             string         `json:"worker_id" gorm:"type:varchar(64);index"`
+	Result               string         `json:"result" gorm:"type:text"` // 可读的结果摘要
+	ResultData           *TaskResult    `json:"result_data" gorm:"type:text"`
+	ArtifactPath         string         `json:"artifact_path" gorm:"type:text"` // 存储后端中的 key
+	ArtifactName         string         `json:"artifact_name" gorm:"type:text"`
+	ArtifactSize         int64          `json:"artifact_size" gorm:"not null;default:0"`        // 解压后的字节数
+	ArtifactStoredSize   int64          `json:"artifact_stored_size" gorm:"not null;default:0"` // 实际存储的字节数
+	ArtifactCompression  string         `json:"artifact_compression" gorm:"type:varchar(16)"`   // gzip | zstd, 空表示未压缩
+	ArtifactSHA256       string         `json:"artifact_sha256" gorm:"type:char(64)"`
+	ArtifactGCedAt       *time.Time     `json:"artifact_gced_at" gorm:"column:artifact_gced_at"` // 保留期过后 artifact 文件被删除的时间
+	Priority             int            `json:"priority" gorm:"not null;default:0;index"`        // 越大越优先
+	Progress             int            `json:"progress" gorm:"not null;default:0"`              // 0-100, 当前尝试的进度
+	Attempts             int            `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts          int            `json:"max_attempts" gorm:"not null;default:1"`
+	AttemptHistory       AttemptHistory `json:"attempt_history" gorm:"type:text"`
+	NextRetryAt          *time.Time     `json:"next_retry_at"`                                 // 失败重试前不参与分发
+	NotBefore            *time.Time     `json:"not_before" gorm:"index"`                       // 此时间之前不参与分发
+	LeaseExpiresAt       *time.Time     `json:"lease_expires_at" gorm:"index"`                 // worker 需在此之前续约, 否则任务被回收
+	PendingTTLSeconds    int            `json:"pending_ttl_seconds" gorm:"not null;default:0"` // 可分发后多久无人领取即过期, 0 表示不过期
+	ExpiresAt            *time.Time     `json:"expires_at" gorm:"index"`                       // 此时间前未被领取则失败
+	TimeoutSeconds       int            `json:"timeout_seconds" gorm:"not null;default:0"`
+	CancelRequested      bool           `json:"cancel_requested" gorm:"not null;default:false"` // 运行中被取消, 等待 worker 停止
+	DependsOn            StringList     `json:"depends_on" gorm:"type:text"`
+	Blocked              bool           `json:"blocked" gorm:"not null;default:false;index"` // 依赖尚未全部成功
+	RequiredCapabilities StringList     `json:"required_capabilities" gorm:"type:text"`
+	ExcludedWorkers      StringList     `json:"excluded_workers" gorm:"type:text"` // 管理员改派后不再分发给这些 worker
+	Env                  StringMap      `json:"env" gorm:"type:text"`              // 传给 worker 的环境变量
+	Version              int            `json:"version" gorm:"not null;default:0"` // 每次更新加一, 用于乐观锁
+	CreatedAt            time.Time      `json:"created_at"`
+	StartedAt            *time.Time     `json:"started_at"`
+	FinishedAt           *time.Time     `json:"finished_at"`
+	DeletedAt            gorm.DeletedAt `json:"archived_at" gorm:"index"` // 归档即软删除
+	Artifacts            []Artifact     `json:"artifacts,omitempty" gorm:"foreignKey:TaskID"`
+}
+
+// Attempt records the outcome of a single execution of a task
+type Attempt struct {
+	Attempt    int        `json:"attempt"`
+	WorkerID   string     `json:"worker_id"`
+	Error      string     `json:"error"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+}
+
+type AttemptHistory []Attempt
+
+func (h AttemptHistory) Value() (driver.Value, error) {
+	if h == nil {
+		return "[]", nil
+	}
+	return valueJSON([]Attempt(h))
+}
+
+func (h *AttemptHistory) Scan(src any) error {
+	return scanJSON(src, (*[]Attempt)(h))
 }
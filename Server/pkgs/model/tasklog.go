@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+// TaskLog is one chunk of the output a worker streamed while running a task. Chunks are
+// only ever appended, the oldest are dropped once the log outgrows its cap.
+type TaskLog struct {
+	ID         uint      `json:"-" gorm:"primaryKey;autoIncrement"`
+	TaskID     string    `json:"task_id" gorm:"type:char(36);uniqueIndex:idx_task_logs_seq"`
+	Seq        int64     `json:"seq" gorm:"not null;uniqueIndex:idx_task_logs_seq"` // 任务内从 1 开始的序号
+	ByteOffset int64     `json:"offset" gorm:"not null;default:0"`                  // 本段在完整日志中的起始字节
+	Size       int64     `json:"size" gorm:"not null;default:0"`
+	Attempt    int       `json:"attempt" gorm:"not null;default:0"`
+	Data       string    `json:"data" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
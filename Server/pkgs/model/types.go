@@ -0,0 +1,63 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// scanJSON decodes a json column into dst, accepting both text and blob drivers
+func scanJSON(src any, dst any) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			return nil
+		}
+		return json.Unmarshal(v, dst)
+	case string:
+		if v == "" {
+			return nil
+		}
+		return json.Unmarshal([]byte(v), dst)
+	default:
+		return fmt.Errorf("unsupported json column type %T", src)
+	}
+}
+
+func valueJSON(v any) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// StringList is a []string stored as a json array
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	return valueJSON([]string(l))
+}
+
+func (l *StringList) Scan(src any) error {
+	return scanJSON(src, (*[]string)(l))
+}
+
+// StringMap is a map[string]string stored as a json object
+type StringMap map[string]string
+
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	return valueJSON(map[string]string(m))
+}
+
+func (m *StringMap) Scan(src any) error {
+	return scanJSON(src, (*map[string]string)(m))
+}
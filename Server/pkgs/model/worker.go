@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+)
+
+type Worker struct {
+	ID           string     `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Capabilities StringList `json:"capabilities" gorm:"type:text"`
+	LastSeenAt   time.Time  `json:"last_seen_at" gorm:"index"`
+	Draining     bool       `json:"draining" gorm:"not null;default:false"` // 不再领取新任务
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// WorkerToken authenticates one worker, only the sha256 of the token is stored
+type WorkerToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	WorkerID   string     `json:"worker_id" gorm:"type:varchar(64);index"`
+	TokenHash  string     `json:"-" gorm:"type:char(64);uniqueIndex"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"` // 最近一次认证
+}
+
+// RunOutcome is how the stint of a worker on a task ended
+type RunOutcome string
+
+const (
+	RunSucceeded RunOutcome = "succeeded"
+	RunFailed    RunOutcome = "failed"
+	RunTimedOut  RunOutcome = "timed_out"
+	RunCancelled RunOutcome = "cancelled"
+	// the lease ran out before the worker reported
+	RunReclaimed  RunOutcome = "reclaimed"
+	RunReassigned RunOutcome = "reassigned"
+)
+
+// RunOutcomes lists every run outcome
+var RunOutcomes = []RunOutcome{RunSucceeded, RunFailed, RunTimedOut, RunCancelled, RunReclaimed, RunReassigned}
+
+// WorkerRun is one attempt of a task on a worker, written when the task stops running
+// there. Worker stats are counted from these, so a task that moved between workers counts
+// once for every worker that held it.
+type WorkerRun struct {
+	ID         uint       `json:"-" gorm:"primaryKey;autoIncrement"`
+	TaskID     string     `json:"task_id" gorm:"type:char(36);index"`
+	Attempt    int        `json:"attempt" gorm:"not null;default:0"`
+	WorkerID   string     `json:"worker_id" gorm:"type:varchar(64);index:idx_worker_runs_finished"`
+	Outcome    RunOutcome `json:"outcome" gorm:"type:varchar(16)"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at" gorm:"index:idx_worker_runs_finished"`
+	BusyMillis int64      `json:"busy_millis" gorm:"not null;default:0"` // 运行时长
+}
+
+// CanRun reports whether the worker's capabilities cover everything the task requires
+func (w *Worker) CanRun(t *Task) bool {
+	have := make(map[string]struct{}, len(w.Capabilities))
+	for _, c := range w.Capabilities {
+		have[c] = struct{}{}
+	}
+	for _, c := range t.RequiredCapabilities {
+		if _, ok := have[c]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// TypeLock has one row per task type with a concurrency limit, claims lock it so the
+// running count they check cannot change under them
+type TypeLock struct {
+	Type     TaskType `gorm:"type:varchar(32);primaryKey"`
+	LockedAt time.Time
+}
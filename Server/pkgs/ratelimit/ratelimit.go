@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"Server/pkgs/config"
+)
+
+// maxBuckets bounds memory when many distinct keys show up, idle buckets are dropped past it
+const maxBuckets = 10000
+
+// Limiter hands out tokens per api key
+type Limiter struct {
+	mu        sync.Mutex
+	rule      config.RateLimitRule
+	overrides map[string]config.RateLimitRule
+	anonymous *bucket
+	buckets   map[string]*bucket
+	now       func() time.Time
+}
+
+type Option func(*Limiter)
+
+// WithClock replaces the wall clock, mostly useful for tests
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+func New(cfg config.RateLimitConfig, opts ...Option) *Limiter {
+	l := &Limiter{
+		rule:      cfg.RateLimitRule,
+		overrides: cfg.Keys,
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.anonymous = newBucket(l.rule, l.now())
+	return l
+}
+
+// Allow takes a token for key, an empty key is an anonymous caller. When the bucket
+// is empty it returns false and how long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	b := l.anonymous
+	if key != "" {
+		b = l.buckets[key]
+		if b == nil {
+			if len(l.buckets) >= maxBuckets {
+				l.evict(now)
+			}
+			rule, ok := l.overrides[key]
+			if !ok {
+				rule = l.rule
+			}
+			b = newBucket(rule, now)
+			l.buckets[key] = b
+		}
+	}
+	return b.take(now)
+}
+
+// evict drops buckets that refilled completely, they behave like fresh ones anyway
+func (l *Limiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type bucket struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+func newBucket(rule config.RateLimitRule, now time.Time) *bucket {
+	burst := float64(max(rule.Burst, 1))
+	return &bucket{
+		perSecond: float64(rule.RequestsPerMinute) / 60,
+		burst:     burst,
+		tokens:    burst,
+		last:      now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.perSecond)
+		b.last = now
+	}
+}
+
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	if b.perSecond <= 0 {
+		return true, 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.perSecond
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
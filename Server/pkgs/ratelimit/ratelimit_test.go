@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"Server/pkgs/config"
+
+	"github.com/BurntSushi/toml"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(config.RateLimitConfig{
+		RateLimitRule: config.RateLimitRule{RequestsPerMinute: 60, Burst: 2},
+		Keys:          map[string]config.RateLimitRule{"trusted": {RequestsPerMinute: 600, Burst: 10}},
+	}, WithClock(clock.Now))
+
+	for i := range 2 {
+		if ok, _ := l.Allow("script"); !ok {
+			t.Fatalf("request %d within burst was refused", i)
+		}
+	}
+	ok, wait := l.Allow("script")
+	if ok || wait != time.Second {
+		t.Fatalf("expected a refusal with 1s wait, got ok=%v wait=%s", ok, wait)
+	}
+	// other keys and anonymous callers have their own buckets
+	if ok, _ := l.Allow("other"); !ok {
+		t.Fatalf("another key was throttled")
+	}
+	if ok, _ := l.Allow(""); !ok {
+		t.Fatalf("anonymous caller was throttled")
+	}
+
+	clock.Advance(time.Second)
+	if ok, _ := l.Allow("script"); !ok {
+		t.Fatalf("token was not refilled")
+	}
+
+	for i := range 10 {
+		if ok, _ := l.Allow("trusted"); !ok {
+			t.Fatalf("override burst: request %d refused", i)
+		}
+	}
+	if _, wait := l.Allow("trusted"); wait != 100*time.Millisecond {
+		t.Fatalf("override rate: expected 100ms wait, got %s", wait)
+	}
+}
+
+func TestDisabledRule(t *testing.T) {
+	l := New(config.RateLimitConfig{})
+	for range 100 {
+		if ok, _ := l.Allow(""); !ok {
+			t.Fatalf("disabled limiter refused a request")
+		}
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	var cfg config.RateLimitConfig
+	_, err := toml.Decode(`
+requests_per_minute = 30
+burst = 5
+
+[keys."ci-key"]
+requests_per_minute = 3000
+burst = 100
+`, &cfg)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if cfg.RequestsPerMinute != 30 || cfg.Burst != 5 || cfg.Keys["ci-key"].Burst != 100 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
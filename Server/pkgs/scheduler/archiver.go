@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+// Archiver periodically soft-deletes old finished tasks so they drop out of the hot queries
+type Archiver struct {
+	sched     *Scheduler
+	interval  time.Duration
+	retention time.Duration
+}
+
+func NewArchiver(sched *Scheduler, interval, retention time.Duration) *Archiver {
+	return &Archiver{sched: sched, interval: interval, retention: retention}
+}
+
+// Run blocks until ctx is cancelled
+func (a *Archiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Tick(ctx)
+		}
+	}
+}
+
+func (a *Archiver) Tick(ctx context.Context) {
+	n, err := a.sched.Archive(ctx, a.retention)
+	if err != nil {
+		slog.ErrorContext(ctx, "archiver: failed to archive tasks", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "archiver: archived tasks", "count", n)
+	}
+}
+
+// Archive soft-deletes tasks that finished more than olderThan ago. Pending and running
+// tasks are never touched, however old they are.
+func (s *Scheduler) Archive(ctx context.Context, olderThan time.Duration) (int64, error) {
+	var archived int64
+	err := s.write(func() error {
+		res := s.db.WithContext(ctx).
+			Where("status IN ?", []model.TaskStatus{model.StatusSuccess, model.StatusFailed, model.StatusCancelled}).
+			Where("finished_at < ?", s.now().Add(-olderThan)).
+			Delete(&model.Task{})
+		archived = res.RowsAffected
+		return res.Error
+	})
+	return archived, err
+}
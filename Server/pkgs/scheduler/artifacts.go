@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// AddArtifact records a file a worker uploaded for its running task, uploading a name
+// again replaces the earlier record
+func (s *Scheduler) AddArtifact(ctx context.Context, workerID string, a *model.Artifact) error {
+	return s.inTx(ctx, func(t *txn) error {
+		task, err := t.load(a.TaskID)
+		if err != nil {
+			return err
+		}
+		if task.Status != model.StatusRunning {
+			return ErrInvalidState
+		}
+		if task.WorkerID != workerID {
+			return ErrNotOwner
+		}
+		if task.Payload.DryRun() {
+			return fmt.Errorf("%w: dry-run tasks must not produce an artifact", ErrInvalidTask)
+		}
+		if a.ContentType == "" {
+			a.ContentType = defaultContentType
+		}
+		a.Primary = false
+		a.Attempt = task.Attempts
+		a.CreatedAt = s.now()
+		return upsertArtifact(t.db, a, "path", "size", "stored_size", "compression", "sha256", "content_type", "attempt", "created_at")
+	})
+}
+
+// setPrimaryArtifact records the artifact a success report named as the primary one,
+// the content type of an earlier upload under the same name is kept
+func setPrimaryArtifact(db *gorm.DB, task *model.Task, a *model.Artifact) error {
+	err := db.Model(&model.Artifact{}).Where("task_id = ? AND name <> ?", task.ID, a.Name).Update("is_primary", false).Error
+	if err != nil {
+		return err
+	}
+	a.TaskID = task.ID
+	a.ContentType = defaultContentType
+	a.Primary = true
+	a.Attempt = task.Attempts
+	return upsertArtifact(db, a, "path", "size", "stored_size", "compression", "sha256", "is_primary", "attempt")
+}
+
+func upsertArtifact(db *gorm.DB, a *model.Artifact, update ...string) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns(update),
+	}).Create(a).Error
+}
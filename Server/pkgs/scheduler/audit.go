@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"context"
+
+	"Server/pkgs/model"
+)
+
+// SystemActor is the audit actor of changes nobody asked for, like the reaper's
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor attributes the changes made with ctx to actor in the audit log
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// audit queues an event to be inserted right before the transaction commits
+func (t *txn) audit(task *model.Task, action model.AuditAction, details model.AuditDetails) {
+	t.audits = append(t.audits, model.AuditEvent{
+		TaskID:    task.ID,
+		Actor:     t.actor,
+		Action:    action,
+		Details:   details,
+		Timestamp: t.now,
+	})
+}
+
+// auditAs makes the next transition of the transaction audited as action instead of the default
+func (t *txn) auditAs(action model.AuditAction, details model.AuditDetails) {
+	t.override = &model.AuditEvent{Action: action, Details: details}
+}
+
+// auditTransition turns a status change into the matching audit event
+func (t *txn) auditTransition(task *model.Task, from model.TaskStatus) {
+	if t.override != nil {
+		t.audit(task, t.override.Action, t.override.Details)
+		t.override = nil
+		return
+	}
+	switch {
+	case from == "":
+		t.audit(task, model.AuditCreated, model.AuditDetails{"type": task.Type, "priority": task.Priority})
+	case task.Status == model.StatusRunning:
+		t.audit(task, model.AuditClaimed, model.AuditDetails{"worker_id": task.WorkerID, "attempt": task.Attempts})
+	case task.Status == model.StatusPending && from == model.StatusDeadLettered:
+		t.audit(task, model.AuditRequeued, nil)
+	case task.Status == model.StatusPending && from == model.StatusRunning:
+		t.audit(task, model.AuditRetried, model.AuditDetails{"attempt": task.Attempts, "reason": task.Result})
+	case task.Status.Terminal():
+		t.audit(task, model.AuditFinished, model.AuditDetails{"from": from, "status": task.Status, "result": task.Result})
+	}
+}
+
+// AuditLog lists the audit events of a task oldest first
+func (s *Scheduler) AuditLog(ctx context.Context, taskID string) ([]model.AuditEvent, error) {
+	events := []model.AuditEvent{}
+	err := s.db.WithContext(ctx).
+		Where("task_id = ?", taskID).
+		Order("timestamp ASC, id ASC").
+		Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,56 @@
+package scheduler
+
+import (
+	"context"
+
+	"Server/pkgs/model"
+)
+
+// Cancel stops a task: pending tasks are cancelled at once, running ones are flagged
+// so the owning worker aborts and reports back.
+func (s *Scheduler) Cancel(ctx context.Context, id string) (*model.Task, error) {
+	var task *model.Task
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			var err error
+			if task, err = t.load(id); err != nil {
+				return err
+			}
+
+			var updates map[string]any
+			switch task.Status {
+			case model.StatusPending:
+				updates = map[string]any{
+					"status":      model.StatusCancelled,
+					"finished_at": s.now(),
+				}
+				t.auditAs(model.AuditCancelled, model.AuditDetails{"from": task.Status})
+			case model.StatusRunning:
+				updates = map[string]any{"cancel_requested": true}
+			default:
+				return ErrInvalidState
+			}
+			ok, err := s.transition(t, task, updates)
+			if err == nil && !ok {
+				err = ErrConflict
+			}
+			if ok && task.Status == model.StatusRunning {
+				t.audit(task, model.AuditCancelRequested, nil)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// PendingCancels lists running tasks of the worker that it should abort
+func (s *Scheduler) PendingCancels(ctx context.Context, workerID string) ([]string, error) {
+	ids := []string{}
+	err := s.db.WithContext(ctx).Model(&model.Task{}).
+		Where("worker_id = ? AND status = ? AND cancel_requested", workerID, model.StatusRunning).
+		Pluck("id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewConcurrencyLimits reads the per type limits from the config, rejecting unknown types
+func NewConcurrencyLimits(cfg config.ConcurrencyConfig) (map[model.TaskType]int, error) {
+	limits := make(map[model.TaskType]int, len(cfg.Limits))
+	for name, limit := range cfg.Limits {
+		taskType := model.TaskType(name)
+		if !taskType.Valid() {
+			return nil, fmt.Errorf("concurrency limit for unknown task type %q", name)
+		}
+		limits[taskType] = limit
+	}
+	return limits, nil
+}
+
+// WithConcurrencyLimits caps how many tasks of a type may run at once, types without
+// a positive limit are unlimited
+func WithConcurrencyLimits(limits map[model.TaskType]int) Option {
+	return func(s *Scheduler) {
+		s.limits = make(map[model.TaskType]int, len(limits))
+		for taskType, limit := range limits {
+			if limit > 0 {
+				s.limits[taskType] = limit
+			}
+		}
+	}
+}
+
+// TypeUsage is how many tasks of a type run against its limit, zero meaning unlimited
+type TypeUsage struct {
+	Type    model.TaskType `json:"type"`
+	Running int64          `json:"running"`
+	Limit   int            `json:"limit"`
+}
+
+// ConcurrencyUsage reports the running count and limit of every task type
+func (s *Scheduler) ConcurrencyUsage(ctx context.Context) ([]TypeUsage, error) {
+	running, err := countRunningByType(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]TypeUsage, len(model.TaskTypes))
+	for i, taskType := range model.TaskTypes {
+		usage[i] = TypeUsage{Type: taskType, Running: running[taskType], Limit: s.limits[taskType]}
+	}
+	return usage, nil
+}
+
+// saturatedTypes lists the types that reached their limit, claims skip them up front
+func (s *Scheduler) saturatedTypes(db *gorm.DB) ([]model.TaskType, error) {
+	if len(s.limits) == 0 {
+		return nil, nil
+	}
+	running, err := countRunningByType(db)
+	if err != nil {
+		return nil, err
+	}
+	var full []model.TaskType
+	for taskType := range s.limits {
+		if s.saturated(taskType, running) {
+			full = append(full, taskType)
+		}
+	}
+	return full, nil
+}
+
+// saturated reports whether the type runs at its limit given the running counts
+func (s *Scheduler) saturated(taskType model.TaskType, running map[model.TaskType]int64) bool {
+	limit, ok := s.limits[taskType]
+	return ok && running[taskType] >= int64(limit)
+}
+
+// acquireSlot reports whether one more task of the type may start. It locks the type's
+// row first, so concurrent claims for a limited type are serialised until commit and
+// can never push the running count over the limit together.
+func (s *Scheduler) acquireSlot(t *txn, taskType model.TaskType) (bool, error) {
+	limit, ok := s.limits[taskType]
+	if !ok {
+		return true, nil
+	}
+	lock := model.TypeLock{Type: taskType, LockedAt: t.now}
+	if err := t.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock).Error; err != nil {
+		return false, err
+	}
+	if err := t.db.Model(&model.TypeLock{}).Where("type = ?", taskType).Update("locked_at", t.now).Error; err != nil {
+		return false, err
+	}
+	var running int64
+	err := t.db.Model(&model.Task{}).Where("type = ? AND status = ?", taskType, model.StatusRunning).Count(&running).Error
+	return running < int64(limit), err
+}
+
+func countRunningByType(db *gorm.DB) (map[model.TaskType]int64, error) {
+	var rows []struct {
+		Type  model.TaskType
+		Count int64
+	}
+	err := db.Model(&model.Task{}).
+		Select("type, COUNT(*) AS count").
+		Where("status = ?", model.StatusRunning).
+		Group("type").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[model.TaskType]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Type] = row.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"context"
+
+	"Server/pkgs/model"
+)
+
+// Requeue gives a dead-lettered task a fresh set of attempts, the attempt history
+// is kept for inspection
+func (s *Scheduler) Requeue(ctx context.Context, id string) (*model.Task, error) {
+	var task *model.Task
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			var err error
+			if task, err = t.load(id); err != nil {
+				return err
+			}
+			if task.Status != model.StatusDeadLettered {
+				return ErrInvalidState
+			}
+			ok, err := s.transition(t, task, map[string]any{
+				"status":           model.StatusPending,
+				"attempts":         0,
+				"worker_id":        "",
+				"result":           "",
+				"result_data":      nil,
+				"progress":         0,
+				"cancel_requested": false,
+				"started_at":       nil,
+				"lease_expires_at": nil,
+				"finished_at":      nil,
+				"next_retry_at":    nil,
+				"expires_at":       nil,
+			})
+			if err == nil && !ok {
+				err = ErrConflict
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
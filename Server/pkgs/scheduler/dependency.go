@@ -0,0 +1,180 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// checkDependencies makes sure every dependency of the submitted tasks either exists
+// or is part of the same submission, and that the resulting graph has no cycle.
+// On failure it also returns the index of the offending task.
+func checkDependencies(tx *gorm.DB, tasks []*model.Task) (int, error) {
+	graph := make(map[string][]string, len(tasks))
+	for _, task := range tasks {
+		graph[task.ID] = task.DependsOn
+	}
+
+	var external []string
+	for _, task := range tasks {
+		for _, dep := range task.DependsOn {
+			if _, ok := graph[dep]; !ok {
+				external = append(external, dep)
+			}
+		}
+	}
+	if len(external) > 0 {
+		var existing []model.Task
+		if err := tx.Unscoped().Select("id", "depends_on").Where("id IN ?", external).Find(&existing).Error; err != nil {
+			return 0, err
+		}
+		for _, task := range existing {
+			graph[task.ID] = task.DependsOn
+		}
+		for i, task := range tasks {
+			for _, dep := range task.DependsOn {
+				if _, ok := graph[dep]; !ok {
+					return i, fmt.Errorf("%w: unknown dependency %s", ErrInvalidTask, dep)
+				}
+			}
+		}
+	}
+
+	// existing tasks can only point at older tasks, so a cycle has to go through a submitted one
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(graph))
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("%w: dependency cycle through %s", ErrInvalidTask, id)
+		case done:
+			return nil
+		}
+		state[id] = visiting
+		for _, dep := range graph[id] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[id] = done
+		return nil
+	}
+	for i, task := range tasks {
+		if err := visit(task.ID); err != nil {
+			return i, err
+		}
+	}
+	return 0, nil
+}
+
+// applyDependencies sets the initial blocked/failed state of a task about to be inserted
+func (s *Scheduler) applyDependencies(tx *gorm.DB, task *model.Task) error {
+	blocked, failedDep, err := dependencyState(tx, task.DependsOn)
+	if err != nil {
+		return err
+	}
+	if failedDep != nil {
+		now := s.now()
+		task.Status = model.StatusFailed
+		task.Result = dependencyFailedResult(failedDep)
+		task.FinishedAt = &now
+		return nil
+	}
+	task.Blocked = blocked
+	return nil
+}
+
+// dependencyState reports whether any dependency is still unfinished, and the first
+// one that ended without success
+func dependencyState(tx *gorm.DB, deps []string) (blocked bool, failed *model.Task, err error) {
+	if len(deps) == 0 {
+		return false, nil, nil
+	}
+	unique := make(map[string]struct{}, len(deps))
+	for _, dep := range deps {
+		unique[dep] = struct{}{}
+	}
+	var tasks []model.Task
+	if err := tx.Unscoped().Select("id", "status").Where("id IN ?", deps).Find(&tasks).Error; err != nil {
+		return false, nil, err
+	}
+	for i := range tasks {
+		switch tasks[i].Status {
+		case model.StatusSuccess:
+		case model.StatusFailed, model.StatusCancelled, model.StatusDeadLettered:
+			return false, &tasks[i], nil
+		default:
+			blocked = true
+		}
+	}
+	// rows not found yet belong to the same submission and are still pending
+	return blocked || len(tasks) < len(unique), nil, nil
+}
+
+func dependencyFailedResult(dep *model.Task) string {
+	return fmt.Sprintf("dependency %s ended %s", dep.ID, dep.Status)
+}
+
+// resolveDependents unblocks or fails the tasks waiting on a task that just finished
+func (s *Scheduler) resolveDependents(t *txn, id string) error {
+	var dependents []model.Task
+	err := t.db.Where("status = ? AND blocked", model.StatusPending).
+		Where("depends_on LIKE ?", `%"`+id+`"%`).
+		Find(&dependents).Error
+	if err != nil {
+		return err
+	}
+	for i := range dependents {
+		if _, err := s.resolve(t, &dependents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve re-evaluates a single blocked task, failures cascade down the graph through transition
+func (s *Scheduler) resolve(t *txn, task *model.Task) (bool, error) {
+	blocked, failedDep, err := dependencyState(t.db, task.DependsOn)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	updates := map[string]any{"blocked": false}
+	if failedDep != nil {
+		updates["status"] = model.StatusFailed
+		updates["result"] = dependencyFailedResult(failedDep)
+		updates["finished_at"] = s.now()
+	}
+	return s.transition(t, task, updates)
+}
+
+// ResolveBlocked re-evaluates every blocked task
+func (s *Scheduler) ResolveBlocked(ctx context.Context) (int64, error) {
+	var blocked []model.Task
+	err := s.db.WithContext(ctx).Where("status = ? AND blocked", model.StatusPending).Find(&blocked).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var resolved int64
+	for i := range blocked {
+		err := s.inTx(ctx, func(t *txn) error {
+			ok, err := s.resolve(t, &blocked[i])
+			if ok {
+				resolved++
+			}
+			return err
+		})
+		if err != nil {
+			return resolved, err
+		}
+	}
+	return resolved, nil
+}
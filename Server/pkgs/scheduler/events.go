@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"Server/pkgs/logging"
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// Transition describes a task changing status, Task is the state after the change.
+// From is empty for newly submitted tasks.
+type Transition struct {
+	Task model.Task
+	From model.TaskStatus
+	To   model.TaskStatus
+	At   time.Time
+	// RequestID correlates the transition with the api request that caused it, empty for background jobs
+	RequestID string
+}
+
+// Listener is called after the transaction making a transition commits, it must not block
+type Listener func(Transition)
+
+// OnTransition registers l for every future transition, call it before the scheduler is used
+func (s *Scheduler) OnTransition(l Listener) {
+	s.listeners = append(s.listeners, l)
+}
+
+// txn is a transaction that remembers the transitions it made, they are published once it commits.
+// Audit events are collected along the way and written just before the commit.
+type txn struct {
+	db        *gorm.DB
+	actor     string
+	requestID string
+	now       time.Time
+	events    []Transition
+	audits    []model.AuditEvent
+	// replaces the default audit event of the next transition
+	override *model.AuditEvent
+	// replaces the outcome the next transition derives for the run it ends
+	outcome model.RunOutcome
+}
+
+func (s *Scheduler) inTx(ctx context.Context, fn func(t *txn) error) error {
+	// the listeners count too, so nothing is notified once Close returned
+	if err := s.writes.begin(); err != nil {
+		return err
+	}
+	defer s.writes.end()
+	t := &txn{actor: actorFrom(ctx), requestID: logging.RequestID(ctx), now: s.now()}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		t.db = tx
+		if err := fn(t); err != nil {
+			return err
+		}
+		if len(t.audits) == 0 {
+			return nil
+		}
+		return tx.Create(&t.audits).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range t.events {
+		slog.InfoContext(ctx, "task transition", append(logging.Task(&e.Task), "from", e.From, "actor", t.actor)...)
+		for _, l := range s.listeners {
+			l(e)
+		}
+	}
+	return nil
+}
+
+func (t *txn) record(task *model.Task, from model.TaskStatus, at time.Time) {
+	if task.Status != from {
+		t.events = append(t.events, Transition{Task: *task, From: from, To: task.Status, At: at, RequestID: t.requestID})
+		t.auditTransition(task, from)
+	}
+}
+
+// maxConflictRetries bounds how often an operation re-reads and re-decides after losing a race
+const maxConflictRetries = 3
+
+// retryOnConflict runs op again while it loses compare-and-swap races, op must re-read the task
+func retryOnConflict(op func() error) error {
+	for i := 0; ; i++ {
+		err := op()
+		if !errors.Is(err, ErrConflict) || i == maxConflictRetries {
+			return err
+		}
+	}
+}
+
+// load reads a task inside the transaction, mapping a missing row to ErrNotFound
+func (t *txn) load(id string) (*model.Task, error) {
+	var task model.Task
+	err := t.db.First(&task, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// transition applies updates to task as a compare-and-swap on the version of the snapshot,
+// so concurrent writers can never clobber each other. It returns false when another writer
+// got there first. On success task is refreshed, the transition recorded, and dependents
+// resolved if the task finished.
+func (s *Scheduler) transition(t *txn, task *model.Task, updates map[string]any) (bool, error) {
+	updates["version"] = gorm.Expr("version + 1")
+	res := t.db.Model(&model.Task{}).
+		Where("id = ? AND version = ?", task.ID, task.Version).
+		Updates(updates)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+
+	from, ended := task.Status, *task
+	// scan into a fresh value, NULL columns would otherwise leave stale fields behind
+	var fresh model.Task
+	if err := t.db.First(&fresh, "id = ?", task.ID).Error; err != nil {
+		return false, err
+	}
+	*task = fresh
+	t.record(task, from, s.now())
+	if from == model.StatusRunning && task.Status != model.StatusRunning {
+		if err := t.endRun(&ended, task); err != nil {
+			return false, err
+		}
+	}
+	if task.Status.Terminal() {
+		return true, s.resolveDependents(t, task.ID)
+	}
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"Server/pkgs/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrExperimentNotFound = errors.New("experiment not found")
+
+func (s *Scheduler) CreateExperiment(ctx context.Context, e *model.Experiment) error {
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+	e.CreatedAt = s.now()
+	err := s.write(func() error { return s.db.WithContext(ctx).Create(e).Error })
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return fmt.Errorf("%w: experiment %s already exists", ErrInvalidTask, e.ID)
+	}
+	if err != nil {
+		return err
+	}
+	e.Status, e.TaskCounts = model.ExperimentStatus(nil), map[model.TaskStatus]int64{}
+	return nil
+}
+
+// GetExperiment loads an experiment together with the aggregate status of its tasks,
+// archived tasks still count
+func (s *Scheduler) GetExperiment(ctx context.Context, id string) (*model.Experiment, error) {
+	db := s.db.WithContext(ctx)
+	var e model.Experiment
+	err := db.First(&e, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrExperimentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Status model.TaskStatus
+		Count  int64
+	}
+	err = db.Unscoped().Model(&model.Task{}).
+		Select("status, COUNT(*) AS count").
+		Where("experiment_id = ?", id).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	e.TaskCounts = make(map[model.TaskStatus]int64, len(rows))
+	for _, row := range rows {
+		e.TaskCounts[row.Status] = row.Count
+	}
+	e.Status = model.ExperimentStatus(e.TaskCounts)
+	return &e, nil
+}
+
+// checkExperiments makes sure the submitted tasks only reference existing experiments,
+// on failure it also returns the index of the offending task
+func checkExperiments(tx *gorm.DB, tasks []*model.Task) (int, error) {
+	known := make(map[string]bool)
+	for i, task := range tasks {
+		if task.ExperimentID == "" {
+			continue
+		}
+		exists, ok := known[task.ExperimentID]
+		if !ok {
+			var n int64
+			if err := tx.Model(&model.Experiment{}).Where("id = ?", task.ExperimentID).Count(&n).Error; err != nil {
+				return i, err
+			}
+			exists = n > 0
+			known[task.ExperimentID] = exists
+		}
+		if !exists {
+			return i, fmt.Errorf("%w: unknown experiment %s", ErrInvalidTask, task.ExperimentID)
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+// ExpirePending fails pending tasks that no worker claimed before ExpiresAt. Tasks that
+// were claimed once and went back to the queue are past the point of expiring.
+// Claim skips the same rows and both sides go through the version check, so a task
+// claimed right at its expiry is either running or failed, never both.
+func (s *Scheduler) ExpirePending(ctx context.Context) (int64, error) {
+	now := s.now()
+
+	var pending []model.Task
+	err := s.db.WithContext(ctx).
+		Where("status = ? AND attempts = 0 AND expires_at <= ?", model.StatusPending, now).
+		Find(&pending).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var expired int64
+	for i := range pending {
+		task := &pending[i]
+		err := s.inTx(ctx, func(t *txn) error {
+			ok, err := s.transition(t, task, map[string]any{
+				"status":      model.StatusFailed,
+				"result":      "expired: not claimed before " + task.ExpiresAt.UTC().Format(time.RFC3339),
+				"blocked":     false,
+				"finished_at": now,
+			})
+			if ok {
+				expired++
+			}
+			return err
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
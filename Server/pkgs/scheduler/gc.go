@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// statuses whose artifact files may be collected, like Archive dead-lettered tasks are
+// left alone since they may still be requeued
+var collectable = []model.TaskStatus{model.StatusSuccess, model.StatusFailed, model.StatusCancelled}
+
+// ArtifactCollector periodically deletes the artifact files of tasks that finished long
+// ago, the task records stay for history
+type ArtifactCollector struct {
+	sched     *Scheduler
+	interval  time.Duration
+	retention time.Duration
+	dryRun    bool
+}
+
+// NewArtifactCollector collects every interval, with dryRun it only logs what it would delete
+func NewArtifactCollector(sched *Scheduler, interval, retention time.Duration, dryRun bool) *ArtifactCollector {
+	return &ArtifactCollector{sched: sched, interval: interval, retention: retention, dryRun: dryRun}
+}
+
+// Run blocks until ctx is cancelled
+func (c *ArtifactCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Tick(ctx)
+		}
+	}
+}
+
+func (c *ArtifactCollector) Tick(ctx context.Context) {
+	gc, err := c.sched.CollectArtifacts(ctx, c.retention, c.dryRun)
+	if err != nil {
+		slog.ErrorContext(ctx, "artifact gc: failed to collect artifacts", "error", err)
+	}
+	if gc.Tasks > 0 || gc.Skipped > 0 {
+		slog.InfoContext(ctx, "artifact gc: collected artifacts", "tasks", gc.Tasks, "files", gc.Files, "bytes", gc.Bytes,
+			"skipped", gc.Skipped, "dry_run", c.dryRun)
+	}
+}
+
+// ArtifactGC sums up a collection run, in a dry run what would have been collected
+type ArtifactGC struct {
+	Tasks int64
+	Files int64
+	// stored bytes freed
+	Bytes int64
+	// tasks kept since an unfinished task may still need their artifacts
+	Skipped int64
+}
+
+// CollectArtifacts deletes the artifact files of tasks that finished more than olderThan
+// ago, archived ones included, through the artifact store. The task keeps its artifact
+// summary with ArtifactGCedAt set, its artifact records go. Tasks that still have a
+// pending or running dependent, or one whose payload references their artifact, are
+// skipped until those are done.
+func (s *Scheduler) CollectArtifacts(ctx context.Context, olderThan time.Duration, dryRun bool) (ArtifactGC, error) {
+	var gc ArtifactGC
+	if s.artifacts == nil {
+		return gc, fmt.Errorf("artifact uploads are not enabled")
+	}
+	cutoff := s.now().Add(-olderThan)
+	var ids []string
+	err := s.db.WithContext(ctx).Unscoped().Model(&model.Task{}).
+		Where("status IN ? AND finished_at < ? AND artifact_gced_at IS NULL", collectable, cutoff).
+		Where("artifact_path <> '' OR EXISTS (SELECT 1 FROM artifacts WHERE artifacts.task_id = tasks.id)").
+		Order("finished_at ASC").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return gc, err
+	}
+	for _, id := range ids {
+		if err := s.collectTask(ctx, id, cutoff, dryRun, &gc); err != nil {
+			return gc, fmt.Errorf("task %s: %w", id, err)
+		}
+	}
+	return gc, nil
+}
+
+// collectTask re-checks the task in the transaction that clears its artifact fields and
+// deletes the files only once it committed, so a dependent that shows up meanwhile either
+// sees the task collected or keeps its files. A file whose deletion fails is left behind
+// and logged, the artifact_gced_at marker keeps the task from being collected twice.
+func (s *Scheduler) collectTask(ctx context.Context, id string, cutoff time.Time, dryRun bool, gc *ArtifactGC) error {
+	var artifacts []model.Artifact
+	err := s.inTx(ctx, func(t *txn) error {
+		artifacts = nil
+		var task model.Task
+		err := t.db.Unscoped().Preload("Artifacts").First(&task, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(collectable, task.Status) || task.FinishedAt == nil || !task.FinishedAt.Before(cutoff) || task.ArtifactGCedAt != nil {
+			return nil
+		}
+		// a patch apply may take the artifact as a patch without depending on the task
+		var dependents int64
+		err = t.db.Model(&model.Task{}).
+			Where("status IN ?", []model.TaskStatus{model.StatusPending, model.StatusRunning}).
+			Where("depends_on LIKE ? OR payload LIKE ?", `%"`+id+`"%`, `%"artifact_id":"`+id+`"%`).
+			Count(&dependents).Error
+		if err != nil {
+			return err
+		}
+		if dependents > 0 {
+			gc.Skipped++
+			return nil
+		}
+
+		artifacts = task.Artifacts
+		// tasks finished before artifact records existed only have the task fields
+		if primary := task.PrimaryArtifact(); primary != nil && !slices.ContainsFunc(artifacts, func(a model.Artifact) bool { return a.Path == primary.Path }) {
+			artifacts = append(artifacts, *primary)
+		}
+		if dryRun {
+			return nil
+		}
+
+		res := t.db.Unscoped().Model(&model.Task{}).
+			Where("id = ? AND version = ?", id, task.Version).
+			Updates(map[string]any{"artifact_gced_at": t.now, "artifact_path": "", "version": gorm.Expr("version + 1")})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflict
+		}
+		return t.db.Where("task_id = ?", id).Delete(&model.Artifact{}).Error
+	})
+	if err != nil || artifacts == nil {
+		return err
+	}
+
+	var errs []error
+	for _, a := range artifacts {
+		if dryRun {
+			slog.InfoContext(ctx, "artifact gc: would delete artifact", "task_id", id, "name", a.Name, "key", a.Path, "stored_size", a.StoredSize)
+		} else {
+			err := s.artifacts.Delete(ctx, a.Path)
+			if err != nil && !errors.Is(err, artifact.ErrNotFound) {
+				slog.ErrorContext(ctx, "artifact gc: failed to delete artifact, the file is left behind", "task_id", id, "name", a.Name, "key", a.Path, "error", err)
+				errs = append(errs, fmt.Errorf("delete %s: %w", a.Path, err))
+				continue
+			}
+			slog.InfoContext(ctx, "artifact gc: deleted artifact", "task_id", id, "name", a.Name, "key", a.Path, "stored_size", a.StoredSize)
+		}
+		gc.Files++
+		gc.Bytes += a.StoredSize
+	}
+	gc.Tasks++
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// how long a claim holds a task unless WithLease says otherwise
+const defaultLease = time.Minute
+
+// WithLease sets how long a claimed task stays with its worker without a renewal
+func WithLease(d time.Duration) Option {
+	return func(s *Scheduler) {
+		if d > 0 {
+			s.lease = d
+		}
+	}
+}
+
+// Renew extends the lease of a running task by another lease period from now,
+// only the worker holding the task may renew it
+func (s *Scheduler) Renew(ctx context.Context, id, workerID string) (*model.Task, error) {
+	var task *model.Task
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			var err error
+			if task, err = t.load(id); err != nil {
+				return err
+			}
+			if task.Status != model.StatusRunning {
+				return ErrInvalidState
+			}
+			if task.WorkerID != workerID {
+				return ErrNotOwner
+			}
+
+			expires := t.now.Add(s.lease)
+			res := t.db.Model(&model.Task{}).
+				Where("id = ? AND version = ?", id, task.Version).
+				Updates(map[string]any{"lease_expires_at": expires, "version": gorm.Expr("version + 1")})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrConflict
+			}
+			task.LeaseExpiresAt = &expires
+			task.Version++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// bytes of output kept per task when no limit is configured
+	defaultLogLimit = 1 << 20
+	// MaxLogChunk bounds a single append, workers split longer output
+	MaxLogChunk = 64 << 10
+)
+
+// WithLogLimit caps the output kept per task, the oldest chunks are dropped past it
+func WithLogLimit(bytes int64) Option {
+	return func(s *Scheduler) {
+		if bytes > 0 {
+			s.logLimit = bytes
+		}
+	}
+}
+
+// AppendLog adds a chunk of output to a running task held by workerID. Chunks are
+// numbered in the order they commit, two appends racing for the same number make the
+// loser retry behind the winner, so chunks are never merged or reordered.
+func (s *Scheduler) AppendLog(ctx context.Context, id, workerID, data string) (*model.TaskLog, error) {
+	if data == "" {
+		return nil, fmt.Errorf("%w: log chunk is empty", ErrInvalidTask)
+	}
+	if maxChunk := min(MaxLogChunk, s.logLimit); int64(len(data)) > maxChunk {
+		return nil, fmt.Errorf("%w: log chunk exceeds %d bytes", ErrInvalidTask, maxChunk)
+	}
+	var chunk *model.TaskLog
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			task, err := t.load(id)
+			if err != nil {
+				return err
+			}
+			if task.Status != model.StatusRunning {
+				return ErrInvalidState
+			}
+			if task.WorkerID != workerID {
+				return ErrNotOwner
+			}
+
+			var last model.TaskLog
+			if err := t.db.Where("task_id = ?", id).Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+			chunk = &model.TaskLog{
+				TaskID:     id,
+				Seq:        last.Seq + 1,
+				ByteOffset: last.ByteOffset + last.Size,
+				Size:       int64(len(data)),
+				Attempt:    task.Attempts,
+				Data:       data,
+				CreatedAt:  t.now,
+			}
+			err = t.db.Create(chunk).Error
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrConflict
+			}
+			if err != nil {
+				return err
+			}
+			// whole chunks go, so what is left starts at a chunk boundary
+			keepFrom := chunk.ByteOffset + chunk.Size - s.logLimit
+			return t.db.Where("task_id = ? AND byte_offset < ?", id, keepFrom).Delete(&model.TaskLog{}).Error
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// Logs returns up to limit chunks of a task following afterSeq, oldest first, and how
+// many leading bytes of the output were dropped to stay within the cap
+func (s *Scheduler) Logs(ctx context.Context, id string, afterSeq int64, limit int) ([]model.TaskLog, int64, error) {
+	db := s.db.WithContext(ctx)
+	chunks := []model.TaskLog{}
+	err := db.Where("task_id = ? AND seq > ?", id, afterSeq).Order("seq ASC").Limit(limit).Find(&chunks).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var truncated int64
+	err = db.Model(&model.TaskLog{}).Select("COALESCE(MIN(byte_offset), 0)").Where("task_id = ?", id).Scan(&truncated).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return chunks, truncated, nil
+}
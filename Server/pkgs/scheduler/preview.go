@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"Server/pkgs/model"
+)
+
+// ProspectiveTask is a task that may be submitted, described by what dispatch looks at.
+// Fields left unset take the defaults of the task type like on submission.
+type ProspectiveTask struct {
+	Type                 model.TaskType `json:"type"`
+	Priority             *int           `json:"priority,omitempty"`
+	RequiredCapabilities []string       `json:"required_capabilities"`
+}
+
+// why a previewed task would not be claimed right away
+const (
+	// no available worker has the required capabilities
+	WaitNoCapableWorker = "no_capable_worker"
+	// the task type runs at its concurrency limit
+	WaitConcurrencyLimit = "concurrency_limit"
+	// every capable worker is busy with other tasks
+	WaitWorkersBusy = "workers_busy"
+)
+
+// Placement is where a prospective task would land if it were submitted now
+type Placement struct {
+	// position of the task in the request
+	Index int `json:"index"`
+	// 1-based position among the dispatchable pending tasks in claim order
+	QueuePosition int `json:"queue_position"`
+	// some available worker has the required capabilities
+	Satisfiable bool `json:"satisfiable"`
+	// worker that would claim the task right away, empty when it waits
+	WorkerID string `json:"worker_id"`
+	// one of the Wait reasons when the task waits
+	WaitReason string `json:"wait_reason"`
+}
+
+// DispatchPreview is the outcome of PreviewDispatch
+type DispatchPreview struct {
+	AvailableWorkers int         `json:"available_workers"`
+	IdleWorkers      int         `json:"idle_workers"`
+	Placements       []Placement `json:"placements"`
+}
+
+// PreviewDispatch works out where the tasks would go if they were submitted now, without
+// changing anything. The prospective tasks queue behind the pending ones of the same
+// priority, then every idle available worker claims in order of its id with the rules
+// Claim applies: capabilities, excluded workers, priority and concurrency limits.
+func (s *Scheduler) PreviewDispatch(ctx context.Context, tasks []ProspectiveTask) (*DispatchPreview, error) {
+	for i, task := range tasks {
+		if !task.Type.Valid() {
+			return nil, fmt.Errorf("%w: task %d: unknown task type %q", ErrInvalidTask, i, task.Type)
+		}
+	}
+	db := s.db.WithContext(ctx)
+	now := s.now()
+
+	var workers []model.Worker
+	err := db.Where("NOT draining AND last_seen_at >= ?", now.Add(-workerSeenWindow)).Order("id").Find(&workers).Error
+	if err != nil {
+		return nil, err
+	}
+	busy, err := s.RunningByWorker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	running, err := countRunningByType(db)
+	if err != nil {
+		return nil, err
+	}
+	var queue []model.Task
+	err = dispatchable(db, now).
+		Select("id, type, priority, required_capabilities, excluded_workers").
+		Order("priority DESC, created_at ASC").
+		Find(&queue).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// prospective tasks carry no id, the index tells them apart from the queued ones
+	type entry struct {
+		task  model.Task
+		index int
+	}
+	entries := make([]entry, 0, len(queue)+len(tasks))
+	for _, task := range queue {
+		entries = append(entries, entry{task: task, index: -1})
+	}
+	for i, task := range tasks {
+		prospect := model.Task{Type: task.Type, Priority: s.TaskType(task.Type).Priority, RequiredCapabilities: task.RequiredCapabilities}
+		if task.Priority != nil {
+			prospect.Priority = *task.Priority
+		}
+		s.applyTaskType(&prospect)
+		entries = append(entries, entry{task: prospect, index: i})
+	}
+	// stable, so ties keep the queued tasks first and the request order after them
+	slices.SortStableFunc(entries, func(a, b entry) int { return b.task.Priority - a.task.Priority })
+
+	preview := &DispatchPreview{AvailableWorkers: len(workers), Placements: make([]Placement, len(tasks))}
+	for pos, e := range entries {
+		if e.index < 0 {
+			continue
+		}
+		p := &preview.Placements[e.index]
+		p.Index, p.QueuePosition = e.index, pos+1
+		p.WaitReason = WaitNoCapableWorker
+		for i := range workers {
+			if canClaim(&workers[i], &e.task) {
+				p.Satisfiable, p.WaitReason = true, WaitWorkersBusy
+				break
+			}
+		}
+	}
+
+	assigned := make([]bool, len(entries))
+	for i := range workers {
+		worker := &workers[i]
+		if busy[worker.ID] > 0 {
+			continue
+		}
+		preview.IdleWorkers++
+		for pos := range entries {
+			e := &entries[pos]
+			if assigned[pos] || !canClaim(worker, &e.task) || s.saturated(e.task.Type, running) {
+				continue
+			}
+			assigned[pos] = true
+			running[e.task.Type]++
+			if e.index >= 0 {
+				p := &preview.Placements[e.index]
+				p.WorkerID, p.WaitReason = worker.ID, ""
+			}
+			break
+		}
+	}
+	for i := range preview.Placements {
+		p := &preview.Placements[i]
+		if p.WaitReason == WaitWorkersBusy && s.saturated(tasks[i].Type, running) {
+			p.WaitReason = WaitConcurrencyLimit
+		}
+	}
+	return preview, nil
+}
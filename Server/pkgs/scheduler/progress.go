@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// UpdateProgress records how far the worker got with a running task, progress
+// is a percentage and must never go backwards within an attempt
+func (s *Scheduler) UpdateProgress(ctx context.Context, id, workerID string, progress int) (*model.Task, error) {
+	if progress < 0 || progress > 100 {
+		return nil, fmt.Errorf("%w: progress must be between 0 and 100", ErrInvalidTask)
+	}
+	var task *model.Task
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			var err error
+			if task, err = t.load(id); err != nil {
+				return err
+			}
+			if task.Status != model.StatusRunning {
+				return ErrInvalidState
+			}
+			if task.WorkerID != workerID {
+				return ErrNotOwner
+			}
+			if progress < task.Progress {
+				return fmt.Errorf("%w: progress must not decrease from %d to %d", ErrInvalidTask, task.Progress, progress)
+			}
+
+			// progress is no status change, so it is not published as a transition
+			res := t.db.Model(&model.Task{}).
+				Where("id = ? AND version = ?", id, task.Version).
+				Updates(map[string]any{"progress": progress, "version": gorm.Expr("version + 1")})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrConflict
+			}
+			task.Progress = progress
+			task.Version++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
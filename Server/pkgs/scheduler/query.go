@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+
+	"Server/pkgs/database"
+	"Server/pkgs/model"
+)
+
+// TaskFilter narrows a task listing, zero values match everything
+type TaskFilter struct {
+	Status   model.TaskStatus
+	Type     model.TaskType
+	WorkerID string
+	// ExperimentID limits the listing to the tasks of one experiment
+	ExperimentID string
+	// Search is a case-insensitive substring of the result, artifact name or crash signature
+	Search string
+	Limit  int
+	Offset int
+	// archived tasks are hidden unless asked for
+	IncludeArchived bool
+}
+
+func (s *Scheduler) List(ctx context.Context, f TaskFilter) ([]model.Task, error) {
+	q := s.db.WithContext(ctx).Model(&model.Task{})
+	if f.IncludeArchived {
+		q = q.Unscoped()
+	}
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.Type != "" {
+		q = q.Where("type = ?", f.Type)
+	}
+	if f.WorkerID != "" {
+		q = q.Where("worker_id = ?", f.WorkerID)
+	}
+	if f.ExperimentID != "" {
+		q = q.Where("experiment_id = ?", f.ExperimentID)
+	}
+	if f.Search != "" {
+		q = q.Where(database.TaskSearchText(s.db)+` LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(f.Search))+"%")
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	if f.Offset > 0 {
+		q = q.Offset(f.Offset)
+	}
+
+	tasks := []model.Task{}
+	if err := q.Order("created_at DESC").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes s match literally inside a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// CountByStatus returns how many tasks there are of each type and status
+func (s *Scheduler) CountByStatus(ctx context.Context) (map[model.TaskType]map[model.TaskStatus]int64, error) {
+	var rows []struct {
+		Type   model.TaskType
+		Status model.TaskStatus
+		Count  int64
+	}
+	err := s.db.WithContext(ctx).Model(&model.Task{}).
+		Select("type, status, COUNT(*) AS count").
+		Group("type, status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[model.TaskType]map[model.TaskStatus]int64)
+	for _, row := range rows {
+		if counts[row.Type] == nil {
+			counts[row.Type] = make(map[model.TaskStatus]int64)
+		}
+		counts[row.Type][row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// Ping checks that the database answers
+func (s *Scheduler) Ping(ctx context.Context) error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
+func (s *Scheduler) CountPending(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.WithContext(ctx).Model(&model.Task{}).Where("status = ?", model.StatusPending).Count(&n).Error
+	return n, err
+}
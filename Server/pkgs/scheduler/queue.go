@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+const (
+	// polling dashboards share one computation for this long
+	queueStatsTTL = 5 * time.Second
+	// how many recently finished tasks of a type the average duration is taken over
+	durationSamples = 50
+	// workers seen within this window count as available
+	workerSeenWindow = 5 * time.Minute
+)
+
+// QueueStats is a rough picture of how long a task of a type waits before it runs
+type QueueStats struct {
+	Type             model.TaskType `json:"type"`
+	Pending          int64          `json:"pending"`
+	Running          int64          `json:"running"`
+	AvailableWorkers int64          `json:"available_workers"`
+	// tasks of the type that run side by side, the available workers capped by the concurrency limit
+	Parallelism        int64   `json:"parallelism"`
+	AvgDurationSeconds float64 `json:"avg_duration_seconds"`
+	DurationSamples    int     `json:"duration_samples"`
+	// time until the pending tasks are through at the recent pace, nil without workers or history
+	EstimatedWaitSeconds *float64 `json:"estimated_wait_seconds"`
+}
+
+type queueStatsCache struct {
+	mu    sync.Mutex
+	at    time.Time
+	stats []QueueStats
+}
+
+// QueueStats reports queue depth and estimated wait per task type. The result is cached
+// for a few seconds, so it may lag behind the queue slightly.
+func (s *Scheduler) QueueStats(ctx context.Context) ([]QueueStats, error) {
+	c := &s.queueStats
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := s.now()
+	if c.stats != nil && now.Sub(c.at) < queueStatsTTL {
+		return slices.Clone(c.stats), nil
+	}
+	stats, err := s.computeQueueStats(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	c.stats, c.at = stats, now
+	return slices.Clone(stats), nil
+}
+
+func (s *Scheduler) computeQueueStats(ctx context.Context, now time.Time) ([]QueueStats, error) {
+	db := s.db.WithContext(ctx)
+	var counts []struct {
+		Type   model.TaskType
+		Status model.TaskStatus
+		Count  int64
+	}
+	err := db.Model(&model.Task{}).
+		Select("type, status, COUNT(*) AS count").
+		Where("status IN ?", []model.TaskStatus{model.StatusPending, model.StatusRunning}).
+		Group("type, status").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var workers int64
+	err = db.Model(&model.Worker{}).Where("NOT draining AND last_seen_at >= ?", now.Add(-workerSeenWindow)).Count(&workers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]QueueStats, len(model.TaskTypes))
+	for i, taskType := range model.TaskTypes {
+		st := &stats[i]
+		st.Type = taskType
+		for _, c := range counts {
+			if c.Type != taskType {
+				continue
+			}
+			switch c.Status {
+			case model.StatusPending:
+				st.Pending = c.Count
+			case model.StatusRunning:
+				st.Running = c.Count
+			}
+		}
+		st.AvailableWorkers = workers
+		st.Parallelism = workers
+		if limit := int64(s.limits[taskType]); limit > 0 && limit < workers {
+			st.Parallelism = limit
+		}
+
+		var recent []model.Task
+		err := db.Select("started_at, finished_at").
+			Where("type = ? AND status IN ? AND started_at IS NOT NULL AND finished_at IS NOT NULL",
+				taskType, []model.TaskStatus{model.StatusSuccess, model.StatusFailed}).
+			Order("finished_at DESC").
+			Limit(durationSamples).
+			Find(&recent).Error
+		if err != nil {
+			return nil, err
+		}
+		var total time.Duration
+		for _, task := range recent {
+			total += task.FinishedAt.Sub(*task.StartedAt)
+		}
+		st.DurationSamples = len(recent)
+		if len(recent) > 0 {
+			st.AvgDurationSeconds = total.Seconds() / float64(len(recent))
+		}
+		st.EstimatedWaitSeconds = estimateWait(st.Pending, st.Parallelism, st.AvgDurationSeconds, st.DurationSamples)
+	}
+	return stats, nil
+}
+
+// estimateWait drains the pending tasks at parallelism tasks per average duration.
+// It ignores what is already running and how long it has left, hence naive.
+func estimateWait(pending, parallelism int64, avgSeconds float64, samples int) *float64 {
+	if pending == 0 {
+		wait := 0.0
+		return &wait
+	}
+	if parallelism == 0 || samples == 0 {
+		return nil
+	}
+	wait := float64(pending) * avgSeconds / float64(parallelism)
+	return &wait
+}
@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"Server/pkgs/model"
+)
+
+const leaseExpiredResult = "lease expired"
+
+// Reaper periodically hands tasks whose lease ran out back to the queue
+type Reaper struct {
+	sched    *Scheduler
+	interval time.Duration
+	// unix nanoseconds of the last loop iteration, zero while Run is not running
+	lastRun atomic.Int64
+}
+
+func NewReaper(sched *Scheduler, interval time.Duration) *Reaper {
+	return &Reaper{sched: sched, interval: interval}
+}
+
+// Run blocks until ctx is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	defer r.lastRun.Store(0)
+	r.lastRun.Store(time.Now().UnixNano())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Tick(ctx)
+			r.lastRun.Store(time.Now().UnixNano())
+		}
+	}
+}
+
+// Check fails when the reaper loop is not running or got stuck, it fits api.WithReadyCheck
+func (r *Reaper) Check(context.Context) error {
+	last := r.lastRun.Load()
+	if last == 0 {
+		return fmt.Errorf("reaper is not running")
+	}
+	if since := time.Since(time.Unix(0, last)); since > 3*r.interval {
+		return fmt.Errorf("reaper last ran %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+// Tick runs every step even when an earlier one failed, a bad task must not hold up the others
+func (r *Reaper) Tick(ctx context.Context) {
+	n, err := r.sched.ReclaimExpired(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "reaper: failed to reclaim expired tasks", "error", err)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "reaper: reclaimed tasks with an expired lease", "count", n)
+	}
+
+	n, err = r.sched.FailTimedOut(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "reaper: failed to time out tasks", "error", err)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "reaper: timed out tasks", "count", n)
+	}
+
+	n, err = r.sched.ExpirePending(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "reaper: failed to expire pending tasks", "error", err)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "reaper: expired unclaimed tasks", "count", n)
+	}
+
+	// backstop in case a dependent was missed when its dependency finished
+	n, err = r.sched.ResolveBlocked(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "reaper: failed to resolve blocked tasks", "error", err)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "reaper: resolved blocked tasks", "count", n)
+	}
+}
+
+// ReclaimExpired fails the attempt of running tasks whose lease ran out without a renewal,
+// they go back to the queue while attempts remain and to the dead-letter queue after.
+// Only running rows are touched, so repeated calls never reset a task twice. Tasks that
+// were asked to cancel are finished as cancelled instead. A task
+// that fails to reclaim does not stop the others, the errors are returned together.
+func (s *Scheduler) ReclaimExpired(ctx context.Context) (int64, error) {
+	now := s.now()
+	var stale []model.Task
+	err := s.db.WithContext(ctx).
+		Where("status = ?", model.StatusRunning).
+		// tasks claimed before leases existed are treated as leased from StartedAt
+		Where("lease_expires_at < ? OR (lease_expires_at IS NULL AND started_at < ?)", now, now.Add(-s.lease)).
+		Find(&stale).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64
+	var errs []error
+	for i := range stale {
+		task := &stale[i]
+		// counts as a failed attempt, a task that keeps crashing its worker ends dead-lettered
+		updates := map[string]any{"result": leaseExpiredResult}
+		s.failAttempt(updates, task, leaseExpiredResult, now)
+		if task.CancelRequested {
+			updates = map[string]any{
+				"status":      model.StatusCancelled,
+				"finished_at": now,
+			}
+		}
+		err := s.inTx(ctx, func(t *txn) error {
+			if !task.CancelRequested {
+				t.endRunAs(model.RunReclaimed)
+			}
+			ok, err := s.transition(t, task, updates)
+			if ok {
+				reclaimed++
+			}
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", task.ID, err))
+		}
+	}
+	return reclaimed, errors.Join(errs...)
+}
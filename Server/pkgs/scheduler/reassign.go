@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+
+	"Server/pkgs/model"
+)
+
+// Reassign takes a running task away from its worker and puts it back in the queue,
+// unlike a cancel the task runs again. With exclude the previous worker is never handed
+// the task again, for workers that are alive but wedged on it.
+func (s *Scheduler) Reassign(ctx context.Context, id string, exclude bool, reason string) (*model.Task, error) {
+	var task *model.Task
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			var err error
+			if task, err = t.load(id); err != nil {
+				return err
+			}
+			// a task that is being cancelled must not come back
+			if task.Status != model.StatusRunning || task.CancelRequested {
+				return ErrInvalidState
+			}
+
+			previous := task.WorkerID
+			updates := map[string]any{
+				"status":           model.StatusPending,
+				"worker_id":        "",
+				"started_at":       nil,
+				"lease_expires_at": nil,
+				"progress":         0,
+			}
+			if exclude && !slices.Contains(task.ExcludedWorkers, previous) {
+				updates["excluded_workers"] = append(slices.Clone(task.ExcludedWorkers), previous)
+			}
+			t.endRunAs(model.RunReassigned)
+			t.auditAs(model.AuditReassigned, model.AuditDetails{"from_worker": previous, "excluded": exclude, "reason": reason})
+			ok, err := s.transition(t, task, updates)
+			if err == nil && !ok {
+				err = ErrConflict
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"Server/pkgs/model"
+)
+
+// checkReplay decides a report for a task that is no longer running. A worker whose
+// report timed out retries it, the retry must see its change went through rather than
+// an error, so the same report against the state it produced is answered as success.
+// Anything else is a conflict with whatever finished the task.
+func checkReplay(task *model.Task, r Report) error {
+	if task.WorkerID != r.WorkerID || !reportProduced(task, r) {
+		return fmt.Errorf("%w: task is %s", ErrInvalidState, task.Status)
+	}
+	return nil
+}
+
+// reportProduced reports whether task is in the state r leaves a running task in
+func reportProduced(task *model.Task, r Report) bool {
+	switch task.Status {
+	case model.StatusCancelled:
+		// a cancel request overrides whatever the worker reports
+		return task.CancelRequested
+	case model.StatusSuccess:
+		return r.Status == model.StatusSuccess && task.Result == r.Result && sameResultData(task.ResultData, r.ResultData) &&
+			task.ArtifactName == r.ArtifactName && task.ArtifactSize == r.ArtifactSize && task.ArtifactSHA256 == r.ArtifactSHA256
+	case model.StatusDeadLettered:
+		return r.Status == model.StatusFailed && task.Result == r.Result
+	}
+	// a failure with retries left put the task back into the queue, a retry of that report
+	// cannot be told from a worker reporting a task it lost
+	return false
+}
+
+func sameResultData(a, b *model.TaskResult) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
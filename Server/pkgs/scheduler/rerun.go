@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"context"
+
+	"Server/pkgs/model"
+)
+
+// Rerun submits a fresh copy of a finished task. Only what describes the work is copied,
+// results, artifacts, timestamps, dependencies and the idempotency key stay behind.
+func (s *Scheduler) Rerun(ctx context.Context, id string) (*model.Task, error) {
+	source, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !source.Status.Terminal() {
+		return nil, ErrInvalidState
+	}
+	task := &model.Task{
+		Type:                 source.Type,
+		Payload:              source.Payload,
+		Priority:             source.Priority,
+		MaxAttempts:          source.MaxAttempts,
+		TimeoutSeconds:       source.TimeoutSeconds,
+		PendingTTLSeconds:    source.PendingTTLSeconds,
+		RequiredCapabilities: source.RequiredCapabilities,
+		Env:                  source.Env,
+		ExperimentID:         source.ExperimentID,
+		RerunOf:              source.ID,
+	}
+	if err := s.Submit(ctx, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
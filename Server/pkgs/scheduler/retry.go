@@ -0,0 +1,41 @@
+package scheduler
+
+import (
+	"math"
+	"time"
+
+	"Server/pkgs/config"
+)
+
+// RetryPolicy decides how often and how soon a failed task is re-enqueued
+type RetryPolicy struct {
+	MaxAttempts   int
+	BaseDelay     time.Duration
+	BackoffFactor float64
+	MaxDelay      time.Duration
+}
+
+func NewRetryPolicy(cfg config.RetryConfig) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   cfg.MaxAttempts,
+		BaseDelay:     cfg.BaseDelayDuration(),
+		BackoffFactor: cfg.BackoffFactor,
+		MaxDelay:      cfg.MaxDelayDuration(),
+	}
+}
+
+// Delay returns the wait before the next try after the given (1-based) attempt failed
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	factor := p.BackoffFactor
+	if factor < 1 {
+		factor = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(factor, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,542 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound     = errors.New("task not found")
+	ErrInvalidState = errors.New("task is not in a valid state for this operation")
+	ErrNotOwner     = errors.New("task is not owned by this worker")
+	ErrInvalidTask  = errors.New("invalid task")
+	// ErrConflict means another writer updated the task first
+	ErrConflict = errors.New("task was modified concurrently")
+	// ErrWorkerNotFound is returned for workers that never sent a heartbeat
+	ErrWorkerNotFound = errors.New("worker not found")
+	// ErrAlreadySubmitted is returned together with the existing task when an idempotency key is reused
+	ErrAlreadySubmitted = errors.New("task already submitted")
+	// ErrInvalidToken is returned for worker tokens that were never issued or got revoked
+	ErrInvalidToken = errors.New("invalid worker token")
+)
+
+// claimBatch is how many candidates a claim loads from the queue at a time
+const claimBatch = 8
+
+type Scheduler struct {
+	db        *gorm.DB
+	retry     RetryPolicy
+	artifacts artifact.Store
+	listeners []Listener
+	limits    map[model.TaskType]int
+	taskTypes map[model.TaskType]TaskTypeConfig
+	lease     time.Duration
+	logLimit  int64
+	// rolling window of the worker stats
+	statsWindow time.Duration
+	now         func() time.Time
+
+	queueStats queueStatsCache
+	writes     writes
+}
+
+type Option func(*Scheduler)
+
+// WithClock replaces the wall clock, mostly useful for tests
+func WithClock(now func() time.Time) Option {
+	return func(s *Scheduler) {
+		s.now = now
+	}
+}
+
+// WithArtifactStore enables verification of uploaded artifacts on success reports
+func WithArtifactStore(store artifact.Store) Option {
+	return func(s *Scheduler) {
+		s.artifacts = store
+	}
+}
+
+func New(db *gorm.DB, retry RetryPolicy, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		db:          db,
+		retry:       retry,
+		lease:       defaultLease,
+		logLimit:    defaultLogLimit,
+		statsWindow: defaultStatsWindow,
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+	s.taskTypes = make(map[model.TaskType]TaskTypeConfig, len(builtinTaskTypes))
+	for _, c := range builtinTaskTypes {
+		s.taskTypes[c.Type] = c
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Report is what a worker sends back once it is done with a task, the artifact
+// itself has to be uploaded beforehand
+type Report struct {
+	WorkerID       string
+	Status         model.TaskStatus
+	Result         string
+	ResultData     *model.TaskResult
+	ArtifactName   string
+	ArtifactSize   int64
+	ArtifactSHA256 string
+}
+
+// Submit inserts a new pending task. If the task carries an idempotency key that was
+// already used for its type, task is overwritten with the earlier one and
+// ErrAlreadySubmitted is returned instead.
+func (s *Scheduler) Submit(ctx context.Context, task *model.Task) error {
+	s.prepare(task)
+	err := s.inTx(ctx, func(t *txn) error {
+		if existing, err := findByIdempotencyKey(t.db, task); err != nil || existing != nil {
+			if existing != nil {
+				*task = *existing
+				return ErrAlreadySubmitted
+			}
+			return err
+		}
+		if _, err := checkExperiments(t.db, []*model.Task{task}); err != nil {
+			return err
+		}
+		if _, err := checkDependencies(t.db, []*model.Task{task}); err != nil {
+			return err
+		}
+		if err := s.applyDependencies(t.db, task); err != nil {
+			return err
+		}
+		return insertTask(t, task)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) && task.IdempotencyKey != nil {
+		// lost the race against a concurrent request with the same key, hand back the winner
+		existing, lookupErr := findByIdempotencyKey(s.db.WithContext(ctx), task)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if existing != nil {
+			*task = *existing
+			return ErrAlreadySubmitted
+		}
+	}
+	return err
+}
+
+func findByIdempotencyKey(db *gorm.DB, task *model.Task) (*model.Task, error) {
+	if task.IdempotencyKey == nil {
+		return nil, nil
+	}
+	var existing model.Task
+	err := db.Unscoped().Where("type = ? AND idempotency_key = ?", task.Type, *task.IdempotencyKey).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// BatchError points at the task of a batch that made the whole submission fail
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("task %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// SubmitBatch inserts all tasks in one transaction, either every task is created or none.
+// Tasks may depend on tasks earlier or later in the same batch. Like Submit, a task whose
+// idempotency key was already used for its type is overwritten with the earlier one, so a
+// retried batch hands back the tasks it created the first time.
+func (s *Scheduler) SubmitBatch(ctx context.Context, tasks []*model.Task) error {
+	for _, task := range tasks {
+		s.prepare(task)
+	}
+	err := s.submitBatch(ctx, tasks)
+	if errors.Is(err, gorm.ErrDuplicatedKey) && slices.ContainsFunc(tasks, func(t *model.Task) bool { return t.IdempotencyKey != nil }) {
+		// lost the race against a concurrent batch with the same keys, this time they are found
+		err = s.submitBatch(ctx, tasks)
+	}
+	return err
+}
+
+func (s *Scheduler) submitBatch(ctx context.Context, tasks []*model.Task) error {
+	return s.inTx(ctx, func(t *txn) error {
+		existing := make([]bool, len(tasks))
+		keys := make(map[string]int)
+		for i, task := range tasks {
+			if task.IdempotencyKey == nil {
+				continue
+			}
+			key := string(task.Type) + "/" + *task.IdempotencyKey
+			if first, ok := keys[key]; ok {
+				return &BatchError{Index: i, Err: fmt.Errorf("%w: idempotency key %q is already used by task %d of the batch", ErrInvalidTask, *task.IdempotencyKey, first)}
+			}
+			keys[key] = i
+			found, err := findByIdempotencyKey(t.db, task)
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+			if found != nil {
+				*task = *found
+				existing[i] = true
+			}
+		}
+		if i, err := checkExperiments(t.db, tasks); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+		if i, err := checkDependencies(t.db, tasks); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+		for i, task := range tasks {
+			if existing[i] {
+				continue
+			}
+			if err := s.applyDependencies(t.db, task); err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+			if err := insertTask(t, task); err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+// prepare fills in the server side fields of a task about to be submitted
+func (s *Scheduler) prepare(task *model.Task) {
+	if task.ID == "" {
+		task.ID = uuid.NewString()
+	}
+	task.Status = model.StatusPending
+	task.Attempts = 0
+	s.applyTaskType(task)
+	task.CreatedAt = s.now()
+	if task.ExpiresAt == nil && task.PendingTTLSeconds > 0 {
+		// the ttl starts once the task may be dispatched at all
+		from := task.CreatedAt
+		if task.NotBefore != nil && task.NotBefore.After(from) {
+			from = *task.NotBefore
+		}
+		expires := from.Add(time.Duration(task.PendingTTLSeconds) * time.Second)
+		task.ExpiresAt = &expires
+	}
+}
+
+func insertTask(t *txn, task *model.Task) error {
+	err := t.db.Create(task).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return fmt.Errorf("%w: task %s already exists: %w", ErrInvalidTask, task.ID, err)
+	}
+	if err != nil {
+		return err
+	}
+	t.record(task, "", task.CreatedAt)
+	return nil
+}
+
+// Get loads a task by id together with its artifacts, archived tasks included
+func (s *Scheduler) Get(ctx context.Context, id string) (*model.Task, error) {
+	var task model.Task
+	err := s.db.WithContext(ctx).Unscoped().
+		Preload("Artifacts", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		First(&task, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Claim hands the most urgent, then oldest, dispatchable task the worker is capable of,
+// it returns nil when there is none or the worker is draining
+func (s *Scheduler) Claim(ctx context.Context, workerID string) (*model.Task, error) {
+	db := s.db.WithContext(ctx)
+	now := s.now()
+
+	worker, err := s.getWorker(ctx, workerID)
+	if err != nil {
+		return nil, err
+	}
+	if worker.Draining {
+		return nil, nil
+	}
+
+	full, err := s.saturatedTypes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for offset := 0; ; offset += claimBatch {
+		var candidates []model.Task
+		q := dispatchable(db, now)
+		if len(full) > 0 {
+			q = q.Where("type NOT IN ?", full)
+		}
+		err := q.Order("priority DESC, created_at ASC").
+			Limit(claimBatch).
+			Offset(offset).
+			Find(&candidates).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range candidates {
+			task := &candidates[i]
+			if !canClaim(worker, task) || slices.Contains(full, task.Type) {
+				continue
+			}
+			// conditional update so two workers never grab the same task
+			var claimed, saturated bool
+			err := s.inTx(ctx, func(t *txn) error {
+				free, err := s.acquireSlot(t, task.Type)
+				if err != nil || !free {
+					saturated = !free
+					return err
+				}
+				claimed, err = s.transition(t, task, map[string]any{
+					"status":           model.StatusRunning,
+					"worker_id":        workerID,
+					"started_at":       now,
+					"lease_expires_at": now.Add(s.lease),
+					"next_retry_at":    nil,
+					"progress":         0,
+					"attempts":         gorm.Expr("attempts + 1"),
+				})
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			if saturated {
+				// filled up since the page was loaded, later pages must not count on it either
+				full = append(full, task.Type)
+			}
+			if claimed {
+				return task, nil
+			}
+		}
+		if len(candidates) < claimBatch {
+			return nil, nil
+		}
+	}
+}
+
+// dispatchable narrows db to the pending tasks a worker may be handed at now
+func dispatchable(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("status = ? AND NOT blocked", model.StatusPending).
+		Where("next_retry_at IS NULL OR next_retry_at <= ?", now).
+		Where("not_before IS NULL OR not_before <= ?", now).
+		Where("expires_at IS NULL OR expires_at > ? OR attempts > 0", now)
+}
+
+// canClaim reports whether the worker may run the task, concurrency limits aside
+func canClaim(worker *model.Worker, task *model.Task) bool {
+	return worker.CanRun(task) && !slices.Contains(task.ExcludedWorkers, worker.ID)
+}
+
+// Report records the outcome of a running task, failed tasks are re-enqueued while attempts remain
+// and dead-lettered afterwards. Repeating a report that already finished the task returns
+// the task unchanged.
+func (s *Scheduler) Report(ctx context.Context, id string, r Report) (*model.Task, error) {
+	switch r.Status {
+	case model.StatusSuccess, model.StatusFailed, model.StatusCancelled:
+	default:
+		return nil, fmt.Errorf("%w: cannot report status %q", ErrInvalidState, r.Status)
+	}
+
+	// a retried report needs no second pass over the artifact
+	var current model.Task
+	if err := s.db.WithContext(ctx).First(&current, "id = ?", id).Error; err == nil && current.Status.Terminal() {
+		if err := checkReplay(&current, r); err != nil {
+			return nil, err
+		}
+		return &current, nil
+	}
+
+	// hashing a multi-gigabyte vmcore must not hold the transaction open
+	var mismatch string
+	var stored storedArtifact
+	if r.Status == model.StatusSuccess {
+		var err error
+		if stored, mismatch, err = s.verifyArtifact(ctx, id, r); err != nil {
+			return nil, err
+		}
+	}
+
+	var task *model.Task
+	err := retryOnConflict(func() error {
+		return s.inTx(ctx, func(t *txn) error {
+			var err error
+			if task, err = t.load(id); err != nil {
+				return err
+			}
+			if task.Status.Terminal() {
+				// finished since the look above, possibly by this very report sent twice at once
+				return checkReplay(task, r)
+			}
+			if task.Status != model.StatusRunning {
+				return ErrInvalidState
+			}
+			if task.WorkerID != r.WorkerID {
+				return ErrNotOwner
+			}
+			if r.Status == model.StatusCancelled && !task.CancelRequested {
+				return fmt.Errorf("%w: task was not cancelled", ErrInvalidState)
+			}
+			if r.ArtifactName != "" && task.Payload.DryRun() {
+				return fmt.Errorf("%w: dry-run tasks must not produce an artifact", ErrInvalidTask)
+			}
+			if r.ResultData != nil {
+				if err := r.ResultData.Validate(task.Type); err != nil {
+					return fmt.Errorf("%w: %w", ErrInvalidTask, err)
+				}
+			}
+
+			now := s.now()
+			updates := map[string]any{"result": r.Result, "result_data": r.ResultData}
+			switch {
+			case task.CancelRequested:
+				// whatever the worker says, a cancelled task ends cancelled and keeps no artifact
+				updates["status"] = model.StatusCancelled
+				updates["finished_at"] = now
+			case r.Status == model.StatusSuccess:
+				if mismatch != "" {
+					updates["status"] = model.StatusFailed
+					updates["result"] = mismatch
+					updates["finished_at"] = now
+					break
+				}
+				updates["status"] = model.StatusSuccess
+				updates["progress"] = 100
+				if r.ArtifactName != "" {
+					updates["artifact_path"] = stored.key
+					updates["artifact_name"] = r.ArtifactName
+					updates["artifact_size"] = r.ArtifactSize
+					updates["artifact_stored_size"] = stored.size
+					updates["artifact_compression"] = stored.encoding
+					updates["artifact_sha256"] = r.ArtifactSHA256
+				}
+				updates["finished_at"] = now
+			default:
+				s.failAttempt(updates, task, r.Result, now)
+			}
+
+			ok, err := s.transition(t, task, updates)
+			if err == nil && !ok {
+				err = ErrConflict
+			}
+			if err == nil && task.Status == model.StatusSuccess && r.ArtifactName != "" {
+				err = setPrimaryArtifact(t.db, task, &model.Artifact{
+					Name:        r.ArtifactName,
+					Path:        stored.key,
+					Size:        r.ArtifactSize,
+					StoredSize:  stored.size,
+					Compression: stored.encoding,
+					SHA256:      r.ArtifactSHA256,
+					CreatedAt:   now,
+				})
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// failAttempt adds the updates that end the running attempt of task with reason: back to
+// pending after the backoff delay while it has attempts left, else the dead-letter queue
+func (s *Scheduler) failAttempt(updates map[string]any, task *model.Task, reason string, now time.Time) {
+	updates["attempt_history"] = append(task.AttemptHistory, model.Attempt{
+		Attempt:    task.Attempts,
+		WorkerID:   task.WorkerID,
+		Error:      reason,
+		StartedAt:  task.StartedAt,
+		FinishedAt: now,
+	})
+	if task.Attempts < task.MaxAttempts {
+		updates["status"] = model.StatusPending
+		updates["worker_id"] = ""
+		updates["started_at"] = nil
+		updates["lease_expires_at"] = nil
+		updates["next_retry_at"] = now.Add(s.retry.Delay(task.Attempts))
+	} else {
+		// out of retries, park it in the dead-letter queue with the final error as result
+		updates["status"] = model.StatusDeadLettered
+		updates["finished_at"] = now
+	}
+}
+
+// storedArtifact is where and how an uploaded artifact ended up in the store
+type storedArtifact struct {
+	key      string
+	encoding string
+	size     int64
+}
+
+// verifyArtifact compares the stored artifact against what the worker claims to have uploaded,
+// it returns a non-empty description on mismatch. Compressed artifacts are checked against
+// their uncompressed content.
+func (s *Scheduler) verifyArtifact(ctx context.Context, taskID string, r Report) (storedArtifact, string, error) {
+	var stored storedArtifact
+	if r.ArtifactName == "" {
+		return stored, "", nil
+	}
+	if s.artifacts == nil {
+		return stored, "", fmt.Errorf("%w: artifact uploads are not enabled", ErrInvalidTask)
+	}
+	if !artifact.ValidName(r.ArtifactName) {
+		return stored, "", fmt.Errorf("%w: %w", ErrInvalidTask, artifact.ErrInvalidName)
+	}
+	for _, enc := range artifact.Encodings {
+		key := artifact.EncodedKey(taskID, r.ArtifactName, enc)
+		info, err := s.artifacts.Stat(ctx, key)
+		if errors.Is(err, artifact.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return stored, "", err
+		}
+		stored = storedArtifact{key: key, encoding: enc, size: info.Size}
+		break
+	}
+	if stored.key == "" {
+		return stored, fmt.Sprintf("artifact %s was never uploaded", r.ArtifactName), nil
+	}
+
+	size, sum, err := artifact.Checksum(ctx, s.artifacts, stored.key, stored.encoding)
+	if errors.Is(err, artifact.ErrCorrupt) {
+		return stored, fmt.Sprintf("artifact %s does not decompress: %v", r.ArtifactName, err), nil
+	}
+	if err != nil {
+		return stored, "", err
+	}
+	if size != r.ArtifactSize || sum != r.ArtifactSHA256 {
+		return stored, fmt.Sprintf("artifact %s mismatch: expected size %d sha256 %s, got size %d sha256 %s",
+			r.ArtifactName, r.ArtifactSize, r.ArtifactSHA256, size, sum), nil
+	}
+	return stored, "", nil
+}
@@ -0,0 +1,1663 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Server/pkgs/artifact"
+	"Server/pkgs/config"
+	"Server/pkgs/database"
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// fakeClock is a manually advanced clock shared by the scheduler under test
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestScheduler(t *testing.T, retry RetryPolicy, clock *fakeClock, opts ...Option) *Scheduler {
+	t.Helper()
+	db, err := database.Open(config.DatabaseConfig{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000",
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return New(db, retry, append([]Option{WithClock(clock.Now)}, opts...)...)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, BackoffFactor: 2, MaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestFailedTaskIsRetriedUntilExhausted(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Minute, BackoffFactor: 2}, clock)
+
+	task := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	claimed, err := s.Claim(ctx, "worker-1")
+	if err != nil || claimed == nil {
+		t.Fatalf("claim: %v %v", claimed, err)
+	}
+	got, err := s.Report(ctx, task.ID, Report{WorkerID: "worker-1", Status: model.StatusFailed, Result: "ssh timeout"})
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if got.Status != model.StatusPending || got.Attempts != 1 || len(got.AttemptHistory) != 1 {
+		t.Fatalf("after first failure: status=%s attempts=%d history=%d", got.Status, got.Attempts, len(got.AttemptHistory))
+	}
+
+	// still backing off
+	if claimed, _ := s.Claim(ctx, "worker-1"); claimed != nil {
+		t.Fatalf("task dispatched before its retry delay")
+	}
+	clock.Advance(time.Minute)
+	if claimed, _ := s.Claim(ctx, "worker-2"); claimed == nil {
+		t.Fatalf("task not dispatched after its retry delay")
+	}
+
+	got, err = s.Report(ctx, task.ID, Report{WorkerID: "worker-2", Status: model.StatusFailed, Result: "kdump failed"})
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if got.Status != model.StatusDeadLettered || got.Attempts != 2 || got.FinishedAt == nil || got.Result != "kdump failed" {
+		t.Fatalf("after exhausting retries: status=%s attempts=%d result=%q", got.Status, got.Attempts, got.Result)
+	}
+	if got.AttemptHistory[1].Error != "kdump failed" || got.AttemptHistory[0].WorkerID != "worker-1" {
+		t.Fatalf("unexpected attempt history: %+v", got.AttemptHistory)
+	}
+	if claimed, _ := s.Claim(ctx, "worker-1"); claimed != nil {
+		t.Fatalf("dead-lettered task was dispatched")
+	}
+
+	got, err = s.Requeue(ctx, task.ID)
+	if err != nil || got.Status != model.StatusPending || got.Attempts != 0 || got.FinishedAt != nil || len(got.AttemptHistory) != 2 {
+		t.Fatalf("requeue: %+v %v", got, err)
+	}
+	if _, err := s.Requeue(ctx, task.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("requeue of a pending task: expected ErrInvalidState, got %v", err)
+	}
+	if claimed, _ := s.Claim(ctx, "worker-1"); claimed == nil || claimed.Attempts != 1 {
+		t.Fatalf("requeued task not dispatched with fresh attempts: %+v", claimed)
+	}
+}
+
+func TestNotBeforeDelaysDispatch(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+
+	window := clock.Now().Add(6 * time.Hour)
+	nightly := &model.Task{Type: model.TaskTypePatchApply, Priority: 10, NotBefore: &window}
+	regular := &model.Task{Type: model.TaskTypePatchApply}
+	for _, task := range []*model.Task{nightly, regular} {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+
+	if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil || task.ID != regular.ID {
+		t.Fatalf("expected the undelayed task first, got %v %v", task, err)
+	}
+	if task, _ := s.Claim(ctx, "worker-1"); task != nil {
+		t.Fatalf("task dispatched before its not_before")
+	}
+
+	clock.Advance(6 * time.Hour)
+	other := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, other); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	// once eligible the delayed task competes on priority like any other
+	if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil || task.ID != nightly.ID {
+		t.Fatalf("expected the delayed task once eligible, got %v %v", task, err)
+	}
+}
+
+func TestClaimOrdersByPriorityThenCreation(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+
+	submit := func(taskType model.TaskType, priority int) string {
+		task := &model.Task{Type: taskType, Priority: priority}
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		clock.Advance(time.Second)
+		return task.ID
+	}
+	vmcore1 := submit(model.TaskTypeGetVmcore, 0)
+	vmcore2 := submit(model.TaskTypeGetVmcore, 0)
+	patch1 := submit(model.TaskTypePatchApply, 10)
+	vmcore3 := submit(model.TaskTypeGetVmcore, 5)
+	patch2 := submit(model.TaskTypePatchApply, 10)
+
+	want := []string{patch1, patch2, vmcore3, vmcore1, vmcore2}
+	for i, id := range want {
+		task, err := s.Claim(ctx, "worker-1")
+		if err != nil || task == nil {
+			t.Fatalf("claim %d: %v %v", i, task, err)
+		}
+		if task.ID != id {
+			t.Fatalf("claim %d: got task %s (priority %d), want %s", i, task.ID, task.Priority, id)
+		}
+	}
+}
+
+func TestReclaimExpiredLeasesIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 2}, clock)
+
+	for _, id := range []string{"alive", "dead"} {
+		if _, err := s.Heartbeat(ctx, id, nil); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+		if err := s.Submit(ctx, &model.Task{Type: model.TaskTypeGetVmcore}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		if task, err := s.Claim(ctx, id); err != nil || task == nil {
+			t.Fatalf("claim: %v %v", task, err)
+		}
+	}
+
+	running, err := s.List(ctx, TaskFilter{Status: model.StatusRunning})
+	if err != nil || len(running) != 2 {
+		t.Fatalf("list running: %v %v", running, err)
+	}
+	owned := map[string]string{}
+	for _, task := range running {
+		owned[task.WorkerID] = task.ID
+	}
+
+	// only the renewing worker keeps its task once the first lease is over
+	clock.Advance(50 * time.Second)
+	renewed, err := s.Renew(ctx, owned["alive"], "alive")
+	if err != nil || !renewed.LeaseExpiresAt.Equal(clock.Now().Add(defaultLease)) {
+		t.Fatalf("renew: %+v %v", renewed, err)
+	}
+	if _, err := s.Renew(ctx, owned["dead"], "alive"); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("renewing another worker's task: expected ErrNotOwner, got %v", err)
+	}
+	clock.Advance(20 * time.Second)
+	// heartbeats alone do not keep a lease alive
+	if _, err := s.Heartbeat(ctx, "dead", nil); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+
+	n, err := s.ReclaimExpired(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("first reclaim: n=%d err=%v", n, err)
+	}
+	if task, _ := s.Get(ctx, owned["alive"]); task.Status != model.StatusRunning || task.WorkerID != "alive" {
+		t.Fatalf("renewed task was reclaimed: %+v", task)
+	}
+	if _, err := s.Renew(ctx, owned["dead"], "dead"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("renewing a reclaimed task: expected ErrInvalidState, got %v", err)
+	}
+	n, err = s.ReclaimExpired(ctx)
+	if err != nil || n != 0 {
+		t.Fatalf("second reclaim: n=%d err=%v", n, err)
+	}
+
+	task, err := s.Claim(ctx, "alive")
+	if err != nil || task == nil || task.ID != owned["dead"] {
+		t.Fatalf("reclaimed task not dispatchable: %v %v", task, err)
+	}
+	if task.Attempts != 2 {
+		t.Fatalf("expected reclaimed task to keep its attempt count, got %d", task.Attempts)
+	}
+
+	events, err := s.AuditLog(ctx, task.ID)
+	if err != nil || len(events) != 4 {
+		t.Fatalf("audit log: %+v %v", events, err)
+	}
+	if events[2].Action != model.AuditRetried || events[2].Actor != SystemActor || events[2].Details["reason"] != leaseExpiredResult {
+		t.Fatalf("reclaim should be audited as a system retry, got %s by %s: %v", events[2].Action, events[2].Actor, events[2].Details)
+	}
+	if len(task.AttemptHistory) != 1 || task.AttemptHistory[0].Error != leaseExpiredResult || task.AttemptHistory[0].WorkerID != "dead" {
+		t.Fatalf("expired lease should be recorded in the attempt history: %+v", task.AttemptHistory)
+	}
+}
+
+func TestExpiredLeaseDeadLettersTheLastAttempt(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 3}, clock)
+	task := &model.Task{Type: model.TaskTypeGetVmcore, MaxAttempts: 1}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if claimed, err := s.Claim(ctx, "worker-1"); err != nil || claimed == nil {
+		t.Fatalf("claim: %v %v", claimed, err)
+	}
+	clock.Advance(2 * defaultLease)
+
+	if n, err := s.ReclaimExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("reclaim: n=%d err=%v", n, err)
+	}
+	got, _ := s.Get(ctx, task.ID)
+	if got.Status != model.StatusDeadLettered || got.Result != leaseExpiredResult || got.Attempts != 1 || len(got.AttemptHistory) != 1 {
+		t.Fatalf("task out of attempts should be dead-lettered: %+v", got)
+	}
+	if claimed, err := s.Claim(ctx, "worker-2"); err != nil || claimed != nil {
+		t.Fatalf("dead-lettered task was dispatched again: %v %v", claimed, err)
+	}
+}
+
+func TestReclaimExpiredContinuesPastAFailingTask(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 3}, clock)
+	for range 2 {
+		if err := s.Submit(ctx, &model.Task{Type: model.TaskTypeGetVmcore}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil {
+			t.Fatalf("claim: %v %v", task, err)
+		}
+	}
+	clock.Advance(2 * defaultLease)
+
+	// the first reclaim hits a database error
+	var updates atomic.Int32
+	err := s.db.Callback().Update().Before("gorm:update").Register("test:fail_first_reclaim", func(db *gorm.DB) {
+		if db.Statement.Table == "tasks" && updates.Add(1) == 1 {
+			db.AddError(errors.New("disk full"))
+		}
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	n, err := s.ReclaimExpired(ctx)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected one task reclaimed and the other's error, got n=%d err=%v", n, err)
+	}
+	if pending, _ := s.CountPending(ctx); pending != 1 {
+		t.Fatalf("the task after the failing one should still be reclaimed, %d pending", pending)
+	}
+}
+
+func TestFailTimedOut(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 3}, clock)
+
+	slow := &model.Task{Type: model.TaskTypePatchApply, TimeoutSeconds: 60}
+	if err := s.Submit(ctx, slow); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	defaulted := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, defaulted); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if defaulted.TimeoutSeconds != 2*60*60 {
+		t.Fatalf("expected default vmcore timeout, got %d", defaulted.TimeoutSeconds)
+	}
+	for range 2 {
+		if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil {
+			t.Fatalf("claim: %v %v", task, err)
+		}
+	}
+
+	// pretend the patch apply started well before its timeout
+	past := clock.Now().Add(-2 * time.Minute)
+	if err := s.db.Model(&model.Task{}).Where("id = ?", slow.ID).Update("started_at", past).Error; err != nil {
+		t.Fatalf("rewind started_at: %v", err)
+	}
+
+	n, err := s.FailTimedOut(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("FailTimedOut: n=%d err=%v", n, err)
+	}
+	got, _ := s.Get(ctx, slow.ID)
+	if got.Status != model.StatusFailed || got.Result != timeoutResult {
+		t.Fatalf("timed out task: status=%s result=%q", got.Status, got.Result)
+	}
+	if got, _ := s.Get(ctx, defaulted.ID); got.Status != model.StatusRunning {
+		t.Fatalf("task within its timeout should keep running, got %s", got.Status)
+	}
+}
+
+func TestRetryOnTimeout(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	retry := true
+	types, err := NewTaskTypes(map[string]config.TaskTypeConfig{string(model.TaskTypePatchApply): {RetryOnTimeout: &retry}})
+	if err != nil {
+		t.Fatalf("task types: %v", err)
+	}
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 3}, clock, WithTaskTypes(types))
+
+	slow := &model.Task{Type: model.TaskTypePatchApply, TimeoutSeconds: 60}
+	last := &model.Task{Type: model.TaskTypePatchApply, TimeoutSeconds: 60, MaxAttempts: 1}
+	for _, task := range []*model.Task{slow, last} {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		if claimed, err := s.Claim(ctx, "worker-1"); err != nil || claimed == nil {
+			t.Fatalf("claim: %v %v", claimed, err)
+		}
+	}
+	clock.Advance(2 * time.Minute)
+
+	if n, err := s.FailTimedOut(ctx); err != nil || n != 2 {
+		t.Fatalf("FailTimedOut: n=%d err=%v", n, err)
+	}
+	got, _ := s.Get(ctx, slow.ID)
+	if got.Status != model.StatusPending || got.Result != timeoutResult || got.NextRetryAt == nil || got.WorkerID != "" {
+		t.Fatalf("timed out task with attempts left should wait for a retry: %+v", got)
+	}
+	if len(got.AttemptHistory) != 1 || got.AttemptHistory[0].Error != timeoutResult {
+		t.Fatalf("timeout should be recorded in the attempt history: %+v", got.AttemptHistory)
+	}
+	if got, _ := s.Get(ctx, last.ID); got.Status != model.StatusDeadLettered || got.Result != timeoutResult {
+		t.Fatalf("timed out task out of attempts should be dead-lettered: status=%s result=%q", got.Status, got.Result)
+	}
+}
+
+func TestExpirePending(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 2}, clock)
+
+	window := clock.Now().Add(10 * time.Minute)
+	delayed := &model.Task{Type: model.TaskTypePatchApply, PendingTTLSeconds: 60, NotBefore: &window}
+	retried := &model.Task{Type: model.TaskTypePatchApply, PendingTTLSeconds: 60}
+	for _, task := range []*model.Task{delayed, retried} {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	if want := window.Add(time.Minute); delayed.ExpiresAt == nil || !delayed.ExpiresAt.Equal(want) {
+		t.Fatalf("ttl should start at not_before: expires_at=%v, want %v", delayed.ExpiresAt, want)
+	}
+
+	// a task that was claimed once no longer expires, even when it fails back into the queue
+	if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil || task.ID != retried.ID {
+		t.Fatalf("claim: %v %v", task, err)
+	}
+	if _, err := s.Report(ctx, retried.ID, Report{WorkerID: "worker-1", Status: model.StatusFailed, Result: "flaky"}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+
+	clock.Advance(11 * time.Minute)
+	if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil || task.ID != retried.ID {
+		t.Fatalf("at the boundary only the retried task is dispatchable, got %v %v", task, err)
+	}
+	n, err := s.ExpirePending(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("ExpirePending: n=%d err=%v", n, err)
+	}
+	got, _ := s.Get(ctx, delayed.ID)
+	if got.Status != model.StatusFailed || got.FinishedAt == nil || !strings.HasPrefix(got.Result, "expired") {
+		t.Fatalf("expired task: status=%s result=%q", got.Status, got.Result)
+	}
+	if n, _ := s.ExpirePending(ctx); n != 0 {
+		t.Fatalf("second sweep expired %d tasks", n)
+	}
+}
+
+func TestClaimRacingExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+
+	const tasks = 10
+	for range tasks {
+		if err := s.Submit(ctx, &model.Task{Type: model.TaskTypePatchApply, PendingTTLSeconds: 1}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range tasks {
+			s.Claim(ctx, "worker-1")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		clock.Advance(time.Second)
+		s.ExpirePending(ctx)
+	}()
+	wg.Wait()
+
+	var all []model.Task
+	s.db.Find(&all)
+	for _, task := range all {
+		switch {
+		case task.Status == model.StatusRunning && task.WorkerID == "worker-1":
+		case task.Status == model.StatusFailed && task.StartedAt == nil:
+		case task.Status == model.StatusPending:
+		default:
+			t.Errorf("task %s both claimed and expired: status=%s worker=%q", task.ID, task.Status, task.WorkerID)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 3}, clock)
+
+	running := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, running); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if task, err := s.Claim(ctx, "worker-1"); err != nil || task == nil {
+		t.Fatalf("claim: %v %v", task, err)
+	}
+	pending := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, pending); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	if got, err := s.Cancel(ctx, pending.ID); err != nil || got.Status != model.StatusCancelled {
+		t.Fatalf("cancel pending: %v %v", got, err)
+	}
+	got, err := s.Cancel(ctx, running.ID)
+	if err != nil || got.Status != model.StatusRunning || !got.CancelRequested {
+		t.Fatalf("cancel running: %+v %v", got, err)
+	}
+
+	ids, err := s.PendingCancels(ctx, "worker-1")
+	if err != nil || len(ids) != 1 || ids[0] != running.ID {
+		t.Fatalf("pending cancels: %v %v", ids, err)
+	}
+
+	// a failure report after cancellation must not re-enqueue the task
+	got, err = s.Report(ctx, running.ID, Report{WorkerID: "worker-1", Status: model.StatusFailed, ArtifactName: "vmcore"})
+	if err != nil || got.Status != model.StatusCancelled || got.ArtifactPath != "" {
+		t.Fatalf("report after cancel: %+v %v", got, err)
+	}
+	if _, err := s.Cancel(ctx, running.ID); err != ErrInvalidState {
+		t.Fatalf("cancelling a finished task: expected ErrInvalidState, got %v", err)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+
+	vmcore := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, vmcore); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	patch := &model.Task{Type: model.TaskTypePatchApply, Priority: 10, DependsOn: model.StringList{vmcore.ID}}
+	if err := s.Submit(ctx, patch); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	followUp := &model.Task{Type: model.TaskTypePatchApply, DependsOn: model.StringList{patch.ID}}
+	if err := s.Submit(ctx, followUp); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if !patch.Blocked || !followUp.Blocked {
+		t.Fatalf("dependent tasks should start blocked")
+	}
+
+	// the higher priority patch must wait for its vmcore
+	claimed, err := s.Claim(ctx, "worker-1")
+	if err != nil || claimed == nil || claimed.ID != vmcore.ID {
+		t.Fatalf("expected the vmcore task to be claimed first, got %v %v", claimed, err)
+	}
+	if _, err := s.Report(ctx, vmcore.ID, Report{WorkerID: "worker-1", Status: model.StatusSuccess}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+
+	claimed, err = s.Claim(ctx, "worker-1")
+	if err != nil || claimed == nil || claimed.ID != patch.ID {
+		t.Fatalf("expected the patch task once unblocked, got %v %v", claimed, err)
+	}
+	if _, err := s.Report(ctx, patch.ID, Report{WorkerID: "worker-1", Status: model.StatusFailed, Result: "hunk rejected"}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	got, _ := s.Get(ctx, followUp.ID)
+	if got.Status != model.StatusFailed || got.Result == "" {
+		t.Fatalf("dependent of a failed task should fail, got %s %q", got.Status, got.Result)
+	}
+
+	if err := s.Submit(ctx, &model.Task{Type: model.TaskTypePatchApply, DependsOn: model.StringList{"missing"}}); !errors.Is(err, ErrInvalidTask) {
+		t.Fatalf("unknown dependency: expected ErrInvalidTask, got %v", err)
+	}
+	self := &model.Task{ID: "11111111-1111-1111-1111-111111111111", Type: model.TaskTypePatchApply}
+	self.DependsOn = model.StringList{self.ID}
+	if err := s.Submit(ctx, self); !errors.Is(err, ErrInvalidTask) {
+		t.Fatalf("self dependency: expected ErrInvalidTask, got %v", err)
+	}
+}
+
+func TestCheckDependenciesRejectsCycles(t *testing.T) {
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+	a := &model.Task{ID: "a", DependsOn: model.StringList{"c"}}
+	b := &model.Task{ID: "b", DependsOn: model.StringList{"a"}}
+	c := &model.Task{ID: "c", DependsOn: model.StringList{"b"}}
+	if _, err := checkDependencies(s.db, []*model.Task{a, b, c}); !errors.Is(err, ErrInvalidTask) {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+	c.DependsOn = nil
+	if _, err := checkDependencies(s.db, []*model.Task{a, b, c}); err != nil {
+		t.Fatalf("acyclic graph rejected: %v", err)
+	}
+}
+
+func TestClaimMatchesCapabilities(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+
+	if _, err := s.Heartbeat(ctx, "plain", []string{"kvm"}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if _, err := s.Heartbeat(ctx, "debug", []string{"kvm", "debug-symbols"}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+
+	// enough unmatched tasks ahead of the plain one to span several claim batches
+	var analysis []string
+	for range claimBatch + 2 {
+		task := &model.Task{Type: model.TaskTypeGetVmcore, Priority: 1, RequiredCapabilities: model.StringList{"debug-symbols"}}
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		analysis = append(analysis, task.ID)
+	}
+	plain := &model.Task{Type: model.TaskTypeGetVmcore, RequiredCapabilities: model.StringList{"kvm"}}
+	if err := s.Submit(ctx, plain); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	got, err := s.Claim(ctx, "plain")
+	if err != nil || got == nil || got.ID != plain.ID {
+		t.Fatalf("plain worker should get the kvm-only task, got %v %v", got, err)
+	}
+	if got, _ := s.Claim(ctx, "plain"); got != nil {
+		t.Fatalf("plain worker must not get a debug-symbols task, got %s", got.ID)
+	}
+	got, err = s.Claim(ctx, "debug")
+	if err != nil || got == nil || got.ID != analysis[0] {
+		t.Fatalf("debug worker should get the first analysis task, got %v %v", got, err)
+	}
+	if got.WorkerID != "debug" {
+		t.Fatalf("task landed on %s", got.WorkerID)
+	}
+}
+
+func TestTransitionsArePublished(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+	var got []Transition
+	s.OnTransition(func(tr Transition) { got = append(got, tr) })
+
+	task := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := s.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := s.Report(ctx, task.ID, Report{WorkerID: "worker-2", Status: model.StatusSuccess}); err != ErrNotOwner {
+		t.Fatalf("foreign report: expected ErrNotOwner, got %v", err)
+	}
+	if _, err := s.Report(ctx, task.ID, Report{WorkerID: "worker-1", Status: model.StatusSuccess}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+
+	want := [][2]model.TaskStatus{
+		{"", model.StatusPending},
+		{model.StatusPending, model.StatusRunning},
+		{model.StatusRunning, model.StatusSuccess},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d transitions, got %+v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].From != w[0] || got[i].To != w[1] || got[i].Task.ID != task.ID {
+			t.Errorf("transition %d: got %s->%s, want %s->%s", i, got[i].From, got[i].To, w[0], w[1])
+		}
+	}
+}
+
+func TestSubmitIdempotencyRace(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+
+	// simulate the loser of a race: the winner's row lands between lookup and insert
+	key := "fleet-crash-7"
+	winner := &model.Task{Type: model.TaskTypeGetVmcore, IdempotencyKey: &key}
+	s.prepare(winner)
+	if err := s.db.Create(winner).Error; err != nil {
+		t.Fatalf("insert winner: %v", err)
+	}
+	loser := &model.Task{Type: model.TaskTypeGetVmcore, IdempotencyKey: &key}
+	if err := s.inTx(ctx, func(tx *txn) error { s.prepare(loser); return insertTask(tx, loser) }); !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Fatalf("expected the unique constraint to fire, got %v", err)
+	}
+
+	replay := &model.Task{Type: model.TaskTypeGetVmcore, IdempotencyKey: &key}
+	if err := s.Submit(ctx, replay); !errors.Is(err, ErrAlreadySubmitted) || replay.ID != winner.ID {
+		t.Fatalf("expected the winner %s back, got %s %v", winner.ID, replay.ID, err)
+	}
+}
+
+func TestRetriedBatchReturnsTheOriginalTasks(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+
+	batch := func() []*model.Task {
+		first, second := "crash-1", "crash-2"
+		return []*model.Task{
+			{Type: model.TaskTypeGetVmcore, IdempotencyKey: &first},
+			{Type: model.TaskTypePatchApply},
+			{Type: model.TaskTypeGetVmcore, IdempotencyKey: &second},
+		}
+	}
+	original := batch()
+	if err := s.SubmitBatch(ctx, original); err != nil {
+		t.Fatalf("submit batch: %v", err)
+	}
+
+	retried := batch()
+	if err := s.SubmitBatch(ctx, retried); err != nil {
+		t.Fatalf("retried batch: %v", err)
+	}
+	if retried[0].ID != original[0].ID || retried[2].ID != original[2].ID {
+		t.Fatalf("keyed tasks should come back as the original ones: %s %s", retried[0].ID, retried[2].ID)
+	}
+	// without a key a task is new every time
+	if retried[1].ID == original[1].ID {
+		t.Fatalf("unkeyed task should be submitted again")
+	}
+	var n int64
+	s.db.Model(&model.Task{}).Count(&n)
+	if n != 4 {
+		t.Fatalf("expected the retry to add only the unkeyed task, found %d tasks", n)
+	}
+
+	key := "crash-3"
+	twice := []*model.Task{
+		{Type: model.TaskTypeGetVmcore, IdempotencyKey: &key},
+		{Type: model.TaskTypeGetVmcore, IdempotencyKey: &key},
+	}
+	var batchErr *BatchError
+	if err := s.SubmitBatch(ctx, twice); !errors.As(err, &batchErr) || batchErr.Index != 1 || !errors.Is(err, ErrInvalidTask) {
+		t.Fatalf("expected a key used twice in one batch to be rejected, got %v", err)
+	}
+}
+
+func TestArchiveOnlyTouchesOldFinishedTasks(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+
+	submit := func() *model.Task {
+		task := &model.Task{Type: model.TaskTypeGetVmcore}
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		return task
+	}
+	done := submit()
+	if _, err := s.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := s.Report(ctx, done.ID, Report{WorkerID: "worker-1", Status: model.StatusSuccess}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	running := submit()
+	if _, err := s.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	pending := submit()
+
+	clock.Advance(48 * time.Hour)
+	n, err := s.Archive(ctx, 24*time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("archive: n=%d err=%v", n, err)
+	}
+
+	hot, _ := s.List(ctx, TaskFilter{})
+	if len(hot) != 2 {
+		t.Fatalf("expected the running and pending tasks in the hot listing, got %d", len(hot))
+	}
+	all, _ := s.List(ctx, TaskFilter{IncludeArchived: true})
+	if len(all) != 3 {
+		t.Fatalf("expected every task with include_archived, got %d", len(all))
+	}
+	if got, err := s.Get(ctx, done.ID); err != nil || !got.DeletedAt.Valid {
+		t.Fatalf("archived task should stay reachable by id: %v %v", got, err)
+	}
+	for _, task := range []*model.Task{running, pending} {
+		if got, _ := s.Get(ctx, task.ID); got.DeletedAt.Valid {
+			t.Fatalf("unfinished task %s was archived", task.ID)
+		}
+	}
+}
+
+func TestStaleSnapshotLosesTheRace(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+
+	task := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	claimed, err := s.Claim(ctx, "worker-1")
+	if err != nil || claimed == nil {
+		t.Fatalf("claim: %v %v", claimed, err)
+	}
+	// the reaper's snapshot of the running task, taken before the worker finishes
+	stale := *claimed
+
+	done, err := s.Report(ctx, task.ID, Report{WorkerID: "worker-1", Status: model.StatusSuccess})
+	if err != nil || done.Version != stale.Version+1 {
+		t.Fatalf("report: version=%d err=%v", done.Version, err)
+	}
+
+	var ok bool
+	err = s.inTx(ctx, func(tx *txn) error {
+		var err error
+		ok, err = s.transition(tx, &stale, map[string]any{"status": model.StatusPending, "worker_id": ""})
+		return err
+	})
+	if err != nil || ok {
+		t.Fatalf("stale write must not apply: ok=%v err=%v", ok, err)
+	}
+	if got, _ := s.Get(ctx, task.ID); got.Status != model.StatusSuccess {
+		t.Fatalf("lost update: status=%s", got.Status)
+	}
+}
+
+func TestReaperCheck(t *testing.T) {
+	r := NewReaper(newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock()), time.Hour)
+	if err := r.Check(context.Background()); err == nil {
+		t.Fatalf("check passed before Run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for r.Check(ctx) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("check still failing while running: %v", r.Check(ctx))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if err := r.Check(context.Background()); err == nil {
+		t.Fatalf("check passed after Run returned")
+	}
+}
+
+func TestConcurrencyLimitPerType(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock(),
+		WithConcurrencyLimits(map[model.TaskType]int{model.TaskTypeGetVmcore: 2}))
+
+	for range 5 {
+		if err := s.Submit(ctx, &model.Task{Type: model.TaskTypeGetVmcore, Priority: 10}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	patch := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, patch); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	claimed := make(chan *model.Task, 6)
+	for i := range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task, err := s.Claim(ctx, fmt.Sprintf("worker-%d", i))
+			if err != nil {
+				t.Errorf("claim: %v", err)
+			}
+			claimed <- task
+		}()
+	}
+	wg.Wait()
+	close(claimed)
+
+	byType := map[model.TaskType]int{}
+	var vmcore *model.Task
+	for task := range claimed {
+		if task != nil {
+			byType[task.Type]++
+			if task.Type == model.TaskTypeGetVmcore {
+				vmcore = task
+			}
+		}
+	}
+	if byType[model.TaskTypeGetVmcore] != 2 || byType[model.TaskTypePatchApply] != 1 {
+		t.Fatalf("expected 2 vmcore and 1 patch task running, got %v", byType)
+	}
+
+	usage, err := s.ConcurrencyUsage(ctx)
+	if err != nil || usage[0].Type != model.TaskTypeGetVmcore || usage[0].Running != 2 || usage[0].Limit != 2 || usage[1].Limit != 0 {
+		t.Fatalf("usage: %+v %v", usage, err)
+	}
+
+	// finishing one frees its slot
+	if _, err := s.Report(ctx, vmcore.ID, Report{WorkerID: vmcore.WorkerID, Status: model.StatusSuccess}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if task, err := s.Claim(ctx, "worker-9"); err != nil || task == nil || task.Type != model.TaskTypeGetVmcore {
+		t.Fatalf("freed slot not reused: %v %v", task, err)
+	}
+	if task, _ := s.Claim(ctx, "worker-10"); task != nil {
+		t.Fatalf("limit exceeded by %s", task.ID)
+	}
+}
+
+func TestReassignExcludesTheWedgedWorker(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+	for _, id := range []string{"wedged", "healthy"} {
+		if _, err := s.Heartbeat(ctx, id, nil); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+	task := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := s.Reassign(ctx, task.ID, true, ""); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("reassigning a pending task: expected ErrInvalidState, got %v", err)
+	}
+	if claimed, err := s.Claim(ctx, "wedged"); err != nil || claimed == nil {
+		t.Fatalf("claim: %v %v", claimed, err)
+	}
+
+	reassigned, err := s.Reassign(WithActor(ctx, "key:admin"), task.ID, true, "stuck in D state")
+	if err != nil {
+		t.Fatalf("reassign: %v", err)
+	}
+	if reassigned.Status != model.StatusPending || reassigned.WorkerID != "" || !slices.Equal(reassigned.ExcludedWorkers, model.StringList{"wedged"}) {
+		t.Fatalf("unexpected task after reassign: %+v", reassigned)
+	}
+	if again, _ := s.Claim(ctx, "wedged"); again != nil {
+		t.Fatalf("excluded worker got the task back")
+	}
+	if claimed, err := s.Claim(ctx, "healthy"); err != nil || claimed == nil || claimed.ID != task.ID {
+		t.Fatalf("task did not move to the healthy worker: %v %v", claimed, err)
+	}
+
+	events, err := s.AuditLog(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("audit log: %v", err)
+	}
+	got := events[2]
+	if got.Action != model.AuditReassigned || got.Actor != "key:admin" || got.Details["from_worker"] != "wedged" || got.Details["reason"] != "stuck in D state" {
+		t.Fatalf("reassign audited as %+v", got)
+	}
+}
+
+func TestEstimateWait(t *testing.T) {
+	for _, tc := range []struct {
+		pending, parallelism int64
+		avg                  float64
+		samples              int
+		want                 *float64
+	}{
+		{pending: 0, parallelism: 0, avg: 0, samples: 0, want: ptr(0.0)},
+		{pending: 6, parallelism: 3, avg: 100, samples: 10, want: ptr(200.0)},
+		{pending: 1, parallelism: 4, avg: 60, samples: 1, want: ptr(15.0)},
+		{pending: 5, parallelism: 0, avg: 60, samples: 10, want: nil},
+		{pending: 5, parallelism: 2, avg: 0, samples: 0, want: nil},
+	} {
+		got := estimateWait(tc.pending, tc.parallelism, tc.avg, tc.samples)
+		if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+			t.Errorf("estimateWait(%d, %d, %v, %d) = %v, want %v", tc.pending, tc.parallelism, tc.avg, tc.samples, got, tc.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestQueueStats(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock,
+		WithConcurrencyLimits(map[model.TaskType]int{model.TaskTypeGetVmcore: 2}))
+
+	// finished vmcore pulls that took 1, 2 and 3 minutes, the dead-lettered one does not count
+	for i, status := range []model.TaskStatus{model.StatusSuccess, model.StatusFailed, model.StatusSuccess, model.StatusDeadLettered} {
+		task := &model.Task{Type: model.TaskTypeGetVmcore}
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		started := clock.Now().Add(-time.Hour)
+		finished := started.Add(time.Duration(i+1) * time.Minute)
+		err := s.db.Model(task).Updates(map[string]any{"status": status, "started_at": started, "finished_at": finished}).Error
+		if err != nil {
+			t.Fatalf("backdate history: %v", err)
+		}
+	}
+	for range 6 {
+		if err := s.Submit(ctx, &model.Task{Type: model.TaskTypeGetVmcore}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	if err := s.Submit(ctx, &model.Task{Type: model.TaskTypePatchApply}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	for _, id := range []string{"worker-1", "worker-2", "worker-3", "drained", "gone"} {
+		if _, err := s.Heartbeat(ctx, id, nil); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+	s.SetDraining(ctx, "drained", true)
+	s.db.Model(&model.Worker{}).Where("id = ?", "gone").Update("last_seen_at", clock.Now().Add(-time.Hour))
+
+	stats, err := s.QueueStats(ctx)
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("queue stats: %+v %v", stats, err)
+	}
+	vmcore, patch := stats[0], stats[1]
+	// 6 pending at 2 minutes each, 3 workers but only 2 vmcore pulls at a time
+	if vmcore.Pending != 6 || vmcore.AvailableWorkers != 3 || vmcore.Parallelism != 2 || vmcore.DurationSamples != 3 ||
+		vmcore.AvgDurationSeconds != 120 || vmcore.EstimatedWaitSeconds == nil || *vmcore.EstimatedWaitSeconds != 360 {
+		t.Fatalf("vmcore stats: %+v", vmcore)
+	}
+	if patch.Pending != 1 || patch.Parallelism != 3 || patch.EstimatedWaitSeconds != nil {
+		t.Fatalf("patch stats without history should have no estimate: %+v", patch)
+	}
+
+	// served from the cache until it expires
+	if err := s.Submit(ctx, &model.Task{Type: model.TaskTypeGetVmcore}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if stats, _ := s.QueueStats(ctx); stats[0].Pending != 6 {
+		t.Fatalf("cached stats recomputed: %+v", stats[0])
+	}
+	clock.Advance(queueStatsTTL)
+	if stats, _ := s.QueueStats(ctx); stats[0].Pending != 7 {
+		t.Fatalf("stale stats kept: %+v", stats[0])
+	}
+}
+
+func TestAppendLogKeepsOrderAndCap(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock(), WithLogLimit(10))
+	task := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := s.AppendLog(ctx, task.ID, "worker-1", "early"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("append to a pending task: expected ErrInvalidState, got %v", err)
+	}
+	if claimed, err := s.Claim(ctx, "worker-1"); err != nil || claimed == nil {
+		t.Fatalf("claim: %v %v", claimed, err)
+	}
+	if _, err := s.AppendLog(ctx, task.ID, "worker-2", "intruder"); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("append by another worker: expected ErrNotOwner, got %v", err)
+	}
+	if _, err := s.AppendLog(ctx, task.ID, "worker-1", "longer than the cap"); !errors.Is(err, ErrInvalidTask) {
+		t.Fatalf("oversized chunk: expected ErrInvalidTask, got %v", err)
+	}
+
+	for _, data := range []string{"aaaa", "bbbb", "cccc", "dd"} {
+		if _, err := s.AppendLog(ctx, task.ID, "worker-1", data); err != nil {
+			t.Fatalf("append %q: %v", data, err)
+		}
+	}
+	// 14 bytes against a cap of 10, the first chunk had to go
+	chunks, truncated, err := s.Logs(ctx, task.ID, 0, 100)
+	if err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+	var got []string
+	for _, c := range chunks {
+		got = append(got, fmt.Sprintf("%d@%d:%s", c.Seq, c.ByteOffset, c.Data))
+	}
+	if want := []string{"2@4:bbbb", "3@8:cccc", "4@12:dd"}; !slices.Equal(got, want) || truncated != 4 {
+		t.Fatalf("logs after truncation: %v truncated=%d, want %v truncated=4", got, truncated, want)
+	}
+	if chunks, _, _ := s.Logs(ctx, task.ID, 3, 100); len(chunks) != 1 || chunks[0].Seq != 4 {
+		t.Fatalf("logs after seq 3: %+v", chunks)
+	}
+
+	// the log outlives the run
+	if _, err := s.Report(ctx, task.ID, Report{WorkerID: "worker-1", Status: model.StatusFailed, Result: "hunk 2 failed"}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if _, err := s.AppendLog(ctx, task.ID, "worker-1", "late"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("append to a finished task: expected ErrInvalidState, got %v", err)
+	}
+	if chunks, _, err := s.Logs(ctx, task.ID, 0, 100); err != nil || len(chunks) != 3 {
+		t.Fatalf("logs of the finished task: %d %v", len(chunks), err)
+	}
+}
+
+func TestRepeatedReportIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+	var finished int
+	s.OnTransition(func(tr Transition) {
+		if tr.To.Terminal() {
+			finished++
+		}
+	})
+	task := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := s.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	// the worker's first call timed out on its side and it sends the report again right away
+	report := Report{WorkerID: "worker-1", Status: model.StatusSuccess, Result: "applied 3 patches"}
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Go(func() {
+			_, errs[i] = s.Report(ctx, task.ID, report)
+		})
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("report %d: %v", i, err)
+		}
+	}
+	again, err := s.Report(ctx, task.ID, report)
+	if err != nil || again.Status != model.StatusSuccess || again.Result != report.Result {
+		t.Fatalf("third report: %+v %v", again, err)
+	}
+	if finished != 1 {
+		t.Fatalf("expected a single final transition, got %d", finished)
+	}
+	events, _ := s.AuditLog(ctx, task.ID)
+	if len(events) != 3 {
+		t.Fatalf("replays must not be audited: %+v", events)
+	}
+
+	for name, conflicting := range map[string]Report{
+		"different result": {WorkerID: "worker-1", Status: model.StatusSuccess, Result: "applied 2 patches"},
+		"different status": {WorkerID: "worker-1", Status: model.StatusFailed, Result: report.Result},
+		"other worker":     {WorkerID: "worker-2", Status: model.StatusSuccess, Result: report.Result},
+	} {
+		if _, err := s.Report(ctx, task.ID, conflicting); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("%s: expected ErrInvalidState, got %v", name, err)
+		}
+	}
+}
+
+func TestWorkerStatsAttributeEveryRun(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 3}, clock, WithStatsWindow(time.Hour))
+	for _, id := range []string{"worker-1", "worker-2"} {
+		if _, err := s.Heartbeat(ctx, id, nil); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+	claim := func(workerID string) *model.Task {
+		t.Helper()
+		task, err := s.Claim(ctx, workerID)
+		if err != nil || task == nil {
+			t.Fatalf("claim by %s: %v %v", workerID, task, err)
+		}
+		return task
+	}
+
+	// worker-1 loses the task to the reaper and worker-2 finishes it
+	reclaimed := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, reclaimed); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	claim("worker-1")
+	clock.Advance(2 * time.Minute)
+	if n, err := s.ReclaimExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("reclaim: %d %v", n, err)
+	}
+	claim("worker-2")
+	clock.Advance(30 * time.Second)
+	if _, err := s.Report(ctx, reclaimed.ID, Report{WorkerID: "worker-2", Status: model.StatusSuccess}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	// the late report of the first worker changes nothing
+	if _, err := s.Report(ctx, reclaimed.ID, Report{WorkerID: "worker-1", Status: model.StatusSuccess}); err == nil {
+		t.Fatalf("late report of the reclaimed worker went through")
+	}
+
+	// a failed attempt, then a retry that is still running once the window moved on
+	retried := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, retried); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	claim("worker-1")
+	clock.Advance(10 * time.Second)
+	if _, err := s.Report(ctx, retried.ID, Report{WorkerID: "worker-1", Status: model.StatusFailed, Result: "patch does not apply"}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	clock.Advance(2 * time.Hour)
+	claim("worker-1")
+	clock.Advance(15 * time.Second)
+
+	stats, err := s.WorkerStats(ctx, "worker-1")
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	life := stats.Lifetime
+	if stats.Running != 1 || life.Total != 2 || life.Outcomes[model.RunReclaimed] != 1 || life.Outcomes[model.RunFailed] != 1 || life.Outcomes[model.RunSucceeded] != 0 {
+		t.Fatalf("unexpected lifetime stats of worker-1: %+v", stats)
+	}
+	if life.BusySeconds != 145 || stats.Window.Total != 0 || stats.Window.BusySeconds != 15 || stats.WindowSeconds != 3600 {
+		t.Fatalf("unexpected busy time of worker-1: %+v", stats)
+	}
+	stats, err = s.WorkerStats(ctx, "worker-2")
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Running != 0 || stats.Lifetime.Total != 1 || stats.Lifetime.Outcomes[model.RunSucceeded] != 1 || stats.Lifetime.BusySeconds != 30 {
+		t.Fatalf("unexpected stats of worker-2: %+v", stats)
+	}
+	if _, err := s.WorkerStats(ctx, "unknown"); !errors.Is(err, ErrWorkerNotFound) {
+		t.Fatalf("stats of an unknown worker: expected ErrWorkerNotFound, got %v", err)
+	}
+}
+
+func TestPreviewDispatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock(),
+		WithConcurrencyLimits(map[model.TaskType]int{model.TaskTypePatchApply: 1}))
+	for id, caps := range map[string][]string{"w-a": {"x86", "kdump"}, "w-b": {"x86"}, "w-c": {"x86"}, "w-d": {"arm"}} {
+		if _, err := s.Heartbeat(ctx, id, caps); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+	if _, err := s.SetDraining(ctx, "w-d", true); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	// w-c is busy and patch_apply is at its limit, one task is already waiting
+	if err := s.Submit(ctx, &model.Task{Type: model.TaskTypePatchApply}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if task, err := s.Claim(ctx, "w-c"); err != nil || task == nil {
+		t.Fatalf("claim: %v %v", task, err)
+	}
+	queued := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, queued); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	high, higher := 10, 20
+	tasks := []ProspectiveTask{
+		{Type: model.TaskTypeGetVmcore, Priority: &high, RequiredCapabilities: []string{"kdump"}},
+		{Type: model.TaskTypeGetVmcore},
+		{Type: model.TaskTypePatchApply, RequiredCapabilities: []string{"arm"}},
+		{Type: model.TaskTypePatchApply, Priority: &higher},
+	}
+	want := []Placement{
+		{Index: 0, QueuePosition: 2, Satisfiable: true, WorkerID: "w-a"},
+		// w-b takes the task queued before it
+		{Index: 1, QueuePosition: 4, Satisfiable: true, WaitReason: WaitWorkersBusy},
+		{Index: 2, QueuePosition: 5, WaitReason: WaitNoCapableWorker},
+		{Index: 3, QueuePosition: 1, Satisfiable: true, WaitReason: WaitConcurrencyLimit},
+	}
+	for range 2 {
+		preview, err := s.PreviewDispatch(ctx, tasks)
+		if err != nil {
+			t.Fatalf("preview: %v", err)
+		}
+		if preview.AvailableWorkers != 3 || preview.IdleWorkers != 2 || !slices.Equal(preview.Placements, want) {
+			t.Fatalf("unexpected preview %+v", preview)
+		}
+	}
+	if got, _ := s.Get(ctx, queued.ID); got.Status != model.StatusPending {
+		t.Fatalf("preview changed the queue: %+v", got)
+	}
+	if _, err := s.PreviewDispatch(ctx, []ProspectiveTask{{Type: "reboot"}}); !errors.Is(err, ErrInvalidTask) {
+		t.Fatalf("unknown type: expected ErrInvalidTask, got %v", err)
+	}
+}
+
+func TestPreviewDispatchAppliesTaskTypeDefaults(t *testing.T) {
+	ctx := context.Background()
+	priority := 7
+	types, err := NewTaskTypes(map[string]config.TaskTypeConfig{
+		string(model.TaskTypePatchApply): {Priority: &priority, RequiredCapabilities: []string{"kpatch"}},
+	})
+	if err != nil {
+		t.Fatalf("task types: %v", err)
+	}
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock(), WithTaskTypes(types))
+	for id, caps := range map[string][]string{"w-a": {"x86"}, "w-b": {"x86", "kpatch"}} {
+		if _, err := s.Heartbeat(ctx, id, caps); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+	// no worker can take it, it only holds a place in the queue
+	if err := s.Submit(ctx, &model.Task{Type: model.TaskTypeGetVmcore, Priority: 5, RequiredCapabilities: []string{"arm"}}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	low := 0
+	preview, err := s.PreviewDispatch(ctx, []ProspectiveTask{
+		{Type: model.TaskTypePatchApply},
+		{Type: model.TaskTypePatchApply, RequiredCapabilities: []string{}},
+		{Type: model.TaskTypePatchApply, Priority: &low},
+	})
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	want := []Placement{
+		// takes the default priority ahead of the queued task and needs kpatch, which only w-b has
+		{Index: 0, QueuePosition: 1, Satisfiable: true, WorkerID: "w-b"},
+		// an empty list asks for no capabilities at all
+		{Index: 1, QueuePosition: 2, Satisfiable: true, WorkerID: "w-a"},
+		{Index: 2, QueuePosition: 4, Satisfiable: true, WaitReason: WaitWorkersBusy},
+	}
+	if !slices.Equal(preview.Placements, want) {
+		t.Fatalf("placements %+v, want %+v", preview.Placements, want)
+	}
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := artifact.NewMemory()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock, WithArtifactStore(store))
+
+	put := func(task *model.Task, name string) (string, int64, string) {
+		t.Helper()
+		key := artifact.Key(task.ID, name)
+		size, sum, err := store.Put(ctx, key, strings.NewReader(name+" of "+task.ID))
+		if err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		return key, size, sum
+	}
+	// start runs a task with an uploaded vmcore and a build log
+	start := func(deps ...string) *model.Task {
+		t.Helper()
+		task := &model.Task{Type: model.TaskTypeGetVmcore, DependsOn: deps}
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		if claimed, err := s.Claim(ctx, "worker-1"); err != nil || claimed == nil || claimed.ID != task.ID {
+			t.Fatalf("claim: %v %v", claimed, err)
+		}
+		key, size, _ := put(task, "build.log")
+		if err := s.AddArtifact(ctx, "worker-1", &model.Artifact{TaskID: task.ID, Name: "build.log", Path: key, Size: size, StoredSize: size}); err != nil {
+			t.Fatalf("add artifact: %v", err)
+		}
+		return task
+	}
+	finish := func(task *model.Task) {
+		t.Helper()
+		_, size, sum := put(task, "vmcore")
+		r := Report{WorkerID: "worker-1", Status: model.StatusSuccess, ArtifactName: "vmcore", ArtifactSize: size, ArtifactSHA256: sum}
+		if got, err := s.Report(ctx, task.ID, r); err != nil || got.Status != model.StatusSuccess {
+			t.Fatalf("report: %+v %v", got, err)
+		}
+	}
+	exists := func(task *model.Task, name string) bool {
+		_, err := store.Stat(ctx, artifact.Key(task.ID, name))
+		return err == nil
+	}
+
+	old := start()
+	finish(old)
+	needed := start()
+	finish(needed)
+	// no worker can run it, so it keeps waiting and needed's artifacts must stay
+	dependent := &model.Task{Type: model.TaskTypePatchApply, DependsOn: []string{needed.ID}, RequiredCapabilities: []string{"arm"}}
+	if err := s.Submit(ctx, dependent); err != nil {
+		t.Fatalf("submit dependent: %v", err)
+	}
+	clock.Advance(48 * time.Hour)
+	if _, err := s.Archive(ctx, 24*time.Hour); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	running := start()
+	recent := start()
+	finish(recent)
+
+	gc, err := s.CollectArtifacts(ctx, 24*time.Hour, true)
+	if err != nil || gc != (ArtifactGC{Tasks: 1, Files: 2, Bytes: gc.Bytes, Skipped: 1}) || gc.Bytes == 0 {
+		t.Fatalf("dry run: %+v %v", gc, err)
+	}
+	if !exists(old, "vmcore") || !exists(old, "build.log") {
+		t.Fatalf("dry run deleted files")
+	}
+
+	for i, want := range []ArtifactGC{{Tasks: 1, Files: 2, Bytes: gc.Bytes, Skipped: 1}, {Skipped: 1}} {
+		if got, err := s.CollectArtifacts(ctx, 24*time.Hour, false); err != nil || got != want {
+			t.Fatalf("run %d: %+v %v", i, got, err)
+		}
+	}
+	if exists(old, "vmcore") || exists(old, "build.log") {
+		t.Fatalf("artifacts of the old task were kept")
+	}
+	got, err := s.Get(ctx, old.ID)
+	if err != nil || got.ArtifactGCedAt == nil || got.ArtifactPath != "" || got.ArtifactName != "vmcore" || len(got.Artifacts) != 0 || got.PrimaryArtifact() != nil {
+		t.Fatalf("unexpected task after gc: %+v %v", got, err)
+	}
+	for _, task := range []*model.Task{needed, running, recent} {
+		if !exists(task, "build.log") {
+			t.Fatalf("artifacts of %s were deleted", task.ID)
+		}
+	}
+}
+
+func TestCollectArtifactsKeepsPatchInputs(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := artifact.NewMemory()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock, WithArtifactStore(store))
+
+	source := &model.Task{Type: model.TaskTypePatchApply}
+	if err := s.Submit(ctx, source); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := s.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	key := artifact.Key(source.ID, "0001-fix.patch")
+	size, sum, err := store.Put(ctx, key, strings.NewReader("diff --git"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	r := Report{WorkerID: "worker-1", Status: model.StatusSuccess, ArtifactName: "0001-fix.patch", ArtifactSize: size, ArtifactSHA256: sum}
+	if _, err := s.Report(ctx, source.ID, r); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+
+	// takes the patch by artifact id without depending on its task, no worker can run it
+	consumer := &model.Task{
+		Type:                 model.TaskTypePatchApply,
+		Payload:              &model.TaskPayload{PatchApply: &model.PatchApplyPayload{TargetKernel: "6.1.0", Patches: []model.PatchRef{{ArtifactID: source.ID}}}},
+		RequiredCapabilities: []string{"arm"},
+	}
+	if err := s.Submit(ctx, consumer); err != nil {
+		t.Fatalf("submit consumer: %v", err)
+	}
+	clock.Advance(48 * time.Hour)
+
+	if gc, err := s.CollectArtifacts(ctx, 24*time.Hour, false); err != nil || gc != (ArtifactGC{Skipped: 1}) {
+		t.Fatalf("patch input should be kept while its consumer waits: %+v %v", gc, err)
+	}
+	if _, err := store.Stat(ctx, key); err != nil {
+		t.Fatalf("patch input was deleted: %v", err)
+	}
+
+	if _, err := s.Cancel(ctx, consumer.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if gc, err := s.CollectArtifacts(ctx, 24*time.Hour, false); err != nil || gc.Tasks != 1 || gc.Skipped != 0 {
+		t.Fatalf("patch input should go once its consumer is done: %+v %v", gc, err)
+	}
+}
+
+// hookedDeletes runs a hook in place of the Delete of the store it wraps
+type hookedDeletes struct {
+	artifact.Store
+	delete func(ctx context.Context, key string) error
+}
+
+func (h hookedDeletes) Delete(ctx context.Context, key string) error { return h.delete(ctx, key) }
+
+func TestCollectArtifactsDeletesFilesAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	memory := artifact.NewMemory()
+	var s *Scheduler
+	var deleted []string
+	store := hookedDeletes{Store: memory, delete: func(ctx context.Context, key string) error {
+		// the task is already marked collected when its files go
+		var task model.Task
+		if err := s.db.Unscoped().First(&task, "id = ?", strings.Split(key, "/")[0]).Error; err != nil || task.ArtifactGCedAt == nil {
+			t.Errorf("file %s deleted before the task was marked collected: %+v %v", key, task, err)
+		}
+		deleted = append(deleted, key)
+		return errors.New("bucket unavailable")
+	}}
+	s = newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock, WithArtifactStore(store))
+
+	task := &model.Task{Type: model.TaskTypeGetVmcore}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := s.Claim(ctx, "worker-1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	key := artifact.Key(task.ID, "vmcore")
+	size, sum, err := memory.Put(ctx, key, strings.NewReader("vmcore"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := s.Report(ctx, task.ID, Report{WorkerID: "worker-1", Status: model.StatusSuccess, ArtifactName: "vmcore", ArtifactSize: size, ArtifactSHA256: sum}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	clock.Advance(48 * time.Hour)
+
+	if _, err := s.CollectArtifacts(ctx, 24*time.Hour, false); err == nil || len(deleted) != 1 || deleted[0] != key {
+		t.Fatalf("a failed deletion should be reported: %v %v", deleted, err)
+	}
+	if got, err := s.Get(ctx, task.ID); err != nil || got.ArtifactGCedAt == nil || got.ArtifactPath != "" {
+		t.Fatalf("the task should stay collected: %+v %v", got, err)
+	}
+	// a later run does not pick the task up again
+	if gc, err := s.CollectArtifacts(ctx, 24*time.Hour, false); err != nil || gc != (ArtifactGC{}) || len(deleted) != 1 {
+		t.Fatalf("second run: %+v %v %v", gc, deleted, err)
+	}
+}
+
+func TestTaskTypeDefaultsFillOnlyUnsetFields(t *testing.T) {
+	ctx := context.Background()
+	priority := 7
+	types, err := NewTaskTypes(map[string]config.TaskTypeConfig{
+		string(model.TaskTypePatchApply): {MaxAttempts: 4, Priority: &priority, RequiredCapabilities: []string{"kpatch"}},
+	})
+	if err != nil {
+		t.Fatalf("task types: %v", err)
+	}
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 2}, newFakeClock(), WithTaskTypes(types))
+
+	unset := &model.Task{Type: model.TaskTypePatchApply}
+	set := &model.Task{Type: model.TaskTypePatchApply, MaxAttempts: 1, TimeoutSeconds: 60, RequiredCapabilities: model.StringList{}}
+	other := &model.Task{Type: model.TaskTypeGetVmcore}
+	for _, task := range []*model.Task{unset, set, other} {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	// the timeout was left out of the entry and keeps the built-in default
+	if unset.MaxAttempts != 4 || unset.TimeoutSeconds != 30*60 || !slices.Equal(unset.RequiredCapabilities, model.StringList{"kpatch"}) {
+		t.Fatalf("defaults not applied: %+v", unset)
+	}
+	if set.MaxAttempts != 1 || set.TimeoutSeconds != 60 || len(set.RequiredCapabilities) != 0 {
+		t.Fatalf("defaults overrode set fields: %+v", set)
+	}
+	if other.MaxAttempts != 2 || other.TimeoutSeconds != 2*60*60 || len(other.RequiredCapabilities) != 0 {
+		t.Fatalf("unexpected defaults of another type: %+v", other)
+	}
+	if got := s.TaskType(model.TaskTypePatchApply); got.Priority != 7 || got.MaxAttempts != 4 {
+		t.Fatalf("unexpected patch-apply defaults %+v", got)
+	}
+
+	if _, err := NewTaskTypes(map[string]config.TaskTypeConfig{"reboot": {}}); err == nil {
+		t.Fatalf("defaults for an unknown type were accepted")
+	}
+}
+
+func TestCloseWaitsForOpenTransactions(t *testing.T) {
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+	ctx := context.Background()
+	inside, release := make(chan struct{}), make(chan struct{})
+	written := make(chan error, 1)
+	go func() {
+		written <- s.inTx(ctx, func(t *txn) error {
+			close(inside)
+			<-release
+			return t.db.Create(&model.Task{Type: model.TaskTypePatchApply, Status: model.StatusPending}).Error
+		})
+	}()
+	<-inside
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := s.Close(short); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "1 writes") {
+		t.Fatalf("expected Close to time out on the open transaction, got %v", err)
+	}
+	if err := s.Submit(ctx, &model.Task{Type: model.TaskTypePatchApply}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected new writes to be refused once closing, got %v", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close(ctx) }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned with a transaction open: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-written; err != nil {
+		t.Fatalf("open transaction: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	var n int64
+	s.db.Model(&model.Task{}).Count(&n)
+	if n != 1 {
+		t.Fatalf("the open transaction should have committed, found %d tasks", n)
+	}
+}
+
+func TestCloseWaitsForWritesOutsideTransactions(t *testing.T) {
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, newFakeClock())
+	ctx := context.Background()
+	// holds the heartbeat upsert right before it reaches the database
+	inside, release := make(chan struct{}), make(chan struct{})
+	err := s.db.Callback().Create().Before("gorm:create").Register("test:hold_heartbeat", func(db *gorm.DB) {
+		if db.Statement.Table == "workers" {
+			close(inside)
+			<-release
+		}
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	beat := make(chan error, 1)
+	go func() {
+		_, err := s.Heartbeat(ctx, "worker-1", []string{"x86"})
+		beat <- err
+	}()
+	<-inside
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close(ctx) }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned with a heartbeat in progress: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-beat; err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := s.SetDraining(ctx, "worker-1", true); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected writes to be refused once closed, got %v", err)
+	}
+}
+
+func TestAuthenticateWorkerThrottlesLastUsed(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := newTestScheduler(t, RetryPolicy{MaxAttempts: 1}, clock)
+	token, _, err := s.IssueToken(ctx, "worker-1", nil)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	lastUsed := func() time.Time {
+		t.Helper()
+		var wt model.WorkerToken
+		if err := s.db.First(&wt, "worker_id = ?", "worker-1").Error; err != nil || wt.LastUsedAt == nil {
+			t.Fatalf("token: %+v %v", wt, err)
+		}
+		return *wt.LastUsedAt
+	}
+
+	if id, err := s.AuthenticateWorker(ctx, token); err != nil || id != "worker-1" {
+		t.Fatalf("authenticate: %q %v", id, err)
+	}
+	first := clock.Now()
+	clock.Advance(30 * time.Second)
+	if _, err := s.AuthenticateWorker(ctx, token); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if got := lastUsed(); !got.Equal(first) {
+		t.Fatalf("use within the interval should not be written, last used %v, want %v", got, first)
+	}
+	clock.Advance(tokenTouchInterval)
+	if _, err := s.AuthenticateWorker(ctx, token); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if got := lastUsed(); !got.Equal(clock.Now()) {
+		t.Fatalf("last used should move once the interval passed, got %v, want %v", got, clock.Now())
+	}
+}
@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrClosed means the scheduler is shutting down and takes no more writes
+var ErrClosed = errors.New("scheduler is shutting down")
+
+// writes counts the transactions and other writes in progress so shutdown can wait for them
+type writes struct {
+	mu     sync.Mutex
+	open   int
+	closed bool
+	// closed by the last write to end once closing
+	idle chan struct{}
+}
+
+func (w *writes) begin() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.open++
+	return nil
+}
+
+func (w *writes) end() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.open--
+	if w.closed && w.open == 0 {
+		close(w.idle)
+	}
+}
+
+// write runs a write made outside inTx, it is counted like a transaction so Close waits
+// for it too
+func (s *Scheduler) write(fn func() error) error {
+	if err := s.writes.begin(); err != nil {
+		return err
+	}
+	defer s.writes.end()
+	return fn()
+}
+
+// Close refuses new writes with ErrClosed and waits for the ones in progress to commit or
+// roll back, so the database can be closed without cutting a write short. It gives up
+// when ctx is done.
+func (s *Scheduler) Close(ctx context.Context) error {
+	w := &s.writes
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		w.idle = make(chan struct{})
+		if w.open == 0 {
+			close(w.idle)
+		}
+	}
+	idle := w.idle
+	w.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		w.mu.Lock()
+		open := w.open
+		w.mu.Unlock()
+		return fmt.Errorf("%d writes still in progress: %w", open, ctx.Err())
+	}
+}
@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// how far back the rolling worker stats look unless WithStatsWindow says otherwise
+const defaultStatsWindow = 24 * time.Hour
+
+// WithStatsWindow sets the rolling window of the worker stats
+func WithStatsWindow(d time.Duration) Option {
+	return func(s *Scheduler) {
+		if d > 0 {
+			s.statsWindow = d
+		}
+	}
+}
+
+// endRunAs sets the outcome of the run the next transition ends, for endings the new
+// status alone does not tell apart like a reclaim from a failed report
+func (t *txn) endRunAs(outcome model.RunOutcome) {
+	t.outcome = outcome
+}
+
+// endRun records the run of ended on its worker, task is the state it moved on to. It is
+// written in the transaction of the transition, so a run is counted exactly when it ends.
+func (t *txn) endRun(ended, task *model.Task) error {
+	outcome := t.outcome
+	t.outcome = ""
+	if outcome == "" {
+		switch task.Status {
+		case model.StatusSuccess:
+			outcome = model.RunSucceeded
+		case model.StatusCancelled:
+			outcome = model.RunCancelled
+		default:
+			outcome = model.RunFailed
+		}
+	}
+	run := &model.WorkerRun{
+		TaskID:     ended.ID,
+		Attempt:    ended.Attempts,
+		WorkerID:   ended.WorkerID,
+		Outcome:    outcome,
+		StartedAt:  t.now,
+		FinishedAt: t.now,
+	}
+	// tasks claimed before StartedAt was tracked count no busy time
+	if ended.StartedAt != nil {
+		run.StartedAt = *ended.StartedAt
+		run.BusyMillis = max(t.now.Sub(run.StartedAt).Milliseconds(), 0)
+	}
+	return t.db.Create(run).Error
+}
+
+// RunCounts sums up the runs of a worker
+type RunCounts struct {
+	Total    int64                      `json:"total"`
+	Outcomes map[model.RunOutcome]int64 `json:"outcomes"`
+	// time spent on tasks, including the ones still running
+	BusySeconds float64 `json:"busy_seconds"`
+}
+
+func newRunCounts() RunCounts {
+	c := RunCounts{Outcomes: make(map[model.RunOutcome]int64, len(model.RunOutcomes))}
+	for _, o := range model.RunOutcomes {
+		c.Outcomes[o] = 0
+	}
+	return c
+}
+
+// WorkerStats is how much work a worker did over its lifetime and the rolling window
+type WorkerStats struct {
+	WorkerID      string    `json:"worker_id"`
+	Running       int64     `json:"running"`
+	WindowSeconds int64     `json:"window_seconds"`
+	Lifetime      RunCounts `json:"lifetime"`
+	Window        RunCounts `json:"window"`
+}
+
+// WorkerStats counts the runs of a worker by outcome. A task reclaimed from one worker and
+// finished by another counts as reclaimed for the first and by its outcome for the second.
+func (s *Scheduler) WorkerStats(ctx context.Context, workerID string) (*WorkerStats, error) {
+	db := s.db.WithContext(ctx)
+	err := db.Select("id").First(&model.Worker{}, "id = ?", workerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrWorkerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	since := now.Add(-s.statsWindow)
+	stats := &WorkerStats{
+		WorkerID:      workerID,
+		WindowSeconds: int64(s.statsWindow / time.Second),
+		Lifetime:      newRunCounts(),
+		Window:        newRunCounts(),
+	}
+
+	var lifetime []struct {
+		Outcome    model.RunOutcome
+		Count      int64
+		BusyMillis int64
+	}
+	err = db.Model(&model.WorkerRun{}).
+		Select("outcome, COUNT(*) AS count, COALESCE(SUM(busy_millis), 0) AS busy_millis").
+		Where("worker_id = ?", workerID).
+		Group("outcome").
+		Scan(&lifetime).Error
+	if err != nil {
+		return nil, err
+	}
+	var busy time.Duration
+	for _, row := range lifetime {
+		stats.Lifetime.Outcomes[row.Outcome] = row.Count
+		stats.Lifetime.Total += row.Count
+		busy += time.Duration(row.BusyMillis) * time.Millisecond
+	}
+
+	// runs are loaded one by one so the part of a run started before the window is left out
+	var recent []model.WorkerRun
+	err = db.Select("outcome, started_at, finished_at").
+		Where("worker_id = ? AND finished_at >= ?", workerID, since).
+		Find(&recent).Error
+	if err != nil {
+		return nil, err
+	}
+	var windowBusy time.Duration
+	for _, run := range recent {
+		stats.Window.Outcomes[run.Outcome]++
+		stats.Window.Total++
+		windowBusy += run.FinishedAt.Sub(later(run.StartedAt, since))
+	}
+
+	var running []model.Task
+	err = db.Select("started_at").
+		Where("worker_id = ? AND status = ?", workerID, model.StatusRunning).
+		Find(&running).Error
+	if err != nil {
+		return nil, err
+	}
+	stats.Running = int64(len(running))
+	for _, task := range running {
+		if task.StartedAt == nil {
+			continue
+		}
+		busy += max(now.Sub(*task.StartedAt), 0)
+		windowBusy += max(now.Sub(later(*task.StartedAt, since)), 0)
+	}
+	stats.Lifetime.BusySeconds = busy.Seconds()
+	stats.Window.BusySeconds = windowBusy.Seconds()
+	return stats, nil
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
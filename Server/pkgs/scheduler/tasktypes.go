@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"fmt"
+	"slices"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+)
+
+// TaskTypeConfig holds the defaults of a task type, a submitted task takes them for every
+// field it leaves unset
+type TaskTypeConfig struct {
+	Type model.TaskType `json:"type"`
+	// zero means no timeout
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// zero falls back to the retry policy
+	MaxAttempts          int      `json:"max_attempts"`
+	Priority             int      `json:"priority"`
+	RequiredCapabilities []string `json:"required_capabilities"`
+	// timed out attempts are retried and then dead-lettered, off fails the task outright
+	RetryOnTimeout bool `json:"retry_on_timeout"`
+}
+
+// builtinTaskTypes registers every task type, a new type needs an entry here and a payload
+// validator. vmcore pulls go over the network to a crashed machine and take far longer
+// than patch applies.
+var builtinTaskTypes = []TaskTypeConfig{
+	{Type: model.TaskTypeGetVmcore, TimeoutSeconds: 2 * 60 * 60},
+	{Type: model.TaskTypePatchApply, TimeoutSeconds: 30 * 60},
+}
+
+// NewTaskTypes reads the per type defaults from the config on top of the built-in ones,
+// rejecting unknown types. Keys an entry leaves out keep the built-in default.
+func NewTaskTypes(cfg map[string]config.TaskTypeConfig) ([]TaskTypeConfig, error) {
+	types := slices.Clone(builtinTaskTypes)
+	for name, entry := range cfg {
+		i := slices.IndexFunc(types, func(c TaskTypeConfig) bool { return c.Type == model.TaskType(name) })
+		if i < 0 {
+			return nil, fmt.Errorf("defaults for unknown task type %q", name)
+		}
+		c := &types[i]
+		if entry.TimeoutSeconds > 0 {
+			c.TimeoutSeconds = entry.TimeoutSeconds
+		}
+		if entry.MaxAttempts > 0 {
+			c.MaxAttempts = entry.MaxAttempts
+		}
+		if entry.Priority != nil {
+			c.Priority = *entry.Priority
+		}
+		if entry.RequiredCapabilities != nil {
+			c.RequiredCapabilities = entry.RequiredCapabilities
+		}
+		if entry.RetryOnTimeout != nil {
+			c.RetryOnTimeout = *entry.RetryOnTimeout
+		}
+	}
+	return types, nil
+}
+
+// WithTaskTypes replaces the defaults of the given task types
+func WithTaskTypes(types []TaskTypeConfig) Option {
+	return func(s *Scheduler) {
+		for _, c := range types {
+			s.taskTypes[c.Type] = c
+		}
+	}
+}
+
+// TaskType returns the defaults of a task type with the retry policy filled in
+func (s *Scheduler) TaskType(t model.TaskType) TaskTypeConfig {
+	c := s.taskTypes[t]
+	c.Type = t
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = max(s.retry.MaxAttempts, 1)
+	}
+	c.RequiredCapabilities = append([]string{}, c.RequiredCapabilities...)
+	return c
+}
+
+// TaskTypes lists the defaults of every task type
+func (s *Scheduler) TaskTypes() []TaskTypeConfig {
+	types := make([]TaskTypeConfig, len(model.TaskTypes))
+	for i, t := range model.TaskTypes {
+		types[i] = s.TaskType(t)
+	}
+	return types
+}
+
+// applyTaskType fills in the fields a submitted task left unset. Priority has no unset
+// value on the task, callers that know it was left out take it from TaskType.
+func (s *Scheduler) applyTaskType(task *model.Task) {
+	defaults := s.TaskType(task.Type)
+	if task.MaxAttempts <= 0 {
+		task.MaxAttempts = defaults.MaxAttempts
+	}
+	if task.TimeoutSeconds <= 0 {
+		task.TimeoutSeconds = defaults.TimeoutSeconds
+	}
+	// an empty list asks for no capabilities at all
+	if task.RequiredCapabilities == nil {
+		task.RequiredCapabilities = defaults.RequiredCapabilities
+	}
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"Server/pkgs/model"
+)
+
+const timeoutResult = "timeout exceeded"
+
+// FailTimedOut marks running tasks past StartedAt + TimeoutSeconds as failed. Types with
+// RetryOnTimeout instead fail the attempt the way a failure report does, so the task is
+// retried while attempts remain and dead-lettered after.
+func (s *Scheduler) FailTimedOut(ctx context.Context) (int64, error) {
+	now := s.now()
+
+	var running []model.Task
+	err := s.db.WithContext(ctx).Where("status = ? AND timeout_seconds > 0 AND NOT cancel_requested", model.StatusRunning).Find(&running).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var failed int64
+	var errs []error
+	for i := range running {
+		task := &running[i]
+		if task.StartedAt == nil || now.Sub(*task.StartedAt).Seconds() < float64(task.TimeoutSeconds) {
+			continue
+		}
+		updates := map[string]any{"result": timeoutResult}
+		if s.TaskType(task.Type).RetryOnTimeout {
+			s.failAttempt(updates, task, timeoutResult, now)
+		} else {
+			updates["status"] = model.StatusFailed
+			updates["finished_at"] = now
+			updates["attempt_history"] = append(task.AttemptHistory, model.Attempt{
+				Attempt:    task.Attempts,
+				WorkerID:   task.WorkerID,
+				Error:      timeoutResult,
+				StartedAt:  task.StartedAt,
+				FinishedAt: now,
+			})
+		}
+		err := s.inTx(ctx, func(t *txn) error {
+			t.endRunAs(model.RunTimedOut)
+			ok, err := s.transition(t, task, updates)
+			if ok {
+				failed++
+			}
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", task.ID, err))
+		}
+	}
+	return failed, errors.Join(errs...)
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+)
+
+// the last use of a token is recorded at most this often, workers authenticate on every request
+const tokenTouchInterval = time.Minute
+
+// IssueToken registers the worker and hands out a new token for it. The token is only
+// returned here, the database keeps its hash.
+func (s *Scheduler) IssueToken(ctx context.Context, workerID string, capabilities []string) (string, *model.Worker, error) {
+	worker, err := s.Heartbeat(ctx, workerID, capabilities)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(raw)
+	err = s.write(func() error {
+		return s.db.WithContext(ctx).Create(&model.WorkerToken{WorkerID: workerID, TokenHash: hashToken(token), CreatedAt: s.now()}).Error
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, worker, nil
+}
+
+// AuthenticateWorker resolves a token to the worker it was issued to
+func (s *Scheduler) AuthenticateWorker(ctx context.Context, token string) (string, error) {
+	var wt model.WorkerToken
+	err := s.db.WithContext(ctx).First(&wt, "token_hash = ?", hashToken(token)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if now := s.now(); wt.LastUsedAt == nil || now.Sub(*wt.LastUsedAt) >= tokenTouchInterval {
+		err := s.write(func() error { return s.db.WithContext(ctx).Model(&wt).Update("last_used_at", now).Error })
+		if err != nil {
+			return "", err
+		}
+	}
+	return wt.WorkerID, nil
+}
+
+// RevokeTokens invalidates every token of a worker, e.g. when its host is retired
+func (s *Scheduler) RevokeTokens(ctx context.Context, workerID string) (int64, error) {
+	var revoked int64
+	err := s.write(func() error {
+		res := s.db.WithContext(ctx).Where("worker_id = ?", workerID).Delete(&model.WorkerToken{})
+		revoked = res.RowsAffected
+		return res.Error
+	})
+	return revoked, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
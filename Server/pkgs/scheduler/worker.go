@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+
+	"Server/pkgs/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Heartbeat registers the worker on first contact and refreshes its last-seen time,
+// capabilities are replaced unless nil
+func (s *Scheduler) Heartbeat(ctx context.Context, workerID string, capabilities []string) (*model.Worker, error) {
+	now := s.now()
+	worker := &model.Worker{ID: workerID, Capabilities: capabilities, LastSeenAt: now, CreatedAt: now}
+	columns := []string{"last_seen_at"}
+	if capabilities != nil {
+		columns = append(columns, "capabilities")
+	}
+	err := s.write(func() error {
+		return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).Create(worker).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.getWorker(ctx, workerID)
+}
+
+// getWorker loads a worker, unknown workers come back as a bare record without capabilities
+func (s *Scheduler) getWorker(ctx context.Context, workerID string) (*model.Worker, error) {
+	var worker model.Worker
+	err := s.db.WithContext(ctx).First(&worker, "id = ?", workerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &model.Worker{ID: workerID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &worker, nil
+}
+
+// SetDraining stops or resumes handing new tasks to a worker, tasks it already runs are unaffected
+func (s *Scheduler) SetDraining(ctx context.Context, workerID string, draining bool) (*model.Worker, error) {
+	var res *gorm.DB
+	err := s.write(func() error {
+		res = s.db.WithContext(ctx).Model(&model.Worker{}).Where("id = ?", workerID).Update("draining", draining)
+		return res.Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrWorkerNotFound
+	}
+	return s.getWorker(ctx, workerID)
+}
+
+// ListWorkers returns every known worker, most recently seen first
+func (s *Scheduler) ListWorkers(ctx context.Context) ([]model.Worker, error) {
+	workers := []model.Worker{}
+	err := s.db.WithContext(ctx).Order("last_seen_at DESC, id ASC").Find(&workers).Error
+	return workers, err
+}
+
+// RunningByWorker counts running tasks per worker id
+func (s *Scheduler) RunningByWorker(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		WorkerID string
+		Count    int64
+	}
+	err := s.db.WithContext(ctx).Model(&model.Task{}).
+		Select("worker_id, COUNT(*) AS count").
+		Where("status = ?", model.StatusRunning).
+		Group("worker_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.WorkerID] = row.Count
+	}
+	return counts, nil
+}
+
+func (s *Scheduler) CountWorkers(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.WithContext(ctx).Model(&model.Worker{}).Count(&n).Error
+	return n, err
+}
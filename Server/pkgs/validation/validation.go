@@ -0,0 +1,99 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+)
+
+// DefaultMaxPayloadBytes bounds the serialized payload of types without a configured limit
+const DefaultMaxPayloadBytes = 64 << 10
+
+// Validator checks the payload of one task type beyond its shape. Field errors go into
+// a *model.ValidationError, any other error rejects the payload as a whole.
+type Validator func(p *model.TaskPayload) error
+
+// Registry holds the payload size limits and the validators of every task type, it is
+// filled at startup and read concurrently afterwards
+type Registry struct {
+	defaultMax int
+	maxBytes   map[model.TaskType]int
+	validators map[model.TaskType][]Validator
+}
+
+// New builds a registry with the size limits of cfg and the built-in validators
+func New(cfg config.PayloadConfig) (*Registry, error) {
+	r := &Registry{
+		defaultMax: DefaultMaxPayloadBytes,
+		maxBytes:   make(map[model.TaskType]int, len(cfg.MaxBytesPerType)),
+		validators: make(map[model.TaskType][]Validator),
+	}
+	if cfg.MaxBytes > 0 {
+		r.defaultMax = cfg.MaxBytes
+	}
+	for name, limit := range cfg.MaxBytesPerType {
+		taskType := model.TaskType(name)
+		if !taskType.Valid() {
+			return nil, fmt.Errorf("payload limit for unknown task type %q", name)
+		}
+		if limit > 0 {
+			r.maxBytes[taskType] = limit
+		}
+	}
+	r.Register(model.TaskTypeGetVmcore, func(p *model.TaskPayload) error { return p.GetVmcore.Validate() })
+	r.Register(model.TaskTypePatchApply, func(p *model.TaskPayload) error { return p.PatchApply.Validate() })
+	return r, nil
+}
+
+// Register adds a validator for a task type, validators run in registration order
+func (r *Registry) Register(t model.TaskType, v Validator) {
+	r.validators[t] = append(r.validators[t], v)
+}
+
+// MaxBytes is the largest serialized payload accepted for a task type
+func (r *Registry) MaxBytes(t model.TaskType) int {
+	if limit, ok := r.maxBytes[t]; ok {
+		return limit
+	}
+	return r.defaultMax
+}
+
+// Validate checks the size, the shape and then every validator of the type, a rejected
+// payload comes back as a *model.ValidationError listing all failing fields
+func (r *Registry) Validate(t model.TaskType, p *model.TaskPayload) error {
+	var errs model.ValidationError
+	if size := payloadSize(p); size > r.MaxBytes(t) {
+		// too big to be worth looking into
+		errs.Add("", "payload of %d bytes exceeds the limit of %d for %s tasks", size, r.MaxBytes(t), t)
+		return &errs
+	}
+	if err := p.CheckSection(t); err != nil {
+		return err
+	}
+	for _, v := range r.validators[t] {
+		err := v(p)
+		var fields *model.ValidationError
+		switch {
+		case err == nil:
+		case errors.As(err, &fields):
+			errs.Fields = append(errs.Fields, fields.Fields...)
+		default:
+			errs.Add("", "%v", err)
+		}
+	}
+	return errs.Err()
+}
+
+func payloadSize(p *model.TaskPayload) int {
+	if p == nil {
+		return 0
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
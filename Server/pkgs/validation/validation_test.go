@@ -0,0 +1,134 @@
+package validation
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+)
+
+func vmcorePayload() *model.TaskPayload {
+	return &model.TaskPayload{GetVmcore: &model.GetVmcorePayload{
+		TargetHost: "crash-host-01",
+		Endpoint:   "ssh://root@crash-host-01:22",
+		CrashTime:  time.Date(2025, 7, 1, 3, 4, 5, 0, time.UTC),
+		DumpPath:   "/var/crash/vmcore",
+	}}
+}
+
+func patchPayload(patches int) *model.TaskPayload {
+	p := &model.PatchApplyPayload{TargetKernel: "6.1.0"}
+	for range patches {
+		p.Patches = append(p.Patches, model.PatchRef{URL: "https://lore.kernel.org/0001-fix-the-thing.patch"})
+	}
+	return &model.TaskPayload{PatchApply: p}
+}
+
+func fieldsOf(t *testing.T, err error) []string {
+	t.Helper()
+	var invalid *model.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a *model.ValidationError, got %v", err)
+	}
+	var fields []string
+	for _, f := range invalid.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+func TestBuiltInValidators(t *testing.T) {
+	r, err := New(config.PayloadConfig{})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := r.Validate(model.TaskTypeGetVmcore, vmcorePayload()); err != nil {
+		t.Fatalf("valid vmcore payload rejected: %v", err)
+	}
+	if err := r.Validate(model.TaskTypePatchApply, patchPayload(1)); err != nil {
+		t.Fatalf("valid patch payload rejected: %v", err)
+	}
+
+	unreachable := vmcorePayload()
+	unreachable.GetVmcore.TargetHost = "0.0.0.0"
+	if got := fieldsOf(t, r.Validate(model.TaskTypeGetVmcore, unreachable)); len(got) != 1 || got[0] != "get_vmcore.target_host" {
+		t.Fatalf("unreachable host: %v", got)
+	}
+	if got := fieldsOf(t, r.Validate(model.TaskTypePatchApply, patchPayload(0))); len(got) != 1 || got[0] != "patch_apply.patches" {
+		t.Fatalf("no patches: %v", got)
+	}
+	if got := fieldsOf(t, r.Validate(model.TaskTypePatchApply, vmcorePayload())); len(got) != 1 || got[0] != "patch_apply" {
+		t.Fatalf("wrong section: %v", got)
+	}
+	if got := fieldsOf(t, r.Validate(model.TaskTypeGetVmcore, patchPayload(1))); len(got) != 1 || got[0] != "get_vmcore" {
+		t.Fatalf("patch payload for a vmcore task: %v", got)
+	}
+	if got := fieldsOf(t, r.Validate(model.TaskTypeGetVmcore, nil)); len(got) != 1 || got[0] != "get_vmcore" {
+		t.Fatalf("missing payload: %v", got)
+	}
+}
+
+func TestPayloadSizeLimits(t *testing.T) {
+	r, err := New(config.PayloadConfig{MaxBytes: 512, MaxBytesPerType: map[string]int{"patch-apply": 4096}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if r.MaxBytes(model.TaskTypeGetVmcore) != 512 || r.MaxBytes(model.TaskTypePatchApply) != 4096 {
+		t.Fatalf("limits: %d %d", r.MaxBytes(model.TaskTypeGetVmcore), r.MaxBytes(model.TaskTypePatchApply))
+	}
+	// about 4 KiB of patch urls, fine for patch-apply with its override
+	if err := r.Validate(model.TaskTypePatchApply, patchPayload(60)); err != nil {
+		t.Fatalf("payload within the override rejected: %v", err)
+	}
+	err = r.Validate(model.TaskTypePatchApply, patchPayload(100))
+	if got := fieldsOf(t, err); len(got) != 1 || got[0] != "" || !strings.Contains(err.Error(), "exceeds the limit of 4096") {
+		t.Fatalf("oversized payload: %v", err)
+	}
+
+	if _, err := New(config.PayloadConfig{MaxBytesPerType: map[string]int{"make-coffee": 1}}); err == nil {
+		t.Fatalf("limit for an unknown task type accepted")
+	}
+	if def, _ := New(config.PayloadConfig{}); def.MaxBytes(model.TaskTypeGetVmcore) != DefaultMaxPayloadBytes {
+		t.Fatalf("default limit: %d", def.MaxBytes(model.TaskTypeGetVmcore))
+	}
+}
+
+func TestRegisteredValidators(t *testing.T) {
+	r, _ := New(config.PayloadConfig{})
+	// e.g. a site policy that only pulls dumps from the lab network
+	r.Register(model.TaskTypeGetVmcore, func(p *model.TaskPayload) error {
+		var errs model.ValidationError
+		if !strings.HasSuffix(p.GetVmcore.TargetHost, ".lab.example.org") {
+			errs.Add("get_vmcore.target_host", "only lab hosts may be dumped")
+		}
+		return errs.Err()
+	})
+	r.Register(model.TaskTypeGetVmcore, func(p *model.TaskPayload) error {
+		if p.GetVmcore.CrashTime.Before(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			return errors.New("dumps from before 2020 are gone")
+		}
+		return nil
+	})
+
+	lab := vmcorePayload()
+	lab.GetVmcore.TargetHost = "crash-host-01.lab.example.org"
+	if err := r.Validate(model.TaskTypeGetVmcore, lab); err != nil {
+		t.Fatalf("lab host rejected: %v", err)
+	}
+	old := vmcorePayload()
+	old.GetVmcore.CrashTime = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	old.GetVmcore.DumpPath = "vmcore"
+	got := fieldsOf(t, r.Validate(model.TaskTypeGetVmcore, old))
+	// the built-in check, the site policy and the plain error of the second validator
+	if want := []string{"get_vmcore.dump_path", "get_vmcore.target_host", ""}; !slices.Equal(got, want) {
+		t.Fatalf("fields %v, want %v", got, want)
+	}
+	// other types are unaffected
+	if err := r.Validate(model.TaskTypePatchApply, patchPayload(1)); err != nil {
+		t.Fatalf("patch payload rejected: %v", err)
+	}
+}
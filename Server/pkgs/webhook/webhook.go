@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"Server/pkgs/config"
+	"Server/pkgs/logging"
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+// SignatureHeader carries "sha256=<hex hmac of the body>" when a secret is configured
+const SignatureHeader = "X-DumpMind-Signature"
+
+// RequestIDHeader forwards the correlation id of the api request behind a delivery
+const RequestIDHeader = "X-Request-ID"
+
+type Payload struct {
+	TaskID    string           `json:"task_id"`
+	Type      model.TaskType   `json:"type"`
+	OldStatus model.TaskStatus `json:"old_status"`
+	NewStatus model.TaskStatus `json:"new_status"`
+	Timestamp time.Time        `json:"timestamp"`
+	// correlation id of the api request behind the change, if any
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Dispatcher delivers transitions to every configured url. Each url has its own queue and
+// goroutine so a slow receiver neither blocks the scheduler nor the other receivers.
+type Dispatcher struct {
+	secret  []byte
+	client  *http.Client
+	retry   scheduler.RetryPolicy
+	targets []*target
+}
+
+type target struct {
+	url   string
+	queue chan Payload
+	// payload whose delivery Run cut short, Flush sends it first
+	interrupted *Payload
+}
+
+func New(cfg config.WebhookConfig) *Dispatcher {
+	d := &Dispatcher{
+		secret: []byte(cfg.Secret),
+		client: &http.Client{Timeout: cfg.TimeoutDuration()},
+		retry: scheduler.RetryPolicy{
+			MaxAttempts:   cfg.MaxAttempts,
+			BaseDelay:     cfg.BaseDelayDuration(),
+			BackoffFactor: 2,
+			MaxDelay:      cfg.MaxDelayDuration(),
+		},
+	}
+	for _, url := range cfg.URLs {
+		d.targets = append(d.targets, &target{url: url, queue: make(chan Payload, cfg.QueueSize)})
+	}
+	return d
+}
+
+// Notify queues a transition for delivery, it is meant to be registered as a scheduler.Listener
+func (d *Dispatcher) Notify(t scheduler.Transition) {
+	p := Payload{
+		TaskID:    t.Task.ID,
+		Type:      t.Task.Type,
+		OldStatus: t.From,
+		NewStatus: t.To,
+		Timestamp: t.At,
+		RequestID: t.RequestID,
+	}
+	for _, tg := range d.targets {
+		select {
+		case tg.queue <- p:
+		default:
+			slog.Warn("webhook: queue is full, dropping transition", append(logging.Task(&t.Task),
+				"url", tg.url, "from", p.OldStatus, "request_id", p.RequestID)...)
+		}
+	}
+}
+
+// Run delivers queued payloads until ctx is cancelled and returns once no delivery is in
+// progress, what is still queued is left for Flush
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, tg := range d.targets {
+		wg.Go(func() { d.deliverLoop(ctx, tg) })
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) deliverLoop(ctx context.Context, tg *target) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-tg.queue:
+			if !d.deliverOrGiveUp(ctx, tg, p) {
+				tg.interrupted = &p
+				return
+			}
+		}
+	}
+}
+
+// Flush delivers the payloads still queued once Run returned, dropping the rest when ctx is
+// done. Transitions notified meanwhile are delivered too.
+func (d *Dispatcher) Flush(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, tg := range d.targets {
+		wg.Go(func() {
+			if p := tg.interrupted; p != nil {
+				tg.interrupted = nil
+				if !d.deliverOrGiveUp(ctx, tg, *p) {
+					d.drop(tg)
+					return
+				}
+			}
+			for {
+				select {
+				case p := <-tg.queue:
+					if !d.deliverOrGiveUp(ctx, tg, p) {
+						d.drop(tg)
+						return
+					}
+				default:
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// deliverOrGiveUp delivers p with retries, false means ctx ended it before it was delivered
+// or given up on
+func (d *Dispatcher) deliverOrGiveUp(ctx context.Context, tg *target, p Payload) bool {
+	reqCtx := logging.WithRequestID(ctx, p.RequestID)
+	err := d.deliver(reqCtx, tg.url, p)
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(reqCtx, "webhook: giving up on delivery", "url", tg.url,
+			"task_id", p.TaskID, "task_type", p.Type, "status", p.NewStatus, "error", err)
+	}
+	return true
+}
+
+// drop logs the payload Flush ran out of time on and the ones queued behind it
+func (d *Dispatcher) drop(tg *target) {
+	slog.Warn("webhook: shutting down, dropping undelivered transitions", "url", tg.url, "count", 1+len(tg.queue))
+}
+
+// deliver posts p to url, retrying non-2xx responses with exponential backoff
+func (d *Dispatcher) deliver(ctx context.Context, url string, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	attempts := max(d.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err = d.post(ctx, url, body)
+		if err == nil || attempt >= attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.retry.Delay(attempt)):
+		}
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(d.secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex hmac-sha256 of body, receivers recompute it to verify a delivery
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Server/pkgs/config"
+	"Server/pkgs/model"
+	"Server/pkgs/scheduler"
+)
+
+func TestDeliveryIsSignedAndRetried(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan Payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != "sha256="+Sign([]byte("s3cret"), body) {
+			t.Errorf("bad signature %q", r.Header.Get(SignatureHeader))
+		}
+		if r.Header.Get(RequestIDHeader) != "req-7" {
+			t.Errorf("request id not forwarded: %q", r.Header.Get(RequestIDHeader))
+		}
+		// fail the first delivery to exercise the retry
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		received <- p
+	}))
+	defer srv.Close()
+
+	d := New(config.WebhookConfig{URLs: []string{srv.URL}, Secret: "s3cret", Timeout: 5, MaxAttempts: 3, QueueSize: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Notify(scheduler.Transition{
+		Task:      model.Task{ID: "task-1", Type: model.TaskTypePatchApply},
+		From:      model.StatusRunning,
+		To:        model.StatusSuccess,
+		At:        time.Now(),
+		RequestID: "req-7",
+	})
+
+	select {
+	case p := <-received:
+		if p.TaskID != "task-1" || p.OldStatus != model.StatusRunning || p.NewStatus != model.StatusSuccess || p.Type != model.TaskTypePatchApply || p.RequestID != "req-7" {
+			t.Fatalf("unexpected payload %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook was never delivered")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected one retry, got %d calls", calls.Load())
+	}
+}
+
+func TestFlushDeliversWhatRunLeftQueued(t *testing.T) {
+	received := make(chan Payload, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		received <- p
+	}))
+	defer srv.Close()
+
+	d := New(config.WebhookConfig{URLs: []string{srv.URL}, Timeout: 5, MaxAttempts: 1, QueueSize: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Run stopped before delivering anything
+	d.Run(ctx)
+	for _, id := range []string{"task-1", "task-2"} {
+		d.Notify(scheduler.Transition{Task: model.Task{ID: id, Type: model.TaskTypeGetVmcore}, From: model.StatusRunning, To: model.StatusFailed})
+	}
+
+	flushCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	d.Flush(flushCtx)
+	if len(received) != 2 {
+		t.Fatalf("expected both queued transitions to be delivered, got %d", len(received))
+	}
+	if p := <-received; p.TaskID != "task-1" {
+		t.Fatalf("queued transitions should be delivered in order, got %+v", p)
+	}
+}